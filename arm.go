@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// armFile is the name of the generated ARM deployment template
+const armFile = "azuredeploy.json"
+
+// armSchema is the deployment template schema for resource group deployments
+const armSchema = "https://schema.management.azure.com/schemas/2019-04-01/deploymentTemplate.json#"
+
+// renderARM converts the rendered templates into an ARM deployment template
+// with securestring parameters for secrets and outputs for each ingress FQDN
+func renderARM(config *Config, data *TemplateData) ([]byte, error) {
+	components, err := renderComponents(config, data)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &armWriter{used: make(map[string]bool)}
+
+	var resources []any
+	previous := ""
+	if config.DeployPrometheus {
+		resources = append(resources, jsonObject{
+			{"condition", armStageCondition(componentStage(components, "prometheus.yaml"))},
+			{"type", "Microsoft.App/managedEnvironments/storages"},
+			{"apiVersion", containerAppsAPIVersion},
+			{"name", armString(environmentName(config) + "/prometheus-pv")},
+			{"properties", jsonObject{
+				{"azureFile", jsonObject{
+					{"accountName", armString(config.StorageAccountName)},
+					{"accountKey", w.string(sensitiveMarker("StorageAccountKey"))},
+					{"shareName", "prometheus-data"},
+					{"accessMode", "ReadWrite"},
+				}},
+			}},
+		})
+	}
+
+	outputs := jsonObject{}
+	for i, component := range components {
+		resource := jsonObject{
+			{"condition", armStageCondition(i + 1)},
+			{"type", "Microsoft.App/containerApps"},
+			{"apiVersion", containerAppsAPIVersion},
+		}
+		for i := 0; i+1 < len(component.doc.Content); i += 2 {
+			key, value := component.doc.Content[i].Value, component.doc.Content[i+1]
+			if key == "type" {
+				continue
+			}
+			resource = append(resource, jsonMember{key, w.value(value)})
+		}
+
+		// Deploy in the same order as deploy.sh
+		var dependsOn []any
+		if previous != "" {
+			dependsOn = append(dependsOn, armResourceID("Microsoft.App/containerApps", previous))
+		}
+		if component.file == "prometheus.yaml" {
+			dependsOn = append(dependsOn, armResourceID("Microsoft.App/managedEnvironments/storages", environmentName(config), "prometheus-pv"))
+		}
+		if len(dependsOn) > 0 {
+			resource = append(resource, jsonMember{"dependsOn", dependsOn})
+		}
+		resources = append(resources, resource)
+		previous = component.name
+
+		if component.hasIngress() {
+			outputs = append(outputs, jsonMember{component.identifier() + "Fqdn", jsonObject{
+				{"condition", armStageCondition(i + 1)},
+				{"type", "string"},
+				{"value", fmt.Sprintf("[reference(%s, '%s').configuration.ingress.fqdn]",
+					strings.Trim(armResourceID("Microsoft.App/containerApps", component.name), "[]"), containerAppsAPIVersion)},
+			}})
+		}
+	}
+
+	parameters := jsonObject{}
+	for _, p := range secretParameters {
+		if !w.used[p.name()] {
+			continue
+		}
+		parameters = append(parameters, jsonMember{p.name(), jsonObject{
+			{"type", "securestring"},
+			{"metadata", jsonObject{{"description", p.description}}},
+		}})
+	}
+
+	parameters = append(parameters, jsonMember{"stage", jsonObject{
+		{"type", "int"},
+		{"defaultValue", len(components)},
+		{"minValue", 1},
+		{"maxValue", len(components)},
+		{"metadata", jsonObject{{"description", stageDescription}}},
+	}})
+
+	template := jsonObject{
+		{"$schema", armSchema},
+		{"contentVersion", "1.0.0.0"},
+		{"metadata", jsonObject{{"description", "Generated by bindplane-aca. Do not edit by hand."}}},
+		{"parameters", parameters},
+		{"resources", resources},
+		{"outputs", outputs},
+	}
+
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(template); err != nil {
+		return nil, fmt.Errorf("failed to encode ARM template: %w", err)
+	}
+
+	return out.Bytes(), nil
+}
+
+// armStageCondition returns the condition deploying a resource from the given
+// stage on
+func armStageCondition(stage int) string {
+	return fmt.Sprintf("[greaterOrEquals(parameters('stage'), %d)]", stage)
+}
+
+// armWriter converts rendered YAML into ARM template values, recording the
+// parameters referenced along the way
+type armWriter struct {
+	used map[string]bool
+}
+
+// value returns the ARM template value for node
+func (w *armWriter) value(node *yaml.Node) any {
+	return jsonValue(node, func(s string) any { return w.string(s) })
+}
+
+// jsonValue converts a rendered YAML node into a JSON value, converting each
+// string with str
+func jsonValue(node *yaml.Node, str func(string) any) any {
+	node = resolveAlias(node)
+	switch node.Kind {
+	case yaml.MappingNode:
+		object := jsonObject{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			object = append(object, jsonMember{node.Content[i].Value, jsonValue(node.Content[i+1], str)})
+		}
+		return object
+	case yaml.SequenceNode:
+		items := make([]any, 0, len(node.Content))
+		for _, item := range node.Content {
+			items = append(items, jsonValue(item, str))
+		}
+		return items
+	}
+
+	switch node.ShortTag() {
+	case "!!int", "!!float":
+		return json.Number(node.Value)
+	case "!!bool":
+		return node.Value == "true"
+	case "!!null":
+		return nil
+	default:
+		return str(node.Value)
+	}
+}
+
+// string returns s as an ARM template string, using template expressions for
+// secret parameter markers
+func (w *armWriter) string(s string) string {
+	segments := splitPlaceholders(s)
+	if len(segments) == 1 && segments[0].param == "" {
+		return armString(s)
+	}
+
+	var args []string
+	for _, segment := range segments {
+		if segment.param != "" {
+			w.used[segment.param] = true
+			args = append(args, fmt.Sprintf("parameters('%s')", segment.param))
+			continue
+		}
+		args = append(args, "'"+strings.ReplaceAll(segment.text, "'", "''")+"'")
+	}
+	if len(args) == 1 {
+		return "[" + args[0] + "]"
+	}
+	return "[concat(" + strings.Join(args, ", ") + ")]"
+}
+
+// armString returns s as a literal ARM template string. Strings starting
+// with [ would otherwise be evaluated as template expressions.
+func armString(s string) string {
+	if strings.HasPrefix(s, "[") {
+		return "[" + s
+	}
+	return s
+}
+
+// armResourceID returns a resourceId() expression for the named resource
+func armResourceID(resourceType string, names ...string) string {
+	args := []string{"'" + resourceType + "'"}
+	for _, name := range names {
+		args = append(args, "'"+strings.ReplaceAll(name, "'", "''")+"'")
+	}
+	return "[resourceId(" + strings.Join(args, ", ") + ")]"
+}
+
+// jsonObject is a JSON object that keeps its members in order
+type jsonObject []jsonMember
+
+// jsonMember is a single key and value of a jsonObject
+type jsonMember struct {
+	key   string
+	value any
+}
+
+// MarshalJSON encodes the object with its members in order
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("{")
+	for i, member := range o {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		key, err := marshalJSON(member.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := marshalJSON(member.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteString(":")
+		b.Write(value)
+	}
+	b.WriteString("}")
+	return b.Bytes(), nil
+}
+
+// marshalJSON encodes v without escaping HTML characters
+func marshalJSON(v any) ([]byte, error) {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
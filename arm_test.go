@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenderARM(t *testing.T) {
+	config := iacTestConfig(t)
+	data := iacTestData()
+
+	output, err := renderARM(config, data)
+	if err != nil {
+		t.Fatalf("Failed to render ARM template: %v", err)
+	}
+
+	compareGolden(t, filepath.Join("testdata", "arm", armFile), output)
+	assertNoSecrets(t, output, config, data)
+}
+
+func TestRenderARMParametersAndDependencies(t *testing.T) {
+	config := iacTestConfig(t)
+
+	output, err := renderARM(config, iacTestData())
+	if err != nil {
+		t.Fatalf("Failed to render ARM template: %v", err)
+	}
+
+	var template struct {
+		Parameters map[string]struct {
+			Type string `json:"type"`
+		} `json:"parameters"`
+		Resources []struct {
+			Name      string   `json:"name"`
+			DependsOn []string `json:"dependsOn"`
+		} `json:"resources"`
+	}
+	if err := json.Unmarshal(output, &template); err != nil {
+		t.Fatalf("ARM template is not valid JSON: %v", err)
+	}
+
+	for _, name := range []string{"license", "postgresPassword", "sessionSecret", "storageAccountKey"} {
+		if template.Parameters[name].Type != "securestring" {
+			t.Errorf("Expected %s to be a securestring parameter, got: %q", name, template.Parameters[name].Type)
+		}
+	}
+
+	dependsOn := make(map[string][]string)
+	for _, r := range template.Resources {
+		dependsOn[r.Name] = r.DependsOn
+	}
+	expected := map[string][]string{
+		"test-env/prometheus-pv":    nil,
+		"bindplane-transform-agent": nil,
+		"bindplane-prometheus": {
+			"[resourceId('Microsoft.App/containerApps', 'bindplane-transform-agent')]",
+			"[resourceId('Microsoft.App/managedEnvironments/storages', 'test-env', 'prometheus-pv')]",
+		},
+		"bindplane-jobs": {"[resourceId('Microsoft.App/containerApps', 'bindplane-prometheus')]"},
+		"bindplane":      {"[resourceId('Microsoft.App/containerApps', 'bindplane-jobs')]"},
+		"otelcol":        {"[resourceId('Microsoft.App/containerApps', 'bindplane')]"},
+	}
+	if !reflect.DeepEqual(dependsOn, expected) {
+		t.Errorf("dependsOn mismatch.\nExpected: %v\nGot: %v", expected, dependsOn)
+	}
+}
+
+func TestARMString(t *testing.T) {
+	w := &armWriter{used: make(map[string]bool)}
+
+	testCases := map[string]string{
+		"plain":                    "plain",
+		"[not an expression]":      "[[not an expression]",
+		sensitiveMarker("License"): "[parameters('license')]",
+		"it's:" + sensitiveMarker("PostgresPassword"): "[concat('it''s:', parameters('postgresPassword'))]",
+	}
+
+	for input, expected := range testCases {
+		if got := w.string(input); got != expected {
+			t.Errorf("string(%q) mismatch. Expected: %s, Got: %s", input, expected, got)
+		}
+	}
+}
+
+func TestARMDeploymentCommands(t *testing.T) {
+	config := iacTestConfig(t)
+
+	script := strings.Join(deploymentGroupCommands(config, armFile, "ARM template"), "\n")
+	if !strings.Contains(script, "--template-file \"$OUTPUT_DIR/azuredeploy.json\"") {
+		t.Errorf("Expected deploy script to use the ARM template, got:\n%s", script)
+	}
+	if strings.Contains(script, config.StorageAccountKey) {
+		t.Errorf("Deploy script contains the storage account key:\n%s", script)
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default endpoints of the Azure public cloud
+const (
+	defaultARMEndpoint   = "https://management.azure.com"
+	defaultAuthorityHost = "https://login.microsoftonline.com"
+	// defaultIMDSEndpoint is the Instance Metadata Service token endpoint of
+	// Azure VMs and scale sets
+	defaultIMDSEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+)
+
+// armResource is the audience of Azure Resource Manager access tokens
+const armResource = "https://management.azure.com/"
+
+// armPollInterval is how often long-running operations are polled when ARM
+// doesn't say how long to wait
+const armPollInterval = 5 * time.Second
+
+// armRetries is how many times a throttled or failed request is sent again
+const armRetries = 5
+
+// armOperationTimeout bounds each long-running operation
+const armOperationTimeout = 30 * time.Minute
+
+// tokenRefreshMargin is how long before expiry a cached token is replaced
+const tokenRefreshMargin = 5 * time.Minute
+
+// tokenSource returns access tokens for Azure Resource Manager
+type tokenSource interface {
+	token(ctx context.Context) (string, error)
+}
+
+// tokenResponse is the token endpoint response of both Entra ID and the
+// managed identity endpoints. expires_in is a number from Entra ID and a
+// string from the Instance Metadata Service. The Container Apps and App
+// Service endpoint only returns expires_on, a Unix time as a string.
+type tokenResponse struct {
+	AccessToken string          `json:"access_token"`
+	ExpiresIn   json.RawMessage `json:"expires_in"`
+	ExpiresOn   json.RawMessage `json:"expires_on"`
+}
+
+// expiry returns when the token expires, from expires_in when the response
+// has it and from expires_on otherwise
+func (r *tokenResponse) expiry(now time.Time) (time.Time, error) {
+	if len(r.ExpiresIn) > 0 {
+		seconds, err := strconv.Atoi(strings.Trim(string(r.ExpiresIn), `"`))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("token response has invalid expires_in %s", r.ExpiresIn)
+		}
+		return now.Add(time.Duration(seconds) * time.Second), nil
+	}
+	if len(r.ExpiresOn) > 0 {
+		unix, err := strconv.ParseInt(strings.Trim(string(r.ExpiresOn), `"`), 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("token response has invalid expires_on %s", r.ExpiresOn)
+		}
+		return time.Unix(unix, 0), nil
+	}
+	return time.Time{}, errors.New("token response has neither expires_in nor expires_on")
+}
+
+// cachedToken caches a token until shortly before it expires
+type cachedToken struct {
+	mu      sync.Mutex
+	value   string
+	expires time.Time
+}
+
+// get returns the cached token, fetching a new one when needed
+func (c *cachedToken) get(fetch func() (*tokenResponse, error)) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.value != "" && time.Until(c.expires) > tokenRefreshMargin {
+		return c.value, nil
+	}
+
+	response, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	if response.AccessToken == "" {
+		return "", errors.New("token response has no access_token")
+	}
+	expires, err := response.expiry(time.Now())
+	if err != nil {
+		return "", err
+	}
+	c.value, c.expires = response.AccessToken, expires
+	return c.value, nil
+}
+
+// clientCredentials gets tokens for a service principal with a client secret
+type clientCredentials struct {
+	authorityHost string
+	tenantID      string
+	clientID      string
+	clientSecret  string
+	httpClient    *http.Client
+	cache         cachedToken
+}
+
+// token returns an access token from the Entra ID token endpoint
+func (c *clientCredentials) token(ctx context.Context) (string, error) {
+	return c.cache.get(func() (*tokenResponse, error) {
+		form := url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {c.clientID},
+			"client_secret": {c.clientSecret},
+			"scope":         {armResource + ".default"},
+		}
+		endpoint := strings.TrimSuffix(c.authorityHost, "/") + "/" + url.PathEscape(c.tenantID) + "/oauth2/v2.0/token"
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return fetchToken(c.httpClient, req, "client credentials")
+	})
+}
+
+// managedIdentity gets tokens for the managed identity of the machine or
+// container running the deployer. Container Apps and App Service provide
+// their endpoint in IDENTITY_ENDPOINT and IDENTITY_HEADER; elsewhere the
+// Instance Metadata Service is used.
+type managedIdentity struct {
+	endpoint string
+	// header is the IDENTITY_HEADER secret, empty for the Instance Metadata
+	// Service
+	header string
+	// clientID selects a user-assigned identity, empty for the system-assigned one
+	clientID   string
+	httpClient *http.Client
+	cache      cachedToken
+}
+
+// newManagedIdentity returns a managed identity token source for the
+// environment the deployer runs in
+func newManagedIdentity(clientID string, httpClient *http.Client) *managedIdentity {
+	if endpoint := os.Getenv("IDENTITY_ENDPOINT"); endpoint != "" {
+		return &managedIdentity{endpoint: endpoint, header: os.Getenv("IDENTITY_HEADER"), clientID: clientID, httpClient: httpClient}
+	}
+	return &managedIdentity{endpoint: defaultIMDSEndpoint, clientID: clientID, httpClient: httpClient}
+}
+
+// token returns an access token from the managed identity endpoint
+func (m *managedIdentity) token(ctx context.Context) (string, error) {
+	return m.cache.get(func() (*tokenResponse, error) {
+		query := url.Values{"resource": {armResource}}
+		if m.header != "" {
+			query.Set("api-version", "2019-08-01")
+		} else {
+			query.Set("api-version", "2018-02-01")
+		}
+		if m.clientID != "" {
+			query.Set("client_id", m.clientID)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.endpoint+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if m.header != "" {
+			req.Header.Set("X-IDENTITY-HEADER", m.header)
+		} else {
+			req.Header.Set("Metadata", "true")
+		}
+		return fetchToken(m.httpClient, req, "managed identity")
+	})
+}
+
+// fetchToken sends a token request and decodes the response
+func fetchToken(httpClient *http.Client, req *http.Request, kind string) (*tokenResponse, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s token: %w", kind, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s token: %w", kind, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &failure) == nil && failure.Error != "" {
+			return nil, fmt.Errorf("failed to get %s token: %s: %s", kind, failure.Error, failure.Description)
+		}
+		return nil, fmt.Errorf("failed to get %s token: %s", kind, resp.Status)
+	}
+
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("failed to parse %s token: %w", kind, err)
+	}
+	return &token, nil
+}
+
+// armClient calls the Azure Resource Manager REST API
+type armClient struct {
+	// baseURL is the ARM endpoint, replaced by a local server in tests
+	baseURL      string
+	tokens       tokenSource
+	httpClient   *http.Client
+	pollInterval time.Duration
+}
+
+// armError is the error response of an ARM request or operation
+type armError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *armError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// resourceURL returns the URL of a resource or operation ID
+func (c *armClient) resourceURL(id, apiVersion string) string {
+	return strings.TrimSuffix(c.baseURL, "/") + id + "?api-version=" + url.QueryEscape(apiVersion)
+}
+
+// do sends an authenticated request and returns the response with its body
+// read. Throttled and server error responses are sent again up to armRetries
+// times, after their Retry-After. Responses other than 2xx are returned as
+// errors.
+func (c *armClient) do(ctx context.Context, method, target string, body any) (*http.Response, []byte, error) {
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			return nil, nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, content, err := c.send(ctx, method, target, encoded)
+		if resp == nil || attempt == armRetries || !retryable(resp.StatusCode) {
+			return resp, content, err
+		}
+		if err := c.sleep(ctx, resp); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// send sends one attempt of a request with the encoded body, if any
+func (c *armClient) send(ctx context.Context, method, target string, encoded []byte) (*http.Response, []byte, error) {
+	var reader io.Reader
+	if encoded != nil {
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	token, err := c.tokens.token(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if encoded != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var failure struct {
+			Error *armError `json:"error"`
+		}
+		if json.Unmarshal(content, &failure) == nil && failure.Error != nil {
+			return resp, content, fmt.Errorf("%s %s: %w", method, req.URL.Path, failure.Error)
+		}
+		return resp, content, fmt.Errorf("%s %s: %s", method, req.URL.Path, resp.Status)
+	}
+	return resp, content, nil
+}
+
+// retryable reports whether a response with the status may succeed when the
+// request is sent again
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// get reads a resource into out
+func (c *armClient) get(ctx context.Context, id, apiVersion string, out any) error {
+	_, body, err := c.do(ctx, http.MethodGet, c.resourceURL(id, apiVersion), nil)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", id, err)
+	}
+	return nil
+}
+
+// put creates or updates a resource and waits for the operation to finish
+func (c *armClient) put(ctx context.Context, id, apiVersion string, body any) error {
+	resp, _, err := c.do(ctx, http.MethodPut, c.resourceURL(id, apiVersion), body)
+	if err != nil {
+		return err
+	}
+	return c.wait(ctx, resp)
+}
+
+// post invokes an action on a resource, such as deactivating a revision, and
+// waits for the operation to finish
+func (c *armClient) post(ctx context.Context, id, apiVersion string) error {
+	resp, _, err := c.do(ctx, http.MethodPost, c.resourceURL(id, apiVersion), nil)
+	if err != nil {
+		return err
+	}
+	return c.wait(ctx, resp)
+}
+
+// delete deletes a resource and waits for the operation to finish. Deleting
+// a resource that doesn't exist succeeds.
+func (c *armClient) delete(ctx context.Context, id, apiVersion string) error {
+	resp, _, err := c.do(ctx, http.MethodDelete, c.resourceURL(id, apiVersion), nil)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.wait(ctx, resp)
+}
+
+// wait waits for the long-running operation started by resp, if any
+func (c *armClient) wait(ctx context.Context, resp *http.Response) error {
+	ctx, cancel := context.WithTimeout(ctx, armOperationTimeout)
+	defer cancel()
+
+	// Azure-AsyncOperation is preferred over Location when both are returned
+	if operation := resp.Header.Get("Azure-AsyncOperation"); operation != "" {
+		return c.waitAsyncOperation(ctx, operation, resp)
+	}
+	if location := resp.Header.Get("Location"); location != "" && resp.StatusCode == http.StatusAccepted {
+		return c.waitLocation(ctx, location, resp)
+	}
+	return nil
+}
+
+// waitAsyncOperation polls an Azure-AsyncOperation URL until the operation
+// has finished
+func (c *armClient) waitAsyncOperation(ctx context.Context, operation string, resp *http.Response) error {
+	for {
+		if err := c.sleep(ctx, resp); err != nil {
+			return err
+		}
+
+		var status struct {
+			Status string    `json:"status"`
+			Error  *armError `json:"error"`
+		}
+		var body []byte
+		var err error
+		resp, body, err = c.do(ctx, http.MethodGet, operation, nil)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(body, &status); err != nil {
+			return fmt.Errorf("failed to parse operation status: %w", err)
+		}
+
+		switch strings.ToLower(status.Status) {
+		case "succeeded":
+			return nil
+		case "failed", "canceled", "cancelled":
+			if status.Error != nil {
+				return fmt.Errorf("operation %s: %w", strings.ToLower(status.Status), status.Error)
+			}
+			return fmt.Errorf("operation %s", strings.ToLower(status.Status))
+		}
+	}
+}
+
+// waitLocation polls a Location URL until it stops returning 202 Accepted
+func (c *armClient) waitLocation(ctx context.Context, location string, resp *http.Response) error {
+	for resp.StatusCode == http.StatusAccepted {
+		if err := c.sleep(ctx, resp); err != nil {
+			return err
+		}
+		var err error
+		resp, _, err = c.do(ctx, http.MethodGet, location, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sleep waits for the Retry-After of resp, or the poll interval
+func (c *armClient) sleep(ctx context.Context, resp *http.Response) error {
+	wait := c.pollInterval
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		wait = time.Duration(seconds) * time.Second
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting for operation: %w", ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockARM is a local Azure Resource Manager and Entra ID token endpoint. It
+// accepts PUTs and DELETEs of any resource as long-running operations that finish on the
+// second poll, failing for the apps listed in failed, accepts POSTs of actions,
+// and serves container apps whose revisions are healthy unless they or their
+// app are listed in unhealthy.
+type mockARM struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// requests lists each ARM request as "METHOD path"
+	requests []string
+	// bodies holds the last body put to each path
+	bodies map[string]map[string]any
+	// polls counts the polls of each operation
+	polls map[string]int
+	// tokens counts the tokens issued
+	tokens int
+	// failed lists the apps whose operation fails
+	failed map[string]bool
+	// unhealthy lists the apps and revisions that fail
+	unhealthy map[string]bool
+	// live holds the JSON returned for an app, instead of its health status
+	live map[string]string
+	// unreadable counts down the reads of each app that fail
+	unreadable map[string]int
+}
+
+// mockARMToken is the access token the mock issues and expects
+const mockARMToken = "mock-arm-token"
+
+func newMockARM(t *testing.T) *mockARM {
+	m := &mockARM{
+		bodies:     make(map[string]map[string]any),
+		polls:      make(map[string]int),
+		failed:     make(map[string]bool),
+		unhealthy:  make(map[string]bool),
+		live:       make(map[string]string),
+		unreadable: make(map[string]int),
+	}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serve))
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockARM) serve(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/oauth2/v2.0/token"):
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("client_secret") != "test-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client","error_description":"bad secret"}`)
+			return
+		}
+		m.tokens++
+		fmt.Fprintf(w, `{"token_type":"Bearer","access_token":%q,"expires_in":3599}`, mockARMToken)
+		return
+	case r.URL.Path == "/msi/token":
+		if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("resource") != armResource {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m.tokens++
+		fmt.Fprintf(w, `{"token_type":"Bearer","access_token":%q,"expires_in":"86399"}`, mockARMToken)
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer "+mockARMToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":{"code":"AuthenticationFailed","message":"no token"}}`)
+		return
+	}
+	m.requests = append(m.requests, r.Method+" "+r.URL.Path)
+	// Polls follow Retry-After, so the operations don't slow the tests down
+	w.Header().Set("Retry-After", "0")
+
+	segments := strings.Split(r.URL.Path, "/")
+	name := segments[len(segments)-1]
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/operations/"):
+		m.polls[name]++
+		switch {
+		case m.polls[name] < 2:
+			fmt.Fprint(w, `{"status":"InProgress"}`)
+		case m.failed[name]:
+			fmt.Fprint(w, `{"status":"Failed","error":{"code":"ContainerAppOperationError","message":"image not found"}}`)
+		default:
+			fmt.Fprint(w, `{"status":"Succeeded"}`)
+		}
+	case r.URL.Query().Get("api-version") != containerAppsAPIVersion:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"code":"InvalidApiVersion","message":"unsupported api-version"}}`)
+	case r.Method == http.MethodPut:
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// Like Container Apps, an ingress without traffic keeps the traffic it had
+		if ingress, ok := mapPath(body, "properties", "configuration", "ingress"); ok && ingress["traffic"] == nil {
+			if previous, ok := mapPath(m.bodies[r.URL.Path], "properties", "configuration", "ingress"); ok && previous["traffic"] != nil {
+				ingress["traffic"] = previous["traffic"]
+			}
+		}
+		m.bodies[r.URL.Path] = body
+		w.Header().Set("Azure-AsyncOperation", m.URL+"/operations/"+name)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{}`)
+	case r.Method == http.MethodDelete:
+		if _, ok := m.bodies[r.URL.Path]; !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		delete(m.bodies, r.URL.Path)
+		w.Header().Set("Azure-AsyncOperation", m.URL+"/operations/"+name)
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPost:
+		fmt.Fprint(w, `{}`)
+	case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/revisions/"):
+		app := segments[len(segments)-3]
+		if m.unhealthy[app] || m.unhealthy[name] {
+			fmt.Fprint(w, `{"properties":{"healthState":"Unhealthy","runningState":"Failed","runningStateDetails":"container crashed","replicas":0}}`)
+			return
+		}
+		fmt.Fprint(w, `{"properties":{"healthState":"Healthy","runningState":"Running","replicas":1}}`)
+	case r.Method == http.MethodGet && m.live[name] != "":
+		fmt.Fprint(w, m.live[name])
+	case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/containerApps/") && m.unreadable[name] > 0:
+		m.unreadable[name]--
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"error":{"code":"AuthorizationFailed","message":"not authorized yet"}}`)
+	case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/containerApps/"):
+		if _, ok := m.bodies[r.URL.Path]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":"ResourceNotFound","message":"not found"}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"properties":{"latestRevisionName":%q,"latestReadyRevisionName":%q,"template":{"scale":{"minReplicas":1}}}}`, name+"--rev1", name+"--rev1")
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// mapPath returns the object at the path of keys in a decoded JSON object
+func mapPath(object map[string]any, keys ...string) (map[string]any, bool) {
+	for _, key := range keys {
+		var ok bool
+		if object, ok = object[key].(map[string]any); !ok {
+			return nil, false
+		}
+	}
+	return object, object != nil
+}
+
+// client returns a client of the mock authenticating with client credentials
+func (m *mockARM) client() *armClient {
+	return &armClient{
+		baseURL:      m.URL,
+		tokens:       &clientCredentials{authorityHost: m.URL, tenantID: "test-tenant", clientID: "test-client", clientSecret: "test-secret", httpClient: m.Client()},
+		httpClient:   m.Client(),
+		pollInterval: time.Millisecond,
+	}
+}
+
+func TestARMClientPut(t *testing.T) {
+	arm := newMockARM(t)
+	client := arm.client()
+
+	id := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.App/containerApps/app"
+	if err := client.put(context.Background(), id, containerAppsAPIVersion, jsonObject{{"location", "eastus"}}); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if arm.polls["app"] != 2 {
+		t.Errorf("Expected the operation to be polled until it succeeded, polled %d times", arm.polls["app"])
+	}
+	if got := arm.bodies[id]["location"]; got != "eastus" {
+		t.Errorf("Expected the body to be put, got location %v", got)
+	}
+
+	// The token is reused while it is valid
+	if err := client.put(context.Background(), id, containerAppsAPIVersion, jsonObject{}); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if arm.tokens != 1 {
+		t.Errorf("Expected one token, got %d", arm.tokens)
+	}
+
+	arm.failed["app"] = true
+	arm.polls["app"] = 0
+	err := client.put(context.Background(), id, containerAppsAPIVersion, jsonObject{})
+	if err == nil || !strings.Contains(err.Error(), "operation failed: ContainerAppOperationError: image not found") {
+		t.Errorf("Expected the operation error, got: %v", err)
+	}
+
+	err = client.put(context.Background(), id, "2020-01-01", jsonObject{})
+	if err == nil || !strings.Contains(err.Error(), "InvalidApiVersion: unsupported api-version") {
+		t.Errorf("Expected the ARM error, got: %v", err)
+	}
+}
+
+func TestARMClientLocation(t *testing.T) {
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			w.Header().Set("Location", "http://"+r.Host+"/status")
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			polls++
+			if polls < 3 {
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	client := &armClient{baseURL: server.URL, tokens: staticToken("token"), httpClient: server.Client(), pollInterval: time.Millisecond}
+	if err := client.put(context.Background(), "/resource", containerAppsAPIVersion, jsonObject{}); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if polls != 3 {
+		t.Errorf("Expected the Location to be polled until done, polled %d times", polls)
+	}
+}
+
+func TestARMClientRetries(t *testing.T) {
+	var bodies []string
+	statuses := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		status := http.StatusInternalServerError
+		if len(bodies) <= len(statuses) {
+			status = statuses[len(bodies)-1]
+		}
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	// Throttled and server error responses are sent again with the same body
+	client := &armClient{baseURL: server.URL, tokens: staticToken("token"), httpClient: server.Client(), pollInterval: time.Millisecond}
+	if err := client.put(context.Background(), "/resource", containerAppsAPIVersion, jsonObject{{"location", "eastus"}}); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	expected := []string{`{"location":"eastus"}`, `{"location":"eastus"}`, `{"location":"eastus"}`}
+	if !reflect.DeepEqual(bodies, expected) {
+		t.Errorf("Expected the request to be sent until it succeeded, got bodies %q", bodies)
+	}
+
+	// A server that keeps failing is given up on after armRetries
+	bodies = nil
+	statuses = nil
+	err := client.get(context.Background(), "/resource", containerAppsAPIVersion, &struct{}{})
+	if err == nil || !strings.Contains(err.Error(), "500 Internal Server Error") {
+		t.Errorf("Expected the server error, got: %v", err)
+	}
+	if len(bodies) != armRetries+1 {
+		t.Errorf("Expected %d attempts, got %d", armRetries+1, len(bodies))
+	}
+
+	// Other errors aren't sent again
+	bodies = nil
+	statuses = []int{http.StatusBadRequest}
+	if err := client.get(context.Background(), "/resource", containerAppsAPIVersion, &struct{}{}); err == nil {
+		t.Error("Expected the bad request to fail")
+	}
+	if len(bodies) != 1 {
+		t.Errorf("Expected one attempt, got %d", len(bodies))
+	}
+}
+
+// staticToken is a token source returning a fixed token
+type staticToken string
+
+func (s staticToken) token(context.Context) (string, error) {
+	return string(s), nil
+}
+
+func TestClientCredentialsError(t *testing.T) {
+	arm := newMockARM(t)
+	tokens := &clientCredentials{authorityHost: arm.URL, tenantID: "test-tenant", clientID: "test-client", clientSecret: "wrong", httpClient: arm.Client()}
+	_, err := tokens.token(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "invalid_client: bad secret") {
+		t.Errorf("Expected the token error, got: %v", err)
+	}
+}
+
+func TestManagedIdentity(t *testing.T) {
+	arm := newMockARM(t)
+
+	identity := &managedIdentity{endpoint: arm.URL + "/msi/token", clientID: "test-client", httpClient: arm.Client()}
+	token, err := identity.token(context.Background())
+	if err != nil {
+		t.Fatalf("token failed: %v", err)
+	}
+	if token != mockARMToken {
+		t.Errorf("token = %q, want %q", token, mockARMToken)
+	}
+
+	// Container Apps and App Service use a header secret instead of Metadata,
+	// and their 2019-08-01 responses only have expires_on
+	var query url.Values
+	var header string
+	var requests int
+	expiresOn := time.Now().Add(time.Hour).Unix()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, header = r.URL.Query(), r.Header.Get("X-IDENTITY-HEADER")
+		requests++
+		fmt.Fprintf(w, `{"access_token":"token","expires_on":"%d","resource":"https://management.azure.com/","token_type":"Bearer","client_id":"test-client"}`, expiresOn)
+	}))
+	defer server.Close()
+
+	t.Setenv("IDENTITY_ENDPOINT", server.URL)
+	t.Setenv("IDENTITY_HEADER", "test-header")
+	identity = newManagedIdentity("test-client", server.Client())
+	for range 2 {
+		if _, err := identity.token(context.Background()); err != nil {
+			t.Fatalf("token failed: %v", err)
+		}
+	}
+	if header != "test-header" || query.Get("api-version") != "2019-08-01" || query.Get("client_id") != "test-client" {
+		t.Errorf("Unexpected identity request: header %q, query %v", header, query)
+	}
+	if requests != 1 || !identity.cache.expires.Equal(time.Unix(expiresOn, 0)) {
+		t.Errorf("Expected one request and the token cached until expires_on, got %d requests and expiry %v", requests, identity.cache.expires)
+	}
+}
+
+func TestTokenResponseExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name     string
+		response string
+		expected time.Time
+		errorMsg string
+	}{
+		{name: "expires_in number", response: `{"expires_in":3599}`, expected: now.Add(3599 * time.Second)},
+		{name: "expires_in string", response: `{"expires_in":"86399","expires_on":"1"}`, expected: now.Add(86399 * time.Second)},
+		{name: "expires_on", response: `{"expires_on":"1700003600"}`, expected: time.Unix(1700003600, 0)},
+		{name: "invalid expires_on", response: `{"expires_on":"soon"}`, errorMsg: `invalid expires_on "soon"`},
+		{name: "neither", response: `{}`, errorMsg: "neither expires_in nor expires_on"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var response tokenResponse
+			if err := json.Unmarshal([]byte(tt.response), &response); err != nil {
+				t.Fatal(err)
+			}
+			expires, err := response.expiry(now)
+			if tt.errorMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("Expected error containing %q, got: %v", tt.errorMsg, err)
+				}
+				return
+			}
+			if err != nil || !expires.Equal(tt.expected) {
+				t.Errorf("expiry() = %v, %v, want %v", expires, err, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Authentication methods of the native deployer
+const (
+	armAuthClientSecret    = "client-secret"
+	armAuthManagedIdentity = "managed-identity"
+)
+
+// armOptions are the flags of the commands that call the ARM REST API
+type armOptions struct {
+	auth          string
+	tenantID      string
+	clientID      string
+	clientSecret  string
+	endpoint      string
+	authorityHost string
+}
+
+// registerARMFlags registers the ARM connection flags on fs
+func registerARMFlags(fs *flag.FlagSet) *armOptions {
+	o := &armOptions{}
+	fs.StringVar(&o.auth, "arm-auth", "", "How to authenticate to Azure Resource Manager: client-secret or managed-identity (default client-secret when a client secret is set, otherwise managed-identity)")
+	fs.StringVar(&o.tenantID, "arm-tenant-id", "", "Entra ID tenant of the service principal (default $AZURE_TENANT_ID)")
+	fs.StringVar(&o.clientID, "arm-client-id", "", "Client ID of the service principal, or of a user-assigned managed identity (default $AZURE_CLIENT_ID)")
+	fs.StringVar(&o.clientSecret, "arm-client-secret", "", "Client secret of the service principal (default $AZURE_CLIENT_SECRET)")
+	fs.StringVar(&o.endpoint, "arm-endpoint", defaultARMEndpoint, "Azure Resource Manager endpoint")
+	fs.StringVar(&o.authorityHost, "arm-authority-host", defaultAuthorityHost, "Entra ID endpoint used for client-secret authentication")
+	return o
+}
+
+// client returns an ARM client for the options. Unset credentials fall back
+// to the AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET variables
+// that the Azure SDKs and CLI use.
+func (o *armOptions) client() (*armClient, error) {
+	tenantID := cmp.Or(o.tenantID, os.Getenv("AZURE_TENANT_ID"))
+	clientID := cmp.Or(o.clientID, os.Getenv("AZURE_CLIENT_ID"))
+	clientSecret := cmp.Or(o.clientSecret, os.Getenv("AZURE_CLIENT_SECRET"))
+
+	if _, err := url.ParseRequestURI(o.endpoint); err != nil {
+		return nil, fmt.Errorf("invalid arm-endpoint %q: %w", o.endpoint, err)
+	}
+
+	httpClient := &http.Client{Timeout: time.Minute}
+	auth := o.auth
+	if auth == "" {
+		auth = armAuthManagedIdentity
+		if clientSecret != "" {
+			auth = armAuthClientSecret
+		}
+	}
+
+	var tokens tokenSource
+	switch auth {
+	case armAuthClientSecret:
+		var missing []string
+		for name, value := range map[string]string{"arm-tenant-id": tenantID, "arm-client-id": clientID, "arm-client-secret": clientSecret} {
+			if value == "" {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			slices.Sort(missing)
+			return nil, fmt.Errorf("missing required values for client-secret authentication: %s", strings.Join(missing, ", "))
+		}
+		tokens = &clientCredentials{authorityHost: o.authorityHost, tenantID: tenantID, clientID: clientID, clientSecret: clientSecret, httpClient: httpClient}
+	case armAuthManagedIdentity:
+		tokens = newManagedIdentity(clientID, httpClient)
+	default:
+		return nil, fmt.Errorf("invalid arm-auth %q: must be %s or %s", o.auth, armAuthClientSecret, armAuthManagedIdentity)
+	}
+
+	return &armClient{baseURL: o.endpoint, tokens: tokens, httpClient: httpClient, pollInterval: armPollInterval}, nil
+}
+
+// armDeployer deploys the container apps through the ARM REST API, in the
+// same order and with the same health checks as deploy.sh
+type armDeployer struct {
+	client *armClient
+	config *Config
+	out    io.Writer
+	// healthPoll is how often revision health is polled
+	healthPoll time.Duration
+}
+
+// newARMDeployer returns a deployer writing progress to stdout
+func newARMDeployer(client *armClient, config *Config) *armDeployer {
+	return &armDeployer{client: client, config: config, out: os.Stdout, healthPoll: healthPollSeconds * time.Second}
+}
+
+// checkNativeDeploy checks that the configuration can be deployed without the
+// Azure CLI
+func checkNativeDeploy(config *Config) error {
+	if config.DeployPrometheus && config.StorageAccountKey == "" {
+		return errors.New("the native deployer needs storage-account-key to set the Prometheus environment storage; storage-account-key-kv-uri is only supported by deploy.sh")
+	}
+	return nil
+}
+
+// deploy renders the components with their secrets and puts them, waiting
+// for each to become healthy before the next
+func (d *armDeployer) deploy(ctx context.Context, data *TemplateData) error {
+	if err := checkNativeDeploy(d.config); err != nil {
+		return err
+	}
+	components, err := renderComponentsWith(d.config, data)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(d.out, "Deploying Bindplane to Azure Container Apps through %s...\n", d.client.baseURL)
+	if d.config.DeployPrometheus {
+		if err := d.setPrometheusStorage(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, component := range components {
+		if err := d.deployApp(ctx, component); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintln(d.out, "Deployment complete!")
+	return nil
+}
+
+// setPrometheusStorage creates or updates the environment storage Prometheus
+// mounts
+func (d *armDeployer) setPrometheusStorage(ctx context.Context) error {
+	fmt.Fprintln(d.out, "Setting environment storage prometheus-pv...")
+	if err := d.client.put(ctx, prometheusStorageID(d.config), containerAppsAPIVersion, prometheusStorageBody(d.config)); err != nil {
+		return fmt.Errorf("failed to set environment storage prometheus-pv: %w", err)
+	}
+	return nil
+}
+
+// removePrometheusStorage deletes the environment storage Prometheus mounts.
+// The file share and its data are kept.
+func (d *armDeployer) removePrometheusStorage(ctx context.Context) error {
+	fmt.Fprintln(d.out, "Removing environment storage prometheus-pv...")
+	if err := d.client.delete(ctx, prometheusStorageID(d.config), containerAppsAPIVersion); err != nil {
+		return fmt.Errorf("failed to remove environment storage prometheus-pv: %w", err)
+	}
+	return nil
+}
+
+// deployApp creates or updates the container app of a component rendered
+// with its secrets and waits for it to become healthy
+func (d *armDeployer) deployApp(ctx context.Context, component *iacComponent) error {
+	id, err := containerAppID(d.config, component.name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(d.out, "Deploying container app %s...\n", component.name)
+	if err := d.client.put(ctx, id, containerAppsAPIVersion, containerAppBody(component)); err != nil {
+		return fmt.Errorf("failed to deploy container app %s: %w", component.name, err)
+	}
+	return d.waitHealthy(ctx, component.name, id)
+}
+
+// deleteApp deletes the named container app
+func (d *armDeployer) deleteApp(ctx context.Context, name string) error {
+	id, err := containerAppID(d.config, name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(d.out, "Deleting container app %s...\n", name)
+	if err := d.client.delete(ctx, id, containerAppsAPIVersion); err != nil {
+		return fmt.Errorf("failed to delete container app %s: %w", name, err)
+	}
+	return nil
+}
+
+// containerAppID returns the resource ID of the named container app in the
+// configured resource group and the subscription of the environment
+func containerAppID(config *Config, name string) (string, error) {
+	environment, err := parseResourceID(config.ACAEnvironmentID)
+	if err != nil {
+		return "", fmt.Errorf("invalid aca-environment-id: %w", err)
+	}
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.App/containerApps/%s",
+		environment.SubscriptionID, config.ResourceGroup, name), nil
+}
+
+// prometheusStorageID returns the resource ID of the Prometheus environment storage
+func prometheusStorageID(config *Config) string {
+	return config.ACAEnvironmentID + "/storages/prometheus-pv"
+}
+
+// prometheusStorageBody is the environment storage mounted by Prometheus
+func prometheusStorageBody(config *Config) jsonObject {
+	return jsonObject{
+		{"properties", jsonObject{
+			{"azureFile", jsonObject{
+				{"accountName", config.StorageAccountName},
+				{"accountKey", config.StorageAccountKey},
+				{"shareName", prometheusShare},
+				{"accessMode", "ReadWrite"},
+			}},
+		}},
+	}
+}
+
+// containerAppBody converts a rendered component into the body of a PUT. The
+// name and type come from the resource ID.
+func containerAppBody(component *iacComponent) jsonObject {
+	body := jsonObject{}
+	for i := 0; i+1 < len(component.doc.Content); i += 2 {
+		key := component.doc.Content[i].Value
+		if key == "name" || key == "type" {
+			continue
+		}
+		body = append(body, jsonMember{key, jsonValue(component.doc.Content[i+1], func(s string) any { return s })})
+	}
+	return body
+}
+
+// containerAppStatus is the part of a container app the health check reads
+type containerAppStatus struct {
+	Properties struct {
+		LatestRevisionName string `json:"latestRevisionName"`
+		Template           struct {
+			Scale struct {
+				MinReplicas *int `json:"minReplicas"`
+			} `json:"scale"`
+		} `json:"template"`
+	} `json:"properties"`
+}
+
+// revisionStatus is the part of a revision the health check reads
+type revisionStatus struct {
+	Properties struct {
+		HealthState         string `json:"healthState"`
+		RunningState        string `json:"runningState"`
+		RunningStateDetails string `json:"runningStateDetails"`
+		Replicas            int    `json:"replicas"`
+	} `json:"properties"`
+}
+
+// revisionHealth is the health of a revision and the minimum number of
+// replicas of its app
+type revisionHealth struct {
+	name        string
+	minReplicas int
+	revisionStatus
+}
+
+// healthy reports whether the revision is healthy with its minimum number of
+// replicas running
+func (h *revisionHealth) healthy() bool {
+	return h.Properties.HealthState == "Healthy" && h.Properties.Replicas >= h.minReplicas
+}
+
+// readRevisionHealth reads and prints the health of the named revision of the
+// app, or of its latest revision when revision is empty. The name of the
+// result is empty when the app has no revision yet.
+func (d *armDeployer) readRevisionHealth(ctx context.Context, name, id, revision string) (*revisionHealth, error) {
+	var app containerAppStatus
+	if err := d.client.get(ctx, id, containerAppsAPIVersion, &app); err != nil {
+		return nil, fmt.Errorf("failed to check the health of %s: %w", name, err)
+	}
+	h := &revisionHealth{name: cmp.Or(revision, app.Properties.LatestRevisionName), minReplicas: 1}
+	if app.Properties.Template.Scale.MinReplicas != nil {
+		h.minReplicas = *app.Properties.Template.Scale.MinReplicas
+	}
+	if h.name == "" {
+		return h, nil
+	}
+
+	if err := d.client.get(ctx, id+"/revisions/"+url.PathEscape(h.name), containerAppsAPIVersion, &h.revisionStatus); err != nil {
+		return nil, fmt.Errorf("failed to check the health of %s: %w", name, err)
+	}
+	p := h.Properties
+	fmt.Fprintf(d.out, "  %s: %s, %s, %d of %d replicas\n", h.name, p.HealthState, p.RunningState, p.Replicas, h.minReplicas)
+	return h, nil
+}
+
+// waitHealthy waits until the latest revision of the app is healthy with its
+// minimum number of replicas running, like wait_healthy in deploy.sh
+func (d *armDeployer) waitHealthy(ctx context.Context, name, id string) error {
+	return d.waitRevisionHealthy(ctx, name, id, "")
+}
+
+// waitRevisionHealthy waits until the named revision of the app, or its
+// latest revision when revision is empty, is healthy with the app's minimum
+// number of replicas running. Like wait_healthy in deploy.sh, a failed read
+// is printed and polled again until the timeout.
+func (d *armDeployer) waitRevisionHealthy(ctx context.Context, name, id, revision string) error {
+	timeout := d.config.HealthTimeout
+	if timeout <= 0 {
+		return nil
+	}
+	fmt.Fprintf(d.out, "Waiting up to %s for %s to become healthy...\n", timeout, cmp.Or(revision, name))
+	deadline := time.Now().Add(timeout)
+
+	var h *revisionHealth
+	var readErr error
+	for {
+		if h, readErr = d.readRevisionHealth(ctx, name, id, revision); readErr != nil {
+			fmt.Fprintf(d.out, "  %s: %v\n", name, readErr)
+		} else if h.name != "" {
+			if h.healthy() {
+				return nil
+			}
+			if h.Properties.RunningState == "Failed" {
+				break
+			}
+		}
+
+		if !time.Now().Before(deadline) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d.healthPoll):
+		}
+	}
+
+	if readErr != nil {
+		return fmt.Errorf("%s did not become healthy within %s: %w", name, timeout, readErr)
+	}
+	if h.name == "" {
+		return fmt.Errorf("%s did not become healthy within %s: it has no revision", name, timeout)
+	}
+	return fmt.Errorf("%s did not become healthy within %s: %s", name, timeout, d.unhealthyRevision(name, h))
+}
+
+// unhealthyRevision describes an unhealthy revision. ARM doesn't serve
+// console logs, so it points at the command that does.
+func (d *armDeployer) unhealthyRevision(name string, h *revisionHealth) string {
+	details := cmp.Or(h.Properties.RunningStateDetails, "no details")
+	return fmt.Sprintf("revision %s is %s (%s); see its logs with az containerapp logs show --name %s --resource-group %s --revision %s",
+		h.name, h.Properties.RunningState, details, name, d.config.ResourceGroup, h.name)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// armTestDeployer returns a deployer of the test config against arm
+func armTestDeployer(t *testing.T, arm *mockARM) (*armDeployer, *bytes.Buffer) {
+	config := iacTestConfig(t)
+	config.HealthTimeout = time.Minute
+	var out bytes.Buffer
+	return &armDeployer{client: arm.client(), config: config, out: &out, healthPoll: time.Millisecond}, &out
+}
+
+func TestARMDeploy(t *testing.T) {
+	arm := newMockARM(t)
+	deployer, out := armTestDeployer(t, arm)
+
+	if err := deployer.deploy(context.Background(), iacTestData()); err != nil {
+		t.Fatalf("deploy failed: %v\n%s", err, out)
+	}
+
+	// The storage comes first, then each app in deploy order once it's healthy
+	apps := "/subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.App/containerApps/"
+	var puts []string
+	for _, request := range arm.requests {
+		if strings.HasPrefix(request, "PUT ") {
+			puts = append(puts, strings.TrimPrefix(request, "PUT "))
+		}
+	}
+	expected := []string{
+		deployer.config.ACAEnvironmentID + "/storages/prometheus-pv",
+		apps + "bindplane-transform-agent",
+		apps + "bindplane-prometheus",
+		apps + "bindplane-jobs",
+		apps + "bindplane",
+		apps + "otelcol",
+	}
+	if !reflect.DeepEqual(puts, expected) {
+		t.Errorf("deploy put %q, want %q", puts, expected)
+	}
+	if !strings.Contains(out.String(), "bindplane--rev1: Healthy, Running, 1 of 1 replicas") {
+		t.Errorf("Expected the health of each app, got:\n%s", out)
+	}
+
+	storage := arm.bodies[expected[0]]["properties"].(map[string]any)["azureFile"].(map[string]any)
+	if storage["accountKey"] != "test-storage-key" || storage["shareName"] != prometheusShare {
+		t.Errorf("Unexpected environment storage: %v", storage)
+	}
+
+	// The apps carry their secret values, and the name and type come from the URL
+	app := arm.bodies[apps+"bindplane"]
+	if _, ok := app["type"]; ok {
+		t.Errorf("Expected no type in the body, got: %v", app)
+	}
+	secrets := app["properties"].(map[string]any)["configuration"].(map[string]any)["secrets"].([]any)
+	if license := secrets[0].(map[string]any); license["name"] != "license" || license["value"] != "test-license-key" {
+		t.Errorf("Unexpected license secret: %v", license)
+	}
+}
+
+func TestARMDeployUnhealthy(t *testing.T) {
+	arm := newMockARM(t)
+	arm.unhealthy["bindplane-jobs"] = true
+	deployer, out := armTestDeployer(t, arm)
+
+	err := deployer.deploy(context.Background(), iacTestData())
+	if err == nil || !strings.Contains(err.Error(), "bindplane-jobs did not become healthy within 1m0s: revision bindplane-jobs--rev1 is Failed (container crashed)") {
+		t.Fatalf("Expected bindplane-jobs to fail its health check, got: %v\n%s", err, out)
+	}
+	for _, request := range arm.requests {
+		if strings.HasSuffix(request, "/containerApps/bindplane") {
+			t.Errorf("Expected the deploy to stop before bindplane, got %s", request)
+		}
+	}
+}
+
+func TestARMWaitHealthyUnreadableApp(t *testing.T) {
+	arm := newMockARM(t)
+	deployer, out := armTestDeployer(t, arm)
+	id, err := containerAppID(deployer.config, "bindplane-jobs")
+	if err != nil {
+		t.Fatalf("containerAppID failed: %v", err)
+	}
+	arm.bodies[id] = map[string]any{}
+
+	// A failed read is polled again
+	arm.unreadable["bindplane-jobs"] = 2
+	if err := deployer.waitHealthy(context.Background(), "bindplane-jobs", id); err != nil {
+		t.Fatalf("Expected bindplane-jobs to become healthy, got: %v\n%s", err, out)
+	}
+	if !strings.Contains(out.String(), "bindplane-jobs: failed to check the health of bindplane-jobs") {
+		t.Errorf("Expected the failed reads to be printed, got:\n%s", out)
+	}
+
+	// Reads failing until the timeout fail with the last error
+	deployer.config.HealthTimeout = 10 * time.Millisecond
+	arm.unreadable["bindplane-jobs"] = math.MaxInt
+	err = deployer.waitHealthy(context.Background(), "bindplane-jobs", id)
+	if err == nil || !strings.Contains(err.Error(), "bindplane-jobs did not become healthy within 10ms: failed to check the health of bindplane-jobs") || !strings.Contains(err.Error(), "not authorized yet") {
+		t.Errorf("Expected the read error after the timeout, got: %v", err)
+	}
+}
+
+func TestARMOptionsClient(t *testing.T) {
+	for _, name := range []string{"AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET", "IDENTITY_ENDPOINT"} {
+		t.Setenv(name, "")
+	}
+
+	client, err := (&armOptions{endpoint: defaultARMEndpoint}).client()
+	if err != nil {
+		t.Fatalf("client failed: %v", err)
+	}
+	if _, ok := client.tokens.(*managedIdentity); !ok {
+		t.Errorf("Expected managed identity without a client secret, got %T", client.tokens)
+	}
+
+	t.Setenv("AZURE_CLIENT_SECRET", "test-secret")
+	_, err = (&armOptions{endpoint: defaultARMEndpoint}).client()
+	if err == nil || !strings.Contains(err.Error(), "arm-client-id, arm-tenant-id") {
+		t.Errorf("Expected the missing service principal values, got: %v", err)
+	}
+
+	if _, err := (&armOptions{auth: "password", endpoint: defaultARMEndpoint}).client(); err == nil {
+		t.Error("Expected an invalid arm-auth to be rejected")
+	}
+}
+
+func TestRunDeployNative(t *testing.T) {
+	ran := stubRunCommand(t)
+	arm := newMockARM(t)
+	for _, name := range []string{"AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET"} {
+		t.Setenv(name, "")
+	}
+
+	outputDir := filepath.Join(t.TempDir(), "out")
+	args := append(cliTestArgs(outputDir),
+		"-native", "-health-timeout", "0",
+		"-arm-endpoint", arm.URL, "-arm-authority-host", arm.URL,
+		"-arm-tenant-id", "test-tenant", "-arm-client-id", "test-client", "-arm-client-secret", "test-secret",
+	)
+	if err := runDeploy(args); err != nil {
+		t.Fatalf("deploy -native failed: %v", err)
+	}
+	if len(*ran) != 0 {
+		t.Errorf("Expected deploy -native not to run anything, ran %q", *ran)
+	}
+	var last string
+	for _, request := range arm.requests {
+		if strings.HasPrefix(request, "PUT ") {
+			last = request
+		}
+	}
+	if !strings.HasSuffix(last, "/containerApps/otelcol") {
+		t.Errorf("Expected otelcol to be deployed last, got %q", arm.requests)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// resourceID is a parsed Azure Resource Manager resource ID such as
+// /subscriptions/<id>/resourceGroups/<group>/providers/Microsoft.App/managedEnvironments/<name>
+type resourceID struct {
+	SubscriptionID string
+	ResourceGroup  string
+	// Provider is the resource provider namespace, e.g. Microsoft.App
+	Provider string
+	// Type is the resource type within the provider, e.g. managedEnvironments
+	Type string
+	Name string
+}
+
+// parseResourceID parses a top level ARM resource ID
+func parseResourceID(id string) (*resourceID, error) {
+	segments := strings.Split(strings.TrimPrefix(id, "/"), "/")
+	if !strings.HasPrefix(id, "/") || len(segments) != 8 {
+		return nil, errors.New("expected /subscriptions/<subscription>/resourceGroups/<group>/providers/<provider>/<type>/<name>")
+	}
+	// The fixed segments are checked in order, so the first wrong one is reported
+	fixed := []struct {
+		index int
+		want  string
+	}{{0, "subscriptions"}, {2, "resourceGroups"}, {4, "providers"}}
+	for _, segment := range fixed {
+		if !strings.EqualFold(segments[segment.index], segment.want) {
+			return nil, fmt.Errorf("expected segment %q, got %q", segment.want, segments[segment.index])
+		}
+	}
+	for _, segment := range segments {
+		if segment == "" {
+			return nil, errors.New("resource ID has an empty segment")
+		}
+	}
+	return &resourceID{
+		SubscriptionID: segments[1],
+		ResourceGroup:  segments[3],
+		Provider:       segments[5],
+		Type:           segments[6],
+		Name:           segments[7],
+	}, nil
+}
+
+// checkResourceID parses id and checks that it names a resource of the given
+// provider and type in the given subscription, when one is set
+func checkResourceID(id, provider, resourceType, subscriptionID string) error {
+	parsed, err := parseResourceID(id)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(parsed.Provider, provider) || !strings.EqualFold(parsed.Type, resourceType) {
+		return fmt.Errorf("expected a %s/%s resource, got %s/%s", provider, resourceType, parsed.Provider, parsed.Type)
+	}
+	if subscriptionID != "" && !strings.EqualFold(parsed.SubscriptionID, subscriptionID) {
+		return fmt.Errorf("subscription %s doesn't match azure-subscription-id %s", parsed.SubscriptionID, subscriptionID)
+	}
+	return nil
+}
+
+// Azure naming rules, see
+// https://learn.microsoft.com/azure/azure-resource-manager/management/resource-name-rules
+var (
+	storageAccountNamePattern      = regexp.MustCompile(`^[a-z0-9]{3,24}$`)
+	serviceBusNamespaceNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]{4,48}[A-Za-z0-9]$`)
+	serviceBusTopicNamePattern     = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]{0,258}[A-Za-z0-9])?$`)
+)
+
+// checkStorageAccountName enforces the storage account naming rules
+func checkStorageAccountName(name string) error {
+	if !storageAccountNamePattern.MatchString(name) {
+		return errors.New("must be 3 to 24 lowercase letters and numbers")
+	}
+	return nil
+}
+
+// checkServiceBusNamespaceName enforces the Service Bus namespace naming rules
+func checkServiceBusNamespaceName(name string) error {
+	if !serviceBusNamespaceNamePattern.MatchString(name) {
+		return errors.New("must be 6 to 50 letters, numbers and hyphens, start with a letter and end with a letter or number")
+	}
+	for _, suffix := range []string{"-sb", "-mgmt"} {
+		if strings.HasSuffix(strings.ToLower(name), suffix) {
+			return fmt.Errorf("must not end with %s", suffix)
+		}
+	}
+	return nil
+}
+
+// checkServiceBusTopicName enforces the Service Bus topic naming rules
+func checkServiceBusTopicName(name string) error {
+	if !serviceBusTopicNamePattern.MatchString(name) {
+		return errors.New("must be 1 to 260 letters, numbers, periods, hyphens, underscores and slashes, starting and ending with a letter or number")
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseResourceID(t *testing.T) {
+	id, err := parseResourceID("/subscriptions/sub-id/resourceGroups/test-rg/providers/Microsoft.App/managedEnvironments/test-env")
+	if err != nil {
+		t.Fatalf("Failed to parse resource ID: %v", err)
+	}
+	expected := &resourceID{
+		SubscriptionID: "sub-id",
+		ResourceGroup:  "test-rg",
+		Provider:       "Microsoft.App",
+		Type:           "managedEnvironments",
+		Name:           "test-env",
+	}
+	if !reflect.DeepEqual(id, expected) {
+		t.Errorf("parseResourceID() = %+v, want %+v", id, expected)
+	}
+
+	for _, invalid := range []string{
+		"test-env",
+		"subscriptions/sub-id/resourceGroups/test-rg/providers/Microsoft.App/managedEnvironments/test-env",
+		"/subscriptions/sub-id/resourceGroups/test-rg/providers/Microsoft.App/managedEnvironments",
+		"/subscriptions/sub-id/resourcegroup/test-rg/providers/Microsoft.App/managedEnvironments/test-env",
+		"/subscriptions//resourceGroups/test-rg/providers/Microsoft.App/managedEnvironments/test-env",
+	} {
+		if _, err := parseResourceID(invalid); err == nil {
+			t.Errorf("Expected error parsing %q", invalid)
+		}
+	}
+
+	// With several wrong segments the first is always reported
+	for range 20 {
+		_, err := parseResourceID("/subscription/sub-id/resourceGroup/test-rg/provider/Microsoft.App/managedEnvironments/test-env")
+		if want := `expected segment "subscriptions", got "subscription"`; err == nil || err.Error() != want {
+			t.Fatalf("parseResourceID() error = %v, want %q", err, want)
+		}
+	}
+}
+
+func TestCheckResourceID(t *testing.T) {
+	const id = "/subscriptions/sub-id/resourceGroups/test-rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/test-uai"
+
+	testCases := map[string]struct {
+		provider, resourceType, subscription string
+		want                                 string
+	}{
+		"valid":                 {"Microsoft.ManagedIdentity", "userAssignedIdentities", "sub-id", ""},
+		"case insensitive":      {"microsoft.managedidentity", "userassignedidentities", "SUB-ID", ""},
+		"no subscription":       {"Microsoft.ManagedIdentity", "userAssignedIdentities", "", ""},
+		"wrong type":            {"Microsoft.App", "managedEnvironments", "sub-id", "expected a Microsoft.App/managedEnvironments resource, got Microsoft.ManagedIdentity/userAssignedIdentities"},
+		"subscription mismatch": {"Microsoft.ManagedIdentity", "userAssignedIdentities", "other-id", "subscription sub-id doesn't match azure-subscription-id other-id"},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := checkResourceID(id, tc.provider, tc.resourceType, tc.subscription)
+			if tc.want == "" {
+				if err != nil {
+					t.Errorf("Expected no error but got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Expected error to contain %q, got: %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestAzureNames(t *testing.T) {
+	testCases := []struct {
+		name  string
+		check func(string) error
+		valid []string
+		bad   []string
+	}{
+		{
+			name:  "storage account",
+			check: checkStorageAccountName,
+			valid: []string{"abc", "teststorage123", strings.Repeat("a", 24)},
+			bad:   []string{"ab", "test-storage", "TestStorage", strings.Repeat("a", 25)},
+		},
+		{
+			name:  "service bus namespace",
+			check: checkServiceBusNamespaceName,
+			valid: []string{"test-namespace", "abcdef", "A1-b2-C3"},
+			bad:   []string{"short", "1namespace", "namespace-", "test_namespace", "bindplane-sb", "bindplane-mgmt", strings.Repeat("a", 51)},
+		},
+		{
+			name:  "service bus topic",
+			check: checkServiceBusTopicName,
+			valid: []string{"a", "test-topic", "bindplane.events_v2", "team/topic"},
+			bad:   []string{"", "-topic", "topic.", "topic name", strings.Repeat("a", 261)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, name := range tc.valid {
+				if err := tc.check(name); err != nil {
+					t.Errorf("Expected %q to be valid, got: %v", name, err)
+				}
+			}
+			for _, name := range tc.bad {
+				if err := tc.check(name); err == nil {
+					t.Errorf("Expected %q to be invalid", name)
+				}
+			}
+		})
+	}
+}
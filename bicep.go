@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// bicepFile is the name of the generated Bicep module
+const bicepFile = "main.bicep"
+
+// bicepIdentifierPattern matches object keys that need no quoting in Bicep
+var bicepIdentifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// renderBicep converts the rendered templates into a Bicep module with secure
+// parameters for secrets and outputs for each ingress FQDN
+func renderBicep(config *Config, data *TemplateData) ([]byte, error) {
+	components, err := renderComponents(config, data)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &bicepWriter{used: make(map[string]bool)}
+
+	var resources strings.Builder
+	previous := ""
+	if config.DeployPrometheus {
+		fmt.Fprintf(&resources, "resource prometheusStorage 'Microsoft.App/managedEnvironments/storages@%s' = if (stage >= %d) {\n", containerAppsAPIVersion, componentStage(components, "prometheus.yaml"))
+		fmt.Fprintf(&resources, "  name: %s\n", bicepString(environmentName(config)+"/prometheus-pv"))
+		resources.WriteString("  properties: {\n")
+		resources.WriteString("    azureFile: {\n")
+		fmt.Fprintf(&resources, "      accountName: %s\n", bicepString(config.StorageAccountName))
+		fmt.Fprintf(&resources, "      accountKey: %s\n", w.string(sensitiveMarker("StorageAccountKey")))
+		resources.WriteString("      shareName: 'prometheus-data'\n")
+		resources.WriteString("      accessMode: 'ReadWrite'\n")
+		resources.WriteString("    }\n")
+		resources.WriteString("  }\n")
+		resources.WriteString("}\n\n")
+	}
+
+	for i, component := range components {
+		fmt.Fprintf(&resources, "resource %s 'Microsoft.App/containerApps@%s' = if (stage >= %d) {\n", component.identifier(), containerAppsAPIVersion, i+1)
+		for i := 0; i+1 < len(component.doc.Content); i += 2 {
+			key, value := component.doc.Content[i].Value, component.doc.Content[i+1]
+			if key == "type" {
+				continue
+			}
+			fmt.Fprintf(&resources, "  %s: %s\n", w.key(key), w.value(value, "  "))
+		}
+
+		// Deploy in the same order as deploy.sh
+		var dependsOn []string
+		if previous != "" {
+			dependsOn = append(dependsOn, previous)
+		}
+		if component.file == "prometheus.yaml" {
+			dependsOn = append(dependsOn, "prometheusStorage")
+		}
+		if len(dependsOn) > 0 {
+			resources.WriteString("  dependsOn: [\n")
+			for _, dep := range dependsOn {
+				fmt.Fprintf(&resources, "    %s\n", dep)
+			}
+			resources.WriteString("  ]\n")
+		}
+		resources.WriteString("}\n\n")
+		previous = component.identifier()
+	}
+
+	var out strings.Builder
+	out.WriteString("// Generated by bindplane-aca. Do not edit by hand.\n")
+	out.WriteString("// Deploy with deploy.sh or az deployment group create.\n\n")
+
+	for _, p := range secretParameters {
+		if !w.used[p.name()] {
+			continue
+		}
+		out.WriteString("@secure()\n")
+		fmt.Fprintf(&out, "@description(%s)\n", bicepString(p.description))
+		fmt.Fprintf(&out, "param %s string\n\n", p.name())
+	}
+
+	fmt.Fprintf(&out, "@description(%s)\n", bicepString(stageDescription))
+	out.WriteString("@minValue(1)\n")
+	fmt.Fprintf(&out, "@maxValue(%d)\n", len(components))
+	fmt.Fprintf(&out, "param stage int = %d\n\n", len(components))
+
+	out.WriteString(resources.String())
+
+	for i, component := range components {
+		if component.hasIngress() {
+			fmt.Fprintf(&out, "output %sFqdn string = stage >= %d ? %s.properties.configuration.ingress.fqdn : ''\n", component.identifier(), i+1, component.identifier())
+		}
+	}
+
+	return []byte(out.String()), nil
+}
+
+// bicepWriter converts rendered YAML into Bicep expressions, recording the
+// parameters referenced along the way
+type bicepWriter struct {
+	used map[string]bool
+}
+
+// value returns the Bicep expression for node, indented for nesting at indent
+func (w *bicepWriter) value(node *yaml.Node, indent string) string {
+	node = resolveAlias(node)
+	switch node.Kind {
+	case yaml.MappingNode:
+		if len(node.Content) == 0 {
+			return "{}"
+		}
+		var b strings.Builder
+		b.WriteString("{\n")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			fmt.Fprintf(&b, "%s  %s: %s\n", indent, w.key(node.Content[i].Value), w.value(node.Content[i+1], indent+"  "))
+		}
+		b.WriteString(indent + "}")
+		return b.String()
+	case yaml.SequenceNode:
+		if len(node.Content) == 0 {
+			return "[]"
+		}
+		var b strings.Builder
+		b.WriteString("[\n")
+		for _, item := range node.Content {
+			fmt.Fprintf(&b, "%s  %s\n", indent, w.value(item, indent+"  "))
+		}
+		b.WriteString(indent + "]")
+		return b.String()
+	}
+
+	switch node.ShortTag() {
+	case "!!int", "!!bool":
+		return node.Value
+	case "!!float":
+		// Bicep has no decimal literals
+		return fmt.Sprintf("json(%s)", bicepString(node.Value))
+	case "!!null":
+		return "null"
+	default:
+		return w.string(node.Value)
+	}
+}
+
+// key returns s as a Bicep object key
+func (w *bicepWriter) key(s string) string {
+	if bicepIdentifierPattern.MatchString(s) {
+		return s
+	}
+	return w.string(s)
+}
+
+// string returns s as a Bicep string, interpolating secret parameter markers
+func (w *bicepWriter) string(s string) string {
+	segments := splitPlaceholders(s)
+	if len(segments) == 1 && segments[0].param != "" {
+		w.used[segments[0].param] = true
+		return segments[0].param
+	}
+
+	var b strings.Builder
+	b.WriteString("'")
+	for _, segment := range segments {
+		if segment.param != "" {
+			w.used[segment.param] = true
+			fmt.Fprintf(&b, "${%s}", segment.param)
+			continue
+		}
+		b.WriteString(bicepEscaper.Replace(segment.text))
+	}
+	b.WriteString("'")
+	return b.String()
+}
+
+// bicepEscaper escapes text for a single quoted Bicep string
+var bicepEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`'`, `\'`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+	"${", `\${`,
+)
+
+// bicepString returns s as a literal Bicep string
+func bicepString(s string) string {
+	return "'" + bicepEscaper.Replace(s) + "'"
+}
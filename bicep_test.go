@@ -0,0 +1,63 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderBicep(t *testing.T) {
+	config := iacTestConfig(t)
+	data := iacTestData()
+
+	output, err := renderBicep(config, data)
+	if err != nil {
+		t.Fatalf("Failed to render Bicep: %v", err)
+	}
+
+	compareGolden(t, filepath.Join("testdata", "bicep", bicepFile), output)
+	assertNoSecrets(t, output, config, data)
+}
+
+func TestBicepString(t *testing.T) {
+	testCases := map[string]string{
+		"plain":       "'plain'",
+		"it's":        `'it\'s'`,
+		"a\nb":        `'a\nb'`,
+		`C:\path`:     `'C:\\path'`,
+		"${notParam}": `'\${notParam}'`,
+	}
+
+	for input, expected := range testCases {
+		if got := bicepString(input); got != expected {
+			t.Errorf("bicepString(%q) mismatch. Expected: %s, Got: %s", input, expected, got)
+		}
+	}
+}
+
+func TestBicepDeploymentCommands(t *testing.T) {
+	config := iacTestConfig(t)
+	config.OutputFormat = outputFormatBicep
+
+	script := strings.Join(deploymentGroupCommands(config, bicepFile, "Bicep"), "\n")
+
+	for _, want := range []string{
+		"az deployment group create",
+		"--template-file \"$OUTPUT_DIR/main.bicep\"",
+		"--parameters license=\"$BINDPLANE_ACA_LICENSE\"",
+		"--parameters storageAccountKey=\"$BINDPLANE_ACA_STORAGE_ACCOUNT_KEY\"",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected deploy script to contain %q, got:\n%s", want, script)
+		}
+	}
+	if strings.Contains(script, config.StorageAccountKey) {
+		t.Errorf("Deploy script contains the storage account key:\n%s", script)
+	}
+
+	config.DeployPrometheus = false
+	script = strings.Join(deploymentGroupCommands(config, bicepFile, "Bicep"), "\n")
+	if strings.Contains(script, "storageAccountKey") {
+		t.Errorf("Expected no storage account key parameter without prometheus, got:\n%s", script)
+	}
+}
@@ -0,0 +1,694 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// programName is the name the commands are documented under
+const programName = "bindplane-aca"
+
+// command is a bindplane-aca subcommand. Each command parses its own flags
+// from the arguments that follow its name.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+// commands lists the subcommands in the order they are documented
+var commands = []command{
+	{"generate", "Render the container apps and write deploy.sh (the default)", runGenerate},
+	{"validate", "Check the configuration and render everything without writing files", runValidate},
+	{"diff", "Compare the rendered container apps with a previous output or deployed apps", runDiff},
+	{"drift", "Compare the deployed container apps with what would be rendered now", runDrift},
+	{"deploy", "Generate, then run deploy.sh", runDeploy},
+	{"plan", "Show which container apps apply would create, update or delete", runPlan},
+	{"apply", "Deploy only the container apps that changed since the last apply", runApply},
+	{"rollback", "Re-apply the previous deployment of Bindplane, its jobs and the transform agent", runRollback},
+	{"rollout", "Shift traffic to a new bindplane revision in steps, promoting or aborting it", runRollout},
+	{"destroy", "Run destroy.sh to delete the deployment", runDestroy},
+	{"version", "Print build information and the default image tags", runVersion},
+}
+
+// Usage text shown by each command's -h, after the usage line
+const (
+	generateUsage = "Renders the container apps for -output-format into -output-dir and writes deploy.sh\nto deploy them."
+	validateUsage = "Validates the configuration and renders every template and deploy.sh in memory,\nreporting any problem without writing to -output-dir."
+	diffUsage     = "Renders the container apps in memory and compares them, field by field, with\nthose in -previous: a generated output directory, or a YAML file holding one\nor more apps exported with az containerapp show --output yaml. Env vars and\nother named items are matched by name, and secret values are only reported\nas changed. Exits with status 1 when they differ."
+	driftUsage    = "Reads the deployed container apps from Azure Resource Manager, or from -live\nexports of az containerapp show or list, and compares them with the apps\nrendered now, reporting fields changed outside of deployments, such as env\nvars edited in the portal. Fields Azure adds and secret values, which it never\nreturns, are ignored. Exits with status 1 when anything drifted."
+	deployUsage   = "Generates the output like generate, then runs deploy.sh. With -native it deploys\nthrough the Azure Resource Manager REST API instead, authenticating with a\nservice principal's client secret or a managed identity, so the Azure CLI\nisn't needed."
+	planUsage     = "Renders the container apps in memory and compares them with the spec hashes\nrecorded in the -state file by the last apply, showing which apply would\ncreate, update, leave unchanged or delete. Nothing in Azure is read."
+	applyUsage    = "Plans like plan, then deploys only the container apps that are new or changed\nthrough the Azure Resource Manager REST API, like deploy -native, and deletes\nthose no longer rendered. The -state file is updated after each change, so an\napply that fails part way can be rerun."
+	rollbackUsage = "Re-applies the container apps of a previous deployment generation, recorded by\ndeploy and apply in <output-dir>/history, to the transform agent, Bindplane\njobs and Bindplane, in that order, through the Azure Resource Manager REST API.\nSecrets are filled in from the current configuration. The default generation\nis the one before the latest; -list shows them all."
+	rolloutUsage  = "Deploys bindplane as a new revision labeled -label next to the revision serving\ntraffic, waits for it to become healthy, then shifts ingress traffic to it in\n-steps, checking its health throughout each -step-interval. When every step\npasses the new revision is promoted and the old one deactivated; otherwise all\ntraffic goes back to the old revision and the new one is deactivated. Needs\nbindplane-revisions-mode multiple and calls the Azure Resource Manager REST API."
+	destroyUsage  = "Runs destroy.sh from -output-dir, writing it first if generate hasn't, deleting\nthe container apps in reverse deployment order and, when Prometheus was\ndeployed, its environment storage and, unless -keep-data is set, its file\nshare. Whether Prometheus was deployed is read from the -state file, or else\nfrom prometheus.yaml in -output-dir. Nothing is deleted without -yes."
+	versionUsage  = "Prints build information and the default image of each component."
+
+	stateUsage  = "State file recording the spec hash of each applied container app (default <output-dir>/" + stateFile + ")"
+	dryRunUsage = "Print the rendered components as one YAML stream with secrets masked, followed by the deploy plan, instead of writing or deploying anything"
+)
+
+// errDifferences is returned by diff when the output has changed. It sets the
+// exit status without printing an error.
+var errDifferences = errors.New("rendered output differs from the previous output")
+
+// runCommand runs an external command with the process's standard streams.
+// Tests replace it to record commands instead of running them.
+var runCommand = func(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// run runs the command named by the first argument and returns the exit
+// status. Arguments starting with a flag run generate, so invocations from
+// before subcommands keep working.
+func run(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return 2
+	}
+
+	name := args[0]
+	switch {
+	case name == "help" || name == "-h" || name == "-help" || name == "--help":
+		if len(args) > 1 {
+			return run([]string{args[1], "-h"})
+		}
+		printUsage(os.Stdout)
+		return 0
+	case strings.HasPrefix(name, "-"):
+		name = "generate"
+	default:
+		args = args[1:]
+	}
+
+	i := slices.IndexFunc(commands, func(c command) bool { return c.name == name })
+	if i < 0 {
+		fmt.Fprintf(os.Stderr, "Error: unknown command %q\n\n", name)
+		printUsage(os.Stderr)
+		return 2
+	}
+
+	err := commands[i].run(args)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errDifferences):
+		return 1
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+}
+
+// printUsage prints the list of commands
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", programName)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", programName)
+}
+
+// newFlagSet returns the flag set of the named command. Parse errors are
+// returned rather than exiting so that run decides the exit status.
+func newFlagSet(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(programName+" "+name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags]\n\n%s\n\nFlags:\n", programName, name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// loadConfig parses and validates the configuration and builds the template
+// data from it, printing any warnings
+func loadConfig(fs *flag.FlagSet, args []string) (*Config, *TemplateData, error) {
+	config, err := parseConfigFlags(fs, args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := checkConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, data, nil
+}
+
+// checkConfig validates a parsed configuration and builds the template data
+// from it, printing any warnings
+func checkConfig(config *Config) (*TemplateData, error) {
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	for _, warning := range postgresWarnings(config) {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	return newTemplateData(config)
+}
+
+// runGenerate writes the rendered output and deploy.sh
+func runGenerate(args []string) error {
+	fs := newFlagSet("generate", generateUsage)
+	dryRun := fs.Bool("dry-run", false, dryRunUsage)
+	config, data, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		return writeDryRun(os.Stdout, config, data)
+	}
+	return generate(config, data)
+}
+
+// generate writes the rendered output and deploy.sh to the output directory
+func generate(config *Config, data *TemplateData) error {
+	if err := processTemplates(config, data); err != nil {
+		return fmt.Errorf("failed to process templates: %w", err)
+	}
+
+	if err := generateDeploymentCommands(config); err != nil {
+		return fmt.Errorf("failed to generate deployment script: %w", err)
+	}
+
+	if err := generateDestroyCommands(config); err != nil {
+		return fmt.Errorf("failed to generate teardown script: %w", err)
+	}
+
+	fmt.Printf("Templates processed successfully. Output files generated in: %s\n", config.OutputDir)
+	return nil
+}
+
+// runValidate renders everything generate would write, without writing it
+func runValidate(args []string) error {
+	config, data, err := loadConfig(newFlagSet("validate", validateUsage), args)
+	if err != nil {
+		return err
+	}
+
+	files, err := renderOutputs(config, data)
+	if err != nil {
+		return err
+	}
+	if _, err := renderDeploymentScript(config); err != nil {
+		return err
+	}
+	if _, err := renderDestroyScript(config); err != nil {
+		return err
+	}
+
+	fmt.Printf("Configuration is valid. generate would write %d files, %s and %s to %s\n", len(files), deployScript, destroyScript, config.OutputDir)
+	return nil
+}
+
+// runDiff compares the rendered components with a previous output
+func runDiff(args []string) error {
+	fs := newFlagSet("diff", diffUsage)
+	previous := fs.String("previous", "", "Previously generated output directory, or a YAML file exported with az containerapp show (default -output-dir)")
+	config, data, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if *previous == "" {
+		*previous = config.OutputDir
+	}
+
+	before, err := loadPreviousComponents(*previous)
+	if err != nil {
+		return err
+	}
+	rendered, err := renderComponentsWith(config, data)
+	if err != nil {
+		return err
+	}
+
+	if writeDiff(os.Stdout, diffComponents(before, rendered)) {
+		return errDifferences
+	}
+	return nil
+}
+
+// runDrift compares the deployed container apps with the rendered ones
+func runDrift(args []string) error {
+	fs := newFlagSet("drift", driftUsage)
+	livePath := fs.String("live", "", "File or directory of container apps exported with az containerapp show or list, as JSON or YAML, to compare instead of reading them from Azure Resource Manager")
+	asJSON := fs.Bool("json", false, "Print the report as JSON, for alerting")
+	arm := registerARMFlags(fs)
+	config, data, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+
+	rendered, err := renderComponents(config, data)
+	if err != nil {
+		return err
+	}
+
+	var live map[string]*yaml.Node
+	source := *livePath
+	if source != "" {
+		live, err = loadLiveComponents(source)
+	} else {
+		var client *armClient
+		if client, err = arm.client(); err != nil {
+			return err
+		}
+		source = "arm"
+		live, err = fetchLiveComponents(context.Background(), client, config)
+	}
+	if err != nil {
+		return err
+	}
+
+	report := detectDrift(source, live, rendered)
+	if err := writeDriftReport(os.Stdout, report, *asJSON); err != nil {
+		return err
+	}
+	if report.Drifted {
+		return errDifferences
+	}
+	return nil
+}
+
+// runDeploy generates the output and runs deploy.sh, or deploys through the
+// ARM REST API with -native
+func runDeploy(args []string) error {
+	fs := newFlagSet("deploy", deployUsage)
+	dryRun := fs.Bool("dry-run", false, dryRunUsage)
+	native := fs.Bool("native", false, "Deploy through the Azure Resource Manager REST API instead of running deploy.sh, so the Azure CLI isn't needed")
+	arm := registerARMFlags(fs)
+	config, data, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		return writeDryRun(os.Stdout, config, data)
+	}
+
+	if *native {
+		if err := checkNativeDeploy(config); err != nil {
+			return err
+		}
+		client, err := arm.client()
+		if err != nil {
+			return err
+		}
+		if err := generate(config, data); err != nil {
+			return err
+		}
+		if err := newARMDeployer(client, config).deploy(context.Background(), data); err != nil {
+			return err
+		}
+		return recordDeployment(config, data, "deploy")
+	}
+
+	if err := generate(config, data); err != nil {
+		return err
+	}
+
+	script := filepath.Join(config.OutputDir, deployScript)
+	if err := runCommand("bash", script); err != nil {
+		return fmt.Errorf("%s failed: %w", script, err)
+	}
+	return recordDeployment(config, data, "deploy")
+}
+
+// runPlan prints what apply would change
+func runPlan(args []string) error {
+	fs := newFlagSet("plan", planUsage)
+	statePath := fs.String("state", "", stateUsage)
+	exitCode := fs.Bool("exit-code", false, "Exit with status 1 when the plan changes anything")
+	config, data, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+
+	_, steps, err := planDeployment(config, data, cmp.Or(*statePath, filepath.Join(config.OutputDir, stateFile)), false)
+	if err != nil {
+		return err
+	}
+	if writePlan(os.Stdout, steps) && *exitCode {
+		return errDifferences
+	}
+	return nil
+}
+
+// runApply deploys the container apps that changed since the last apply
+func runApply(args []string) error {
+	fs := newFlagSet("apply", applyUsage)
+	statePath := fs.String("state", "", stateUsage)
+	yes := fs.Bool("yes", false, "Delete container apps that are no longer rendered; required since deletes can't be undone")
+	force := fs.Bool("force", false, "Update every rendered container app even if unchanged, e.g. after rotating a secret, since secret values aren't part of the spec hash")
+	arm := registerARMFlags(fs)
+	config, data, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := checkNativeDeploy(config); err != nil {
+		return err
+	}
+
+	path := cmp.Or(*statePath, filepath.Join(config.OutputDir, stateFile))
+	state, steps, err := planDeployment(config, data, path, *force)
+	if err != nil {
+		return err
+	}
+	if !writePlan(os.Stdout, steps) {
+		fmt.Println("Nothing to apply.")
+		return nil
+	}
+	if !*yes && slices.ContainsFunc(steps, func(step planStep) bool { return step.action == actionDelete }) {
+		return errors.New("refusing to delete container apps without -yes")
+	}
+
+	client, err := arm.client()
+	if err != nil {
+		return err
+	}
+	if err := generate(config, data); err != nil {
+		return err
+	}
+	components, err := renderComponentsWith(config, data)
+	if err != nil {
+		return err
+	}
+	if err := applyPlan(context.Background(), newARMDeployer(client, config), steps, components, state, path); err != nil {
+		return err
+	}
+	fmt.Printf("Apply complete. State saved to %s\n", path)
+	return recordDeployment(config, data, "apply")
+}
+
+// runRollback re-applies a previous generation
+func runRollback(args []string) error {
+	fs := newFlagSet("rollback", rollbackUsage)
+	to := fs.Int("to", 0, "Generation to roll back to (default the one before the latest)")
+	list := fs.Bool("list", false, "List the recorded generations, newest first, instead of rolling back")
+	statePath := fs.String("state", "", stateUsage)
+	arm := registerARMFlags(fs)
+	config, err := parseConfigFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	generations, err := listGenerations(config)
+	if err != nil {
+		return err
+	}
+	if *list {
+		writeHistory(os.Stdout, generations)
+		return nil
+	}
+
+	data, err := checkConfig(config)
+	if err != nil {
+		return err
+	}
+	if len(generations) < 2 {
+		return fmt.Errorf("%d deployments recorded in %s; there is nothing to roll back to", len(generations), filepath.Join(config.OutputDir, historyDir))
+	}
+	current, target := generations[len(generations)-1], generations[len(generations)-2]
+	if *to != 0 {
+		i := slices.IndexFunc(generations, func(g *generation) bool { return g.Number == *to })
+		switch {
+		case i < 0:
+			return fmt.Errorf("generation %d is not recorded; see %s rollback -list", *to, programName)
+		case generations[i] == current:
+			return fmt.Errorf("generation %d is the latest deployment", *to)
+		}
+		target = generations[i]
+	}
+
+	var files []string
+	for _, filename := range rollbackFiles {
+		if slices.Contains(target.Files, filename) {
+			files = append(files, filename)
+		}
+	}
+	components, err := target.components(files)
+	if err != nil {
+		return err
+	}
+	var hashes []string
+	for _, component := range components {
+		hash, err := specHash(component)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, hash)
+		if err := fillSecrets(component, data); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Rolling back from %s\n  to %s\n", current, target)
+	if warning := migrationWarning(current, target); warning != "" {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	client, err := arm.client()
+	if err != nil {
+		return err
+	}
+	deployer := newARMDeployer(client, config)
+	path := cmp.Or(*statePath, filepath.Join(config.OutputDir, stateFile))
+	state, err := loadState(path)
+	if err != nil {
+		return err
+	}
+	for i, component := range components {
+		if err := deployer.deployApp(context.Background(), component); err != nil {
+			return err
+		}
+		// Keep the state of apply current, so plan shows the rollback
+		if err := state.recordDeployed(config, path, planStep{name: component.name, file: component.file, hash: hashes[i]}); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Rollback to generation %d complete\n", target.Number)
+	return recordRollback(config, current, target)
+}
+
+// runRollout rolls bindplane out as a new revision, shifting traffic in steps
+func runRollout(args []string) error {
+	fs := newFlagSet("rollout", rolloutUsage)
+	steps := fs.String("steps", defaultRolloutSteps, "Comma-separated percentages of traffic to shift to the new revision, ending with 100; 100 alone is a blue/green switch")
+	interval := fs.Duration("step-interval", defaultRolloutInterval, "How long to hold each step, checking the new revision's health, before the next")
+	label := fs.String("label", defaultRolloutLabel, "Traffic label of the new revision, which gives it its own URL")
+	suffix := fs.String("revision-suffix", "", "Suffix of the new revision's name (default made of bindplane-tag and the time)")
+	statePath := fs.String("state", "", stateUsage)
+	arm := registerARMFlags(fs)
+	config, data, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if config.BindplaneRevisionsMode != revisionsModeMultiple {
+		return fmt.Errorf("rollout needs bindplane-revisions-mode %s, so deploy, apply and drift agree with the revisions it creates, but it is %s (%s)",
+			revisionsModeMultiple, config.BindplaneRevisionsMode, config.sourceOf("bindplane-revisions-mode"))
+	}
+	// Traffic only moves to a revision that health checks found ready
+	if config.HealthTimeout <= 0 {
+		return fmt.Errorf("rollout needs a health-timeout above 0 to wait for the new revision before shifting traffic to it, but it is %s (%s)",
+			config.HealthTimeout, config.sourceOf("health-timeout"))
+	}
+	options, err := newRolloutOptions(*steps, *interval, *label, *suffix, config.BindplaneTag, time.Now())
+	if err != nil {
+		return err
+	}
+
+	client, err := arm.client()
+	if err != nil {
+		return err
+	}
+	if err := generate(config, data); err != nil {
+		return err
+	}
+	rendered, err := renderComponents(config, data)
+	if err != nil {
+		return err
+	}
+	components, err := renderComponentsWith(config, data)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(components, func(c *iacComponent) bool { return c.file == "bindplane.yaml" })
+	hash, err := specHash(rendered[i])
+	if err != nil {
+		return err
+	}
+
+	if err := newARMDeployer(client, config).rollout(context.Background(), components[i], options); err != nil {
+		return err
+	}
+	path := cmp.Or(*statePath, filepath.Join(config.OutputDir, stateFile))
+	state, err := loadState(path)
+	if err != nil {
+		return err
+	}
+	if err := state.recordDeployed(config, path, planStep{name: components[i].name, file: components[i].file, hash: hash}); err != nil {
+		return err
+	}
+	return recordDeployment(config, data, "rollout")
+}
+
+// runDestroy writes destroy.sh and runs it
+func runDestroy(args []string) error {
+	fs := newFlagSet("destroy", destroyUsage)
+	yes := fs.Bool("yes", false, "Delete without asking; required since destroy can't be undone")
+	keepData := fs.Bool("keep-data", false, "Keep the Prometheus Azure File share and its data")
+	statePath := fs.String("state", "", stateUsage)
+	config, err := parseConfigFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	// What gets deleted follows what was deployed, not this command's flags
+	config.DeployPrometheus, err = deployedPrometheus(config, cmp.Or(*statePath, filepath.Join(config.OutputDir, stateFile)))
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	if config.ResourceGroup == "" {
+		missing = append(missing, fmt.Sprintf("resource-group (%s)", config.sourceOf("resource-group")))
+	}
+	if config.DeployPrometheus && config.ACAEnvironmentID == "" {
+		missing = append(missing, fmt.Sprintf("aca-environment-id (%s), needed to remove the Prometheus storage", config.sourceOf("aca-environment-id")))
+	}
+	if config.DeployPrometheus && !*keepData && config.StorageAccountName == "" {
+		missing = append(missing, fmt.Sprintf("storage-account-name (%s), needed to delete the Prometheus file share unless -keep-data is set", config.sourceOf("storage-account-name")))
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required values: %s", strings.Join(missing, ", "))
+	}
+
+	if !*yes {
+		for _, line := range destroyPlan(config, *keepData) {
+			fmt.Println(line)
+		}
+		return errors.New("refusing to delete anything without -yes")
+	}
+
+	// A destroy.sh written by generate already matches the output it deletes
+	script := filepath.Join(config.OutputDir, destroyScript)
+	if _, err := os.Stat(script); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(config.OutputDir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+		if err := generateDestroyCommands(config); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to read %s: %w", script, err)
+	}
+
+	scriptArgs := []string{script, "--yes"}
+	if *keepData {
+		scriptArgs = append(scriptArgs, "--keep-data")
+	}
+	if err := runCommand("bash", scriptArgs...); err != nil {
+		return fmt.Errorf("%s failed: %w", script, err)
+	}
+	return nil
+}
+
+// runVersion prints build information and the default images
+func runVersion(args []string) error {
+	fs := newFlagSet("version", versionUsage)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	version, settings := "(devel)", map[string]string{}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Version != "" {
+			version = info.Main.Version
+		}
+		settings["go"] = info.GoVersion
+		for _, setting := range info.Settings {
+			settings[setting.Key] = setting.Value
+		}
+	}
+
+	fmt.Printf("%s %s\n", programName, version)
+	if revision := settings["vcs.revision"]; revision != "" {
+		if settings["vcs.modified"] == "true" {
+			revision += " (modified)"
+		}
+		fmt.Printf("  commit: %s\n", revision)
+	}
+	if built := settings["vcs.time"]; built != "" {
+		fmt.Printf("  built:  %s\n", built)
+	}
+	if goVersion := settings["go"]; goVersion != "" {
+		fmt.Printf("  go:     %s\n", goVersion)
+	}
+
+	images, err := defaultImages()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nDefault images (-bindplane-tag %s):\n", defaultBindplaneTag)
+	for _, filename := range templateFiles(&Config{DeployPrometheus: true}) {
+		fmt.Printf("  %-22s %s\n", filename, images[filename])
+	}
+	return nil
+}
+
+// imagePattern matches the image line of an embedded template
+var imagePattern = regexp.MustCompile(`(?m)^\s*image:\s*(\S+)`)
+
+// defaultImages returns the image each embedded template deploys with the
+// default Bindplane tag, keyed by template file
+func defaultImages() (map[string]string, error) {
+	images := make(map[string]string)
+	for _, filename := range templateFiles(&Config{DeployPrometheus: true}) {
+		content, _, err := readTemplate("", filename)
+		if err != nil {
+			return nil, err
+		}
+		match := imagePattern.FindSubmatch(content)
+		if match == nil {
+			continue
+		}
+		tmpl, err := template.New(filename).Parse(string(match[1]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse image of %s: %w", filename, err)
+		}
+		var image bytes.Buffer
+		if err := tmpl.Execute(&image, &TemplateData{BindplaneTag: defaultBindplaneTag}); err != nil {
+			return nil, fmt.Errorf("failed to render image of %s: %w", filename, err)
+		}
+		images[filename] = image.String()
+	}
+	return images, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// cliTestArgs returns the flags of a valid configuration writing to outputDir
+func cliTestArgs(outputDir string) []string {
+	return []string{
+		"-aca-environment-id", "/subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.App/managedEnvironments/test-env",
+		"-postgres-host", "test-host",
+		"-postgres-username", "test-user",
+		"-postgres-database", "test-db",
+		"-license", "test-license",
+		"-postgres-password", "test-pass",
+		"-storage-account-name", "teststorage",
+		"-storage-account-key", "test-storage-key",
+		"-resource-group", "test-rg",
+		"-session-secret", "test-session-secret",
+		"-azure-connection-string", "test-connection-string",
+		"-azure-topic", "test-topic",
+		"-azure-subscription-id", "test-subscription-id",
+		"-azure-resource-group", "test-rg",
+		"-azure-namespace", "test-namespace",
+		"-managed-identity-id", "/subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/test-uai",
+		"-azure-client-id", "test-client-id",
+		"-output-dir", outputDir,
+	}
+}
+
+// stubRunCommand records the commands run instead of running them
+func stubRunCommand(t *testing.T) *[][]string {
+	t.Helper()
+	var ran [][]string
+	original := runCommand
+	runCommand = func(name string, args ...string) error {
+		ran = append(ran, append([]string{name}, args...))
+		return nil
+	}
+	t.Cleanup(func() { runCommand = original })
+	return &ran
+}
+
+func TestRunExitStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{name: "no command", args: nil, want: 2},
+		{name: "unknown command", args: []string{"bogus"}, want: 2},
+		{name: "help", args: []string{"help"}, want: 0},
+		{name: "command help", args: []string{"generate", "-h"}, want: 0},
+		{name: "unknown flag", args: []string{"validate", "-bogus"}, want: 1},
+		{name: "invalid config", args: []string{"validate"}, want: 1},
+		{name: "unexpected argument", args: []string{"version", "extra"}, want: 1},
+		{name: "version", args: []string{"version"}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := run(tt.args); got != tt.want {
+				t.Errorf("run(%q) = %d, want %d", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunGenerateWithoutCommand(t *testing.T) {
+	outputDir := filepath.Join(t.TempDir(), "out")
+	if got := run(cliTestArgs(outputDir)); got != 0 {
+		t.Fatalf("run() = %d, want 0", got)
+	}
+	for _, name := range []string{"bindplane.yaml", deployScript} {
+		if _, err := os.Stat(filepath.Join(outputDir, name)); err != nil {
+			t.Errorf("Expected %s to be generated: %v", name, err)
+		}
+	}
+}
+
+func TestRunValidateWritesNothing(t *testing.T) {
+	outputDir := filepath.Join(t.TempDir(), "out")
+	if err := runValidate(cliTestArgs(outputDir)); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	if _, err := os.Stat(outputDir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected validate not to create %s, got: %v", outputDir, err)
+	}
+}
+
+func TestRunDiff(t *testing.T) {
+	outputDir := filepath.Join(t.TempDir(), "out")
+	if err := runGenerate(cliTestArgs(outputDir)); err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+
+	if err := runDiff(cliTestArgs(outputDir)); err != nil {
+		t.Errorf("Expected no differences, got: %v", err)
+	}
+
+	args := append(cliTestArgs(outputDir), "-postgres-port", "6432")
+	if err := runDiff(args); !errors.Is(err, errDifferences) {
+		t.Errorf("Expected errDifferences, got: %v", err)
+	}
+	if got := run(append([]string{"diff"}, args...)); got != 1 {
+		t.Errorf("run(diff) = %d, want 1", got)
+	}
+}
+
+func TestRunDeploy(t *testing.T) {
+	ran := stubRunCommand(t)
+	outputDir := filepath.Join(t.TempDir(), "out")
+
+	if err := runDeploy(cliTestArgs(outputDir)); err != nil {
+		t.Fatalf("deploy failed: %v", err)
+	}
+
+	expected := [][]string{{"bash", filepath.Join(outputDir, deployScript)}}
+	if !reflect.DeepEqual(*ran, expected) {
+		t.Errorf("deploy ran %q, want %q", *ran, expected)
+	}
+}
+
+func TestRunDestroy(t *testing.T) {
+	ran := stubRunCommand(t)
+	outputDir := filepath.Join(t.TempDir(), "out")
+	args := []string{
+		"-resource-group", "test-rg",
+		"-aca-environment-id", "/subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.App/managedEnvironments/test-env",
+		"-storage-account-name", "teststorage",
+		"-deploy-prometheus",
+		"-output-dir", outputDir,
+	}
+
+	err := runDestroy(args)
+	if err == nil || !strings.Contains(err.Error(), "-yes") {
+		t.Errorf("Expected destroy to require -yes, got: %v", err)
+	}
+	if len(*ran) != 0 {
+		t.Fatalf("Expected nothing to run without -yes, ran %q", *ran)
+	}
+
+	if err := runDestroy(append(args, "-yes", "-keep-data")); err != nil {
+		t.Fatalf("destroy failed: %v", err)
+	}
+	script := filepath.Join(outputDir, destroyScript)
+	expected := [][]string{{"bash", script, "--yes", "--keep-data"}}
+	if !reflect.DeepEqual(*ran, expected) {
+		t.Errorf("destroy ran %q, want %q", *ran, expected)
+	}
+	content, err := os.ReadFile(script)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", destroyScript, err)
+	}
+	if !strings.Contains(string(content), "delete_app bindplane-prometheus") {
+		t.Errorf("Expected %s to delete Prometheus, got:\n%s", destroyScript, content)
+	}
+
+	// Deleting the file share needs the storage account
+	err = runDestroy([]string{"-resource-group", "test-rg", "-aca-environment-id", args[3], "-deploy-prometheus", "-output-dir", outputDir})
+	if err == nil || !strings.Contains(err.Error(), "storage-account-name") {
+		t.Errorf("Expected destroy to require storage-account-name, got: %v", err)
+	}
+
+	// The destroy.sh written by generate is run as is, whatever the flags say
+	generated := filepath.Join(t.TempDir(), "generated")
+	if err := runGenerate(cliTestArgs(generated)); err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+	script = filepath.Join(generated, destroyScript)
+	if err := os.WriteFile(script, []byte("# generated\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	*ran = nil
+	if err := runDestroy([]string{"-resource-group", "test-rg", "-deploy-prometheus", "-output-dir", generated, "-yes"}); err != nil {
+		t.Fatalf("destroy failed: %v", err)
+	}
+	if expected := [][]string{{"bash", script, "--yes"}}; !reflect.DeepEqual(*ran, expected) {
+		t.Errorf("destroy ran %q, want %q", *ran, expected)
+	}
+	if content, err := os.ReadFile(script); err != nil || string(content) != "# generated\n" {
+		t.Errorf("Expected the existing %s to be kept, got %q, %v", destroyScript, content, err)
+	}
+}
+
+func TestDefaultImages(t *testing.T) {
+	images, err := defaultImages()
+	if err != nil {
+		t.Fatalf("defaultImages failed: %v", err)
+	}
+	if want := "ghcr.io/observiq/bindplane-transform-agent:" + defaultBindplaneTag + "-bindplane"; images["transform-agent.yaml"] != want {
+		t.Errorf("transform-agent.yaml image = %q, want %q", images["transform-agent.yaml"], want)
+	}
+	for _, filename := range []string{"bindplane.yaml", "jobs.yaml"} {
+		if want := "ghcr.io/observiq/bindplane-ee:" + defaultBindplaneTag; images[filename] != want {
+			t.Errorf("%s image = %q, want %q", filename, images[filename], want)
+		}
+	}
+	for _, filename := range templateFiles(&Config{DeployPrometheus: true}) {
+		if images[filename] == "" || strings.Contains(images[filename], "{{") {
+			t.Errorf("Unexpected image for %s: %q", filename, images[filename])
+		}
+	}
+}
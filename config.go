@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sourceFlag is recorded for values supplied on the command line. Values are
+// resolved in order of precedence: command line flags, then environment
+// variables, then the config file, then the flag defaults.
+const sourceFlag = "flag"
+
+// envPrefix is prepended to the upper-cased flag name to form the environment
+// variable bound to each flag, e.g. BINDPLANE_ACA_POSTGRES_PASSWORD.
+const envPrefix = "BINDPLANE_ACA_"
+
+// envName returns the environment variable bound to the named flag
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// FileConfig is the structure of the file passed with -config. JSON files are
+// accepted as well since JSON is a subset of YAML.
+type FileConfig struct {
+	ACAEnvironmentID         string         `yaml:"acaEnvironmentId"`
+	ResourceGroup            string         `yaml:"resourceGroup"`
+	OutputDir                string         `yaml:"outputDir"`
+	TemplatesDir             string         `yaml:"templatesDir"`
+	OutputFormat             string         `yaml:"outputFormat"`
+	HealthTimeout            string         `yaml:"healthTimeout"`
+	License                  string         `yaml:"license"`
+	LicenseKeyVaultURI       string         `yaml:"licenseKeyVaultUri"`
+	SessionSecret            string         `yaml:"sessionSecret"`
+	SessionSecretKeyVaultURI string         `yaml:"sessionSecretKeyVaultUri"`
+	BindplaneRemoteURL       string         `yaml:"bindplaneRemoteUrl"`
+	BindplaneRevisionsMode   string         `yaml:"bindplaneRevisionsMode"`
+	DeployPrometheus         *bool          `yaml:"deployPrometheus"`
+	Postgres                 PostgresFile   `yaml:"postgres"`
+	ServiceBus               ServiceBusFile `yaml:"serviceBus"`
+	Storage                  StorageFile    `yaml:"storage"`
+	Identity                 IdentityFile   `yaml:"identity"`
+	Images                   ImagesFile     `yaml:"images"`
+}
+
+// PostgresFile holds the postgres section of the config file
+type PostgresFile struct {
+	Host                string `yaml:"host"`
+	Username            string `yaml:"username"`
+	Password            string `yaml:"password"`
+	PasswordKeyVaultURI string `yaml:"passwordKeyVaultUri"`
+	Database            string `yaml:"database"`
+	SSLMode             string `yaml:"sslMode"`
+	Port                *int   `yaml:"port"`
+	CACertFile          string `yaml:"caCertFile"`
+	// Connection pool sizes for each component
+	BindplaneMaxConnections     *int `yaml:"bindplaneMaxConnections"`
+	BindplaneMaxIdleConnections *int `yaml:"bindplaneMaxIdleConnections"`
+	JobsMaxConnections          *int `yaml:"jobsMaxConnections"`
+	JobsMaxIdleConnections      *int `yaml:"jobsMaxIdleConnections"`
+}
+
+// ServiceBusFile holds the Azure Service Bus section of the config file
+type ServiceBusFile struct {
+	ConnectionString            string `yaml:"connectionString"`
+	ConnectionStringKeyVaultURI string `yaml:"connectionStringKeyVaultUri"`
+	Topic                       string `yaml:"topic"`
+	SubscriptionID              string `yaml:"subscriptionId"`
+	ResourceGroup               string `yaml:"resourceGroup"`
+	Namespace                   string `yaml:"namespace"`
+}
+
+// StorageFile holds the Azure Storage section of the config file
+type StorageFile struct {
+	AccountName           string `yaml:"accountName"`
+	AccountKey            string `yaml:"accountKey"`
+	AccountKeyKeyVaultURI string `yaml:"accountKeyKeyVaultUri"`
+}
+
+// IdentityFile holds the managed identity section of the config file
+type IdentityFile struct {
+	ManagedIdentityID string `yaml:"managedIdentityId"`
+	ClientID          string `yaml:"clientId"`
+}
+
+// ImagesFile holds the container image section of the config file
+type ImagesFile struct {
+	BindplaneTag string `yaml:"bindplaneTag"`
+}
+
+// fileValue is a single config file value keyed by the flag it sets
+type fileValue struct {
+	key   string
+	value string
+}
+
+// loadConfigFile reads the config file at path and returns its non-empty
+// values keyed by flag name.
+func loadConfigFile(path string) (map[string]fileValue, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	var fc FileConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&fc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return fc.values(), nil
+}
+
+// values flattens the file into flag name -> value pairs, skipping unset
+// entries so they do not override defaults.
+func (fc *FileConfig) values() map[string]fileValue {
+	entries := []struct {
+		flag  string
+		key   string
+		value string
+	}{
+		{"aca-environment-id", "acaEnvironmentId", fc.ACAEnvironmentID},
+		{"resource-group", "resourceGroup", fc.ResourceGroup},
+		{"output-dir", "outputDir", fc.OutputDir},
+		{"templates-dir", "templatesDir", fc.TemplatesDir},
+		{"output-format", "outputFormat", fc.OutputFormat},
+		{"health-timeout", "healthTimeout", fc.HealthTimeout},
+		{"license", "license", fc.License},
+		{"license-kv-uri", "licenseKeyVaultUri", fc.LicenseKeyVaultURI},
+		{"session-secret", "sessionSecret", fc.SessionSecret},
+		{"session-secret-kv-uri", "sessionSecretKeyVaultUri", fc.SessionSecretKeyVaultURI},
+		{"bindplane-remote-url", "bindplaneRemoteUrl", fc.BindplaneRemoteURL},
+		{"bindplane-revisions-mode", "bindplaneRevisionsMode", fc.BindplaneRevisionsMode},
+		{"postgres-host", "postgres.host", fc.Postgres.Host},
+		{"postgres-username", "postgres.username", fc.Postgres.Username},
+		{"postgres-password", "postgres.password", fc.Postgres.Password},
+		{"postgres-password-kv-uri", "postgres.passwordKeyVaultUri", fc.Postgres.PasswordKeyVaultURI},
+		{"postgres-database", "postgres.database", fc.Postgres.Database},
+		{"postgres-ssl-mode", "postgres.sslMode", fc.Postgres.SSLMode},
+		{"postgres-ca-cert-file", "postgres.caCertFile", fc.Postgres.CACertFile},
+		{"azure-connection-string", "serviceBus.connectionString", fc.ServiceBus.ConnectionString},
+		{"azure-connection-string-kv-uri", "serviceBus.connectionStringKeyVaultUri", fc.ServiceBus.ConnectionStringKeyVaultURI},
+		{"azure-topic", "serviceBus.topic", fc.ServiceBus.Topic},
+		{"azure-subscription-id", "serviceBus.subscriptionId", fc.ServiceBus.SubscriptionID},
+		{"azure-resource-group", "serviceBus.resourceGroup", fc.ServiceBus.ResourceGroup},
+		{"azure-namespace", "serviceBus.namespace", fc.ServiceBus.Namespace},
+		{"storage-account-name", "storage.accountName", fc.Storage.AccountName},
+		{"storage-account-key", "storage.accountKey", fc.Storage.AccountKey},
+		{"storage-account-key-kv-uri", "storage.accountKeyKeyVaultUri", fc.Storage.AccountKeyKeyVaultURI},
+		{"managed-identity-id", "identity.managedIdentityId", fc.Identity.ManagedIdentityID},
+		{"azure-client-id", "identity.clientId", fc.Identity.ClientID},
+		{"bindplane-tag", "images.bindplaneTag", fc.Images.BindplaneTag},
+	}
+
+	integers := []struct {
+		flag  string
+		key   string
+		value *int
+	}{
+		{"postgres-port", "postgres.port", fc.Postgres.Port},
+		{"bindplane-postgres-max-connections", "postgres.bindplaneMaxConnections", fc.Postgres.BindplaneMaxConnections},
+		{"bindplane-postgres-max-idle-connections", "postgres.bindplaneMaxIdleConnections", fc.Postgres.BindplaneMaxIdleConnections},
+		{"jobs-postgres-max-connections", "postgres.jobsMaxConnections", fc.Postgres.JobsMaxConnections},
+		{"jobs-postgres-max-idle-connections", "postgres.jobsMaxIdleConnections", fc.Postgres.JobsMaxIdleConnections},
+	}
+
+	values := make(map[string]fileValue, len(entries)+len(integers)+1)
+	for _, e := range entries {
+		if e.value != "" {
+			values[e.flag] = fileValue{key: e.key, value: e.value}
+		}
+	}
+	for _, i := range integers {
+		if i.value != nil {
+			values[i.flag] = fileValue{key: i.key, value: strconv.Itoa(*i.value)}
+		}
+	}
+	if fc.DeployPrometheus != nil {
+		values["deploy-prometheus"] = fileValue{key: "deployPrometheus", value: strconv.FormatBool(*fc.DeployPrometheus)}
+	}
+
+	return values
+}
+
+// resolveSources records which values were set on the command line and fills
+// the remaining ones from the environment and the config file, if any.
+func resolveSources(fs *flag.FlagSet, config *Config) error {
+	config.sources = make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		config.sources[f.Name] = sourceFlag
+	})
+
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if envErr != nil || config.sources[f.Name] != "" {
+			return
+		}
+		name := envName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return
+		}
+		source := "environment " + name
+		// The value is left out of the error, since it may be a secret
+		if err := f.Value.Set(value); err != nil {
+			envErr = fmt.Errorf("invalid value for %s from %s: %w", f.Name, source, err)
+			return
+		}
+		config.sources[f.Name] = source
+	})
+	if envErr != nil {
+		return envErr
+	}
+
+	if config.ConfigFile == "" {
+		return nil
+	}
+
+	values, err := loadConfigFile(config.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	for name, v := range values {
+		if config.sources[name] != "" {
+			continue
+		}
+		source := fmt.Sprintf("config file %s (%s)", config.ConfigFile, v.key)
+		// As above, the value is left out of the error
+		if err := fs.Lookup(name).Value.Set(v.value); err != nil {
+			return fmt.Errorf("invalid value for %s from %s: %w", name, source, err)
+		}
+		config.sources[name] = source
+	}
+
+	return nil
+}
+
+// sourceOf describes where the value for the named flag came from
+func (c *Config) sourceOf(name string) string {
+	switch source, ok := c.sources[name]; {
+	case !ok:
+		return "not set"
+	case source == sourceFlag:
+		return "flag -" + name
+	default:
+		return source
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+	}{
+		{
+			name:     "yaml",
+			filename: "deploy.yaml",
+			content: `
+acaEnvironmentId: test-env
+deployPrometheus: true
+healthTimeout: 15m
+postgres:
+  host: test-host
+  password: test-pass
+  port: 6432
+  jobsMaxIdleConnections: 5
+serviceBus:
+  topic: test-topic
+storage:
+  accountName: teststorage
+identity:
+  clientId: test-client-id
+images:
+  bindplaneTag: 1.95.0
+`,
+		},
+		{
+			name:     "json",
+			filename: "deploy.json",
+			content: `{
+  "acaEnvironmentId": "test-env",
+  "deployPrometheus": true,
+  "healthTimeout": "15m",
+  "postgres": {"host": "test-host", "password": "test-pass", "port": 6432, "jobsMaxIdleConnections": 5},
+  "serviceBus": {"topic": "test-topic"},
+  "storage": {"accountName": "teststorage"},
+  "identity": {"clientId": "test-client-id"},
+  "images": {"bindplaneTag": "1.95.0"}
+}`,
+		},
+	}
+
+	expected := map[string]string{
+		"aca-environment-id":                 "test-env",
+		"deploy-prometheus":                  "true",
+		"health-timeout":                     "15m",
+		"postgres-host":                      "test-host",
+		"postgres-password":                  "test-pass",
+		"postgres-port":                      "6432",
+		"jobs-postgres-max-idle-connections": "5",
+		"azure-topic":                        "test-topic",
+		"storage-account-name":               "teststorage",
+		"azure-client-id":                    "test-client-id",
+		"bindplane-tag":                      "1.95.0",
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := loadConfigFile(writeConfigFile(t, tt.filename, tt.content))
+			if err != nil {
+				t.Fatalf("Failed to load config file: %v", err)
+			}
+
+			if len(values) != len(expected) {
+				t.Errorf("Expected %d values, got %d: %v", len(expected), len(values), values)
+			}
+			for name, want := range expected {
+				if got := values[name].value; got != want {
+					t.Errorf("Value for %s mismatch. Expected: %s, Got: %s", name, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileUnknownField(t *testing.T) {
+	path := writeConfigFile(t, "deploy.yaml", "postgres:\n  hots: test-host\n")
+
+	_, err := loadConfigFile(path)
+	if err == nil {
+		t.Fatal("Expected error for unknown field but got none")
+	}
+	if !strings.Contains(err.Error(), "hots") {
+		t.Errorf("Expected error to mention the unknown field, got: %v", err)
+	}
+}
+
+func TestParseFlagsPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "deploy.yaml", `
+postgres:
+  host: file-host
+  username: file-user
+images:
+  bindplaneTag: 1.95.0
+`)
+
+	config, err := parseFlags([]string{"-config", path, "-postgres-host", "flag-host"})
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	if config.PostgresHost != "flag-host" {
+		t.Errorf("Expected flag to override config file, got: %s", config.PostgresHost)
+	}
+	if config.PostgresUsername != "file-user" {
+		t.Errorf("Expected config file to override default, got: %s", config.PostgresUsername)
+	}
+	if config.BindplaneTag != "1.95.0" {
+		t.Errorf("Expected config file bindplane tag, got: %s", config.BindplaneTag)
+	}
+	if config.OutputDir != "out" {
+		t.Errorf("Expected default output dir, got: %s", config.OutputDir)
+	}
+
+	if got := config.sourceOf("postgres-host"); got != "flag -postgres-host" {
+		t.Errorf("Unexpected source for postgres-host: %s", got)
+	}
+	if got := config.sourceOf("postgres-username"); !strings.Contains(got, "postgres.username") {
+		t.Errorf("Unexpected source for postgres-username: %s", got)
+	}
+}
+
+func TestValidateConfigReportsSource(t *testing.T) {
+	path := writeConfigFile(t, "deploy.yaml", "postgres:\n  host: file-host\n")
+
+	config, err := parseFlags([]string{"-config", path, "-license", ""})
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	err = validateConfig(config)
+	if err == nil {
+		t.Fatal("Expected error but got none")
+	}
+	for _, want := range []string{"license (flag -license)", "postgres-username (not set)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	testCases := map[string]string{
+		"postgres-password":  "BINDPLANE_ACA_POSTGRES_PASSWORD",
+		"aca-environment-id": "BINDPLANE_ACA_ACA_ENVIRONMENT_ID",
+		"license":            "BINDPLANE_ACA_LICENSE",
+	}
+
+	for flagName, expected := range testCases {
+		if got := envName(flagName); got != expected {
+			t.Errorf("envName(%q) mismatch. Expected: %s, Got: %s", flagName, expected, got)
+		}
+	}
+}
+
+func TestParseFlagsEnvironment(t *testing.T) {
+	path := writeConfigFile(t, "deploy.yaml", `
+license: file-license
+postgres:
+  host: file-host
+  username: file-user
+`)
+
+	t.Setenv("BINDPLANE_ACA_CONFIG", path)
+	t.Setenv("BINDPLANE_ACA_POSTGRES_HOST", "env-host")
+	t.Setenv("BINDPLANE_ACA_POSTGRES_PASSWORD", "env-pass")
+	t.Setenv("BINDPLANE_ACA_LICENSE", "env-license")
+	t.Setenv("BINDPLANE_ACA_DEPLOY_PROMETHEUS", "true")
+
+	config, err := parseFlags([]string{"-license", "flag-license"})
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	if config.License != "flag-license" {
+		t.Errorf("Expected flag to override environment, got: %s", config.License)
+	}
+	if config.PostgresHost != "env-host" {
+		t.Errorf("Expected environment to override config file, got: %s", config.PostgresHost)
+	}
+	if config.PostgresPassword != "env-pass" {
+		t.Errorf("Expected environment postgres password, got: %s", config.PostgresPassword)
+	}
+	if config.PostgresUsername != "file-user" {
+		t.Errorf("Expected config file postgres username, got: %s", config.PostgresUsername)
+	}
+	if !config.DeployPrometheus {
+		t.Error("Expected deploy-prometheus to be set from the environment")
+	}
+	if got := config.sourceOf("postgres-host"); got != "environment BINDPLANE_ACA_POSTGRES_HOST" {
+		t.Errorf("Unexpected source for postgres-host: %s", got)
+	}
+}
+
+func TestParseFlagsInvalidEnvironment(t *testing.T) {
+	t.Setenv("BINDPLANE_ACA_DEPLOY_PROMETHEUS", "maybe")
+
+	_, err := parseFlags(nil)
+	if err == nil {
+		t.Fatal("Expected error but got none")
+	}
+	// The value isn't echoed, since other variables hold secrets
+	if want := "invalid value for deploy-prometheus from environment BINDPLANE_ACA_DEPLOY_PROMETHEUS: parse error"; err.Error() != want {
+		t.Errorf("Expected error %q, got: %v", want, err)
+	}
+}
+
+func TestValidateConfigFromEnvironment(t *testing.T) {
+	env := map[string]string{
+		"aca-environment-id":      "/subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.App/managedEnvironments/test-env",
+		"postgres-host":           "test-host",
+		"postgres-username":       "test-user",
+		"postgres-database":       "test-db",
+		"license":                 "test-license",
+		"postgres-password":       "test-pass",
+		"storage-account-name":    "teststorage",
+		"storage-account-key":     "test-key",
+		"resource-group":          "test-rg",
+		"session-secret":          "test-session-secret",
+		"azure-connection-string": "test-connection-string",
+		"azure-topic":             "test-topic",
+		"azure-subscription-id":   "test-subscription-id",
+		"azure-resource-group":    "test-rg",
+		"azure-namespace":         "test-namespace",
+		"managed-identity-id":     "/subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/test-uai",
+		"azure-client-id":         "test-client-id",
+	}
+	for name, value := range env {
+		t.Setenv(envName(name), value)
+	}
+
+	config, err := parseFlags(nil)
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if err := validateConfig(config); err != nil {
+		t.Errorf("Expected no error but got: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// containerApp is a rendered Microsoft.App/containerApps template
+type containerApp struct {
+	Name       string                 `yaml:"name"`
+	Type       string                 `yaml:"type"`
+	Location   string                 `yaml:"location"`
+	Identity   *containerAppIdentity  `yaml:"identity"`
+	Properties containerAppProperties `yaml:"properties"`
+}
+
+// containerAppIdentity is the managed identity assigned to a container app
+type containerAppIdentity struct {
+	Type                   string              `yaml:"type"`
+	UserAssignedIdentities map[string]struct{} `yaml:"userAssignedIdentities"`
+}
+
+// containerAppProperties holds the container app resource properties
+type containerAppProperties struct {
+	ManagedEnvironmentID string                    `yaml:"managedEnvironmentId"`
+	Configuration        containerAppConfiguration `yaml:"configuration"`
+	Template             containerAppTemplate      `yaml:"template"`
+}
+
+// containerAppConfiguration holds revision, ingress and secret settings
+type containerAppConfiguration struct {
+	ActiveRevisionsMode string               `yaml:"activeRevisionsMode"`
+	Ingress             *containerAppIngress `yaml:"ingress"`
+	Secrets             []containerAppSecret `yaml:"secrets"`
+}
+
+// containerAppIngress configures the container app's ingress
+type containerAppIngress struct {
+	External               bool                      `yaml:"external"`
+	TargetPort             int                       `yaml:"targetPort"`
+	AllowInsecure          bool                      `yaml:"allowInsecure"`
+	Transport              string                    `yaml:"transport"`
+	AdditionalPortMappings []containerAppPortMapping `yaml:"additionalPortMappings"`
+}
+
+// containerAppPortMapping is an additional port exposed by ingress
+type containerAppPortMapping struct {
+	External    bool `yaml:"external"`
+	TargetPort  int  `yaml:"targetPort"`
+	ExposedPort int  `yaml:"exposedPort"`
+}
+
+// containerAppSecret is a secret available to the app's containers
+type containerAppSecret struct {
+	Name        string `yaml:"name"`
+	Value       string `yaml:"value"`
+	KeyVaultURL string `yaml:"keyVaultUrl"`
+	Identity    string `yaml:"identity"`
+}
+
+// containerAppTemplate describes the app's revisions
+type containerAppTemplate struct {
+	Containers []containerAppContainer `yaml:"containers"`
+	Volumes    []containerAppVolume    `yaml:"volumes"`
+	Scale      containerAppScale       `yaml:"scale"`
+}
+
+// containerAppContainer is a single container in the app
+type containerAppContainer struct {
+	Name         string                    `yaml:"name"`
+	Image        string                    `yaml:"image"`
+	Command      []string                  `yaml:"command"`
+	Args         []string                  `yaml:"args"`
+	Resources    containerAppResources     `yaml:"resources"`
+	Env          []containerAppEnv         `yaml:"env"`
+	Probes       []containerAppProbe       `yaml:"probes"`
+	VolumeMounts []containerAppVolumeMount `yaml:"volumeMounts"`
+}
+
+// containerAppResources is the cpu and memory allocated to a container
+type containerAppResources struct {
+	CPU    float64 `yaml:"cpu"`
+	Memory string  `yaml:"memory"`
+}
+
+// containerAppEnv is an environment variable set on a container
+type containerAppEnv struct {
+	Name      string `yaml:"name"`
+	Value     string `yaml:"value"`
+	SecretRef string `yaml:"secretRef"`
+}
+
+// containerAppProbe is a liveness, readiness or startup probe
+type containerAppProbe struct {
+	Type                string                `yaml:"type"`
+	HTTPGet             *containerAppHTTPGet  `yaml:"httpGet"`
+	TCPSocket           *containerAppTCPProbe `yaml:"tcpSocket"`
+	InitialDelaySeconds int                   `yaml:"initialDelaySeconds"`
+	PeriodSeconds       int                   `yaml:"periodSeconds"`
+	TimeoutSeconds      int                   `yaml:"timeoutSeconds"`
+	FailureThreshold    int                   `yaml:"failureThreshold"`
+	SuccessThreshold    int                   `yaml:"successThreshold"`
+}
+
+// containerAppHTTPGet is an HTTP probe action
+type containerAppHTTPGet struct {
+	Path   string `yaml:"path"`
+	Port   int    `yaml:"port"`
+	Scheme string `yaml:"scheme"`
+}
+
+// containerAppTCPProbe is a TCP probe action
+type containerAppTCPProbe struct {
+	Port int `yaml:"port"`
+}
+
+// containerAppVolumeMount mounts a volume into a container
+type containerAppVolumeMount struct {
+	VolumeName string `yaml:"volumeName"`
+	MountPath  string `yaml:"mountPath"`
+	ReadOnly   bool   `yaml:"readOnly"`
+}
+
+// containerAppVolume is a volume available to the app's containers
+type containerAppVolume struct {
+	Name        string                     `yaml:"name"`
+	StorageType string                     `yaml:"storageType"`
+	StorageName string                     `yaml:"storageName"`
+	Secrets     []containerAppVolumeSecret `yaml:"secrets"`
+}
+
+// containerAppVolumeSecret maps a secret to a file in a Secret volume
+type containerAppVolumeSecret struct {
+	SecretRef string `yaml:"secretRef"`
+	Path      string `yaml:"path"`
+}
+
+// containerAppScale bounds the app's replica count
+type containerAppScale struct {
+	MinReplicas int `yaml:"minReplicas"`
+	MaxReplicas int `yaml:"maxReplicas"`
+}
+
+// parseContainerApp decodes a rendered template, rejecting fields the model
+// does not know about
+func parseContainerApp(filename string, content []byte) (*containerApp, error) {
+	var app containerApp
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&app); err != nil {
+		return nil, fmt.Errorf("failed to parse rendered template %s: %w", filename, err)
+	}
+	return &app, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// destroyScript is the name of the generated teardown script
+const destroyScript = "destroy.sh"
+
+// prometheusShare is the Azure File share holding the Prometheus data
+const prometheusShare = "prometheus-data"
+
+// generateDestroyCommands writes destroy.sh, the counterpart of deploy.sh, to
+// the output directory
+func generateDestroyCommands(config *Config) error {
+	scriptPath := filepath.Join(config.OutputDir, destroyScript)
+
+	script, err := renderDestroyScript(config)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(scriptPath, script, 0755); err != nil {
+		return fmt.Errorf("failed to create teardown script: %w", err)
+	}
+
+	// WriteFile keeps the mode of an existing file, so make sure it's executable
+	if err := os.Chmod(scriptPath, 0755); err != nil {
+		return fmt.Errorf("failed to make teardown script executable: %w", err)
+	}
+
+	fmt.Printf("Teardown script generated: %s\n", scriptPath)
+	return nil
+}
+
+// renderDestroyScript renders destroy.sh for the configured output format,
+// refusing to include any secret
+func renderDestroyScript(config *Config) ([]byte, error) {
+	marked, markers := markSensitive(config)
+	if err := checkScriptSecrets(filepath.Join(config.OutputDir, destroyScript), strings.Join(destroyCommands(marked), "\n"), markers); err != nil {
+		return nil, err
+	}
+	return []byte(strings.Join(destroyCommands(config), "\n") + "\n"), nil
+}
+
+// deployedPrometheus reports whether Prometheus was deployed, so that destroy
+// deletes it and its storage: from the state at statePath when apply recorded
+// any app, otherwise from prometheus.yaml in an output directory holding
+// rendered templates. Without either, -deploy-prometheus decides.
+func deployedPrometheus(config *Config, statePath string) (bool, error) {
+	state, err := loadState(statePath)
+	if err != nil {
+		return false, err
+	}
+	if len(state.Components) > 0 {
+		_, ok := state.Components[containerAppNames["prometheus.yaml"]]
+		return ok, nil
+	}
+
+	if _, err := os.Stat(filepath.Join(config.OutputDir, "bindplane.yaml")); err == nil {
+		_, err := os.Stat(filepath.Join(config.OutputDir, "prometheus.yaml"))
+		return err == nil, nil
+	}
+	return config.DeployPrometheus, nil
+}
+
+// destroyPlan describes what destroy.sh deletes, one step per line. The file
+// share is listed unless keepData is set.
+func destroyPlan(config *Config, keepData bool) []string {
+	how := "az containerapp delete for each component"
+	if config.OutputFormat == outputFormatTerraform {
+		how = "terraform destroy"
+	}
+
+	plan := []string{
+		fmt.Sprintf("Destroy plan: %s in resource group %s", how, config.ResourceGroup),
+	}
+	step := 0
+	add := func(format string, args ...any) {
+		step++
+		plan = append(plan, fmt.Sprintf("  %d. %s", step, fmt.Sprintf(format, args...)))
+	}
+
+	order := deployOrder(config)
+	for i := len(order) - 1; i >= 0; i-- {
+		add("Delete container app %s", containerAppNames[order[i]])
+	}
+	if config.DeployPrometheus {
+		add("Remove environment storage prometheus-pv from environment %s", environmentName(config))
+		if !keepData {
+			add("Delete file share %s in storage account %s", prometheusShare, config.StorageAccountName)
+		}
+	}
+	return plan
+}
+
+// destroyCommands deletes the deployment in reverse deployment order. The
+// Prometheus app and its environment storage are only included when they
+// were rendered. The script asks for confirmation unless run with --yes, and
+// deletes the Prometheus file share unless run with --keep-data.
+func destroyCommands(config *Config) []string {
+	resourceGroup := shellQuote(config.ResourceGroup)
+	kind := ""
+	if config.OutputFormat == outputFormatTerraform {
+		kind = "Terraform "
+	}
+
+	commands := []string{
+		"#!/bin/bash",
+		fmt.Sprintf("# Generated %steardown commands for Bindplane Azure Container Apps", kind),
+		"",
+		"set -e",
+		"",
+		"OUTPUT_DIR=" + shellQuote(config.OutputDir),
+		"RESOURCE_GROUP=" + resourceGroup,
+		"",
+		"YES=false",
+		"KEEP_DATA=false",
+		"for arg in \"$@\"; do",
+		"  case \"$arg\" in",
+		"    --yes|-y) YES=true ;;",
+		"    --keep-data) KEEP_DATA=true ;;",
+		"    *)",
+		"      echo \"Usage: $0 [--yes] [--keep-data]\" >&2",
+		"      exit 2",
+		"      ;;",
+		"  esac",
+		"done",
+		"",
+	}
+
+	// The confirmation lists the plan, with the file share step only when the
+	// data isn't kept
+	plan := destroyPlan(config, false)
+	keptPlan := destroyPlan(config, true)
+	commands = append(commands, "if [ \"$YES\" != true ]; then")
+	for _, line := range keptPlan {
+		commands = append(commands, "  echo "+shellQuote(line))
+	}
+	if len(plan) > len(keptPlan) {
+		commands = append(commands,
+			"  if [ \"$KEEP_DATA\" != true ]; then",
+			"    echo "+shellQuote(plan[len(plan)-1]),
+			"  fi",
+		)
+	}
+	commands = append(commands,
+		"  read -r -p \"Delete these resources? [y/N] \" REPLY || true",
+		"  case \"$REPLY\" in",
+		"    y|Y|yes|YES) ;;",
+		"    *)",
+		"      echo \"Aborted; nothing was deleted\"",
+		"      exit 1",
+		"      ;;",
+		"  esac",
+		"fi",
+		"",
+	)
+
+	if config.OutputFormat == outputFormatTerraform {
+		commands = append(commands, envFileCommands()...)
+		commands = append(commands, terraformVariableCommands(config)...)
+		commands = append(commands,
+			"",
+			"echo \"Destroying Bindplane with Terraform...\"",
+			"terraform -chdir=\"$OUTPUT_DIR\" init -input=false",
+			"terraform -chdir=\"$OUTPUT_DIR\" destroy -input=false -auto-approve",
+		)
+	} else {
+		commands = append(commands,
+			"# delete_app deletes a container app, skipping one that doesn't exist so",
+			"# that a partial deployment can be torn down",
+			"delete_app() {",
+			"  if az containerapp show --name \"$1\" --resource-group \"$RESOURCE_GROUP\" --output none 2>/dev/null; then",
+			"    echo \"Deleting container app $1\"",
+			"    az containerapp delete --name \"$1\" --resource-group \"$RESOURCE_GROUP\" --yes",
+			"  else",
+			"    echo \"Container app $1 does not exist\"",
+			"  fi",
+			"}",
+			"",
+			"echo \"Deleting Bindplane from Azure Container Apps...\"",
+		)
+		order := deployOrder(config)
+		for i := len(order) - 1; i >= 0; i-- {
+			commands = append(commands, "delete_app "+containerAppNames[order[i]])
+		}
+		if config.DeployPrometheus {
+			commands = append(commands,
+				"",
+				"ENV_NAME="+shellQuote(environmentName(config)),
+				"if az containerapp env storage show --name \"$ENV_NAME\" --resource-group \"$RESOURCE_GROUP\" --storage-name prometheus-pv --output none 2>/dev/null; then",
+				"  echo \"Removing environment storage prometheus-pv\"",
+				"  az containerapp env storage remove --name \"$ENV_NAME\" --resource-group \"$RESOURCE_GROUP\" --storage-name prometheus-pv --yes --output none",
+				"fi",
+			)
+		}
+	}
+
+	if config.DeployPrometheus {
+		// share-rm works through Azure Resource Manager, so no storage account key is needed
+		share := fmt.Sprintf("--storage-account %s --name %s", shellQuote(config.StorageAccountName), prometheusShare)
+		commands = append(commands,
+			"",
+			"if [ \"$KEEP_DATA\" = true ]; then",
+			fmt.Sprintf("  echo \"Keeping file share %s in storage account\" %s", prometheusShare, shellQuote(config.StorageAccountName)),
+			"elif az storage share-rm exists "+share+" --query exists --output tsv | grep -q true; then",
+			fmt.Sprintf("  echo \"Deleting file share %s\"", prometheusShare),
+			"  az storage share-rm delete "+share+" --include snapshots --yes",
+			"fi",
+		)
+	}
+
+	return append(commands,
+		"",
+		"echo \"Teardown complete!\"",
+	)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDestroyPlan(t *testing.T) {
+	config := iacTestConfig(t)
+
+	expected := []string{
+		"Destroy plan: az containerapp delete for each component in resource group test-rg",
+		"  1. Delete container app otelcol",
+		"  2. Delete container app bindplane",
+		"  3. Delete container app bindplane-jobs",
+		"  4. Delete container app bindplane-prometheus",
+		"  5. Delete container app bindplane-transform-agent",
+		"  6. Remove environment storage prometheus-pv from environment test-env",
+		"  7. Delete file share prometheus-data in storage account teststorageaccount",
+	}
+	if got := destroyPlan(config, false); !reflect.DeepEqual(got, expected) {
+		t.Errorf("destroyPlan() = %q, want %q", got, expected)
+	}
+	if got := destroyPlan(config, true); !reflect.DeepEqual(got, expected[:7]) {
+		t.Errorf("destroyPlan(keepData) = %q, want %q", got, expected[:7])
+	}
+
+	config.DeployPrometheus = false
+	if got := destroyPlan(config, false); !reflect.DeepEqual(got, []string{expected[0], expected[1], expected[2], expected[3], "  4. Delete container app bindplane-transform-agent"}) {
+		t.Errorf("Expected no Prometheus steps, got %q", got)
+	}
+}
+
+func TestDeployedPrometheus(t *testing.T) {
+	write := func(path string) {
+		if err := os.WriteFile(path, []byte("name: test\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saveState := func(path string, names ...string) {
+		state := &deployState{Version: stateVersion, Components: map[string]stateComponent{}}
+		for _, name := range names {
+			state.Components[name] = stateComponent{}
+		}
+		if err := state.save(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &Config{OutputDir: t.TempDir(), DeployPrometheus: true}
+	statePath := filepath.Join(config.OutputDir, stateFile)
+	check := func(name string, expected bool) {
+		t.Helper()
+		got, err := deployedPrometheus(config, statePath)
+		if err != nil {
+			t.Fatalf("%s: deployedPrometheus failed: %v", name, err)
+		}
+		if got != expected {
+			t.Errorf("%s: deployedPrometheus() = %v, want %v", name, got, expected)
+		}
+	}
+
+	check("nothing deployed falls back to the flag", true)
+	write(filepath.Join(config.OutputDir, "bindplane.yaml"))
+	check("output without prometheus.yaml", false)
+	config.DeployPrometheus = false
+	write(filepath.Join(config.OutputDir, "prometheus.yaml"))
+	check("output with prometheus.yaml", true)
+	saveState(statePath, "bindplane")
+	check("state without the Prometheus app", false)
+	saveState(statePath, "bindplane", "bindplane-prometheus")
+	check("state with the Prometheus app", true)
+}
+
+func TestDestroyCommandsWithoutPrometheus(t *testing.T) {
+	config := iacTestConfig(t)
+	config.DeployPrometheus = false
+
+	script := strings.Join(destroyCommands(config), "\n")
+	for _, unwanted := range []string{"bindplane-prometheus", "prometheus-pv", "share-rm"} {
+		if strings.Contains(script, unwanted) {
+			t.Errorf("Expected destroy.sh not to mention %s without prometheus, got:\n%s", unwanted, script)
+		}
+	}
+}
+
+func TestDestroyCommandsTerraform(t *testing.T) {
+	config := iacTestConfig(t)
+	config.OutputFormat = outputFormatTerraform
+
+	script, err := renderDestroyScript(config)
+	if err != nil {
+		t.Fatalf("renderDestroyScript failed: %v", err)
+	}
+	for _, want := range []string{
+		"terraform -chdir=\"$OUTPUT_DIR\" destroy -input=false -auto-approve",
+		"export TF_VAR_storage_account_key=\"${BINDPLANE_ACA_STORAGE_ACCOUNT_KEY:?",
+		"az storage share-rm delete --storage-account 'teststorageaccount' --name prometheus-data",
+	} {
+		if !strings.Contains(string(script), want) {
+			t.Errorf("Expected destroy.sh to contain %q, got:\n%s", want, script)
+		}
+	}
+	if strings.Contains(string(script), "az containerapp delete") {
+		t.Errorf("Expected Terraform to delete the apps, got:\n%s", script)
+	}
+}
+
+func TestDestroyScript(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not available")
+	}
+
+	config := iacTestConfig(t)
+	if err := generateDestroyCommands(config); err != nil {
+		t.Fatalf("Failed to generate teardown script: %v", err)
+	}
+	bin := writeFakeAZ(t)
+
+	destroy := func(stdin string, args ...string) ([]string, string, error) {
+		t.Helper()
+		log := filepath.Join(t.TempDir(), "az.log")
+		cmd := exec.Command(bash, append([]string{filepath.Join(config.OutputDir, destroyScript)}, args...)...)
+		cmd.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"), "AZ_LOG="+log,
+			"AZ_EXISTING=bindplane-jobs bindplane otelcol", "AZ_STORAGE=teststorageaccount/prometheus-data", "AZ_SHARE=1")
+		cmd.Stdin = strings.NewReader(stdin)
+		out, err := cmd.CombinedOutput()
+		calls, _ := os.ReadFile(log)
+		var deletes []string
+		for _, call := range strings.Split(string(calls), "\n") {
+			if fields := strings.Fields(call); len(fields) > 3 && (fields[1] == "delete" || fields[2] == "delete" || fields[3] == "remove") {
+				deletes = append(deletes, strings.Join(fields[:4], " "))
+			}
+		}
+		return deletes, string(out), err
+	}
+
+	// Declining the prompt deletes nothing
+	deletes, out, err := destroy("n\n")
+	if err == nil || len(deletes) != 0 {
+		t.Fatalf("Expected destroy.sh to abort, got %v, deleted %q\n%s", err, deletes, out)
+	}
+	if !strings.Contains(out, "Delete container app bindplane-transform-agent") || !strings.Contains(out, "Aborted") {
+		t.Errorf("Expected the plan and an abort message, got:\n%s", out)
+	}
+
+	// Apps that don't exist are skipped
+	expected := []string{
+		"containerapp delete --name otelcol",
+		"containerapp delete --name bindplane",
+		"containerapp delete --name bindplane-jobs",
+		"containerapp env storage remove",
+		"storage share-rm delete --storage-account",
+	}
+	deletes, out, err = destroy("y\n")
+	if err != nil {
+		t.Fatalf("destroy.sh failed: %v\n%s", err, out)
+	}
+	if !reflect.DeepEqual(deletes, expected) {
+		t.Errorf("destroy.sh deleted %q, want %q", deletes, expected)
+	}
+
+	deletes, out, err = destroy("", "--yes", "--keep-data")
+	if err != nil {
+		t.Fatalf("destroy.sh --yes --keep-data failed: %v\n%s", err, out)
+	}
+	if !reflect.DeepEqual(deletes, expected[:4]) {
+		t.Errorf("destroy.sh --keep-data deleted %q, want %q", deletes, expected[:4])
+	}
+	if strings.Contains(out, "Delete these resources?") {
+		t.Errorf("Expected --yes to skip the prompt, got:\n%s", out)
+	}
+
+	if _, out, err := destroy("", "--bogus"); err == nil || !strings.Contains(out, "Usage:") {
+		t.Errorf("Expected a usage error for an unknown option, got %v:\n%s", err, out)
+	}
+}
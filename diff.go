@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// secretsPath is the path of the container app secrets in a component
+const secretsPath = ".properties.configuration.secrets"
+
+// sequenceKeys are the fields that identify the items of a sequence of
+// mappings, such as env vars by name and probes by type, in order of
+// preference. Sequences whose items share none of them are compared by
+// position.
+var sequenceKeys = []string{"name", "volumeName", "type"}
+
+// componentDiff is the difference between the previous and the rendered
+// version of a component
+type componentDiff struct {
+	name string
+	// file is the template the component is rendered from, empty for a
+	// component that is no longer rendered
+	file    string
+	added   bool
+	removed bool
+	changes []fieldChange
+}
+
+// fieldChange is a single difference within a component
+type fieldChange struct {
+	// op is + for an added field, - for a removed one and ~ for a changed one
+	op       byte
+	path     string
+	from, to *yaml.Node
+}
+
+// previousComponent is a component loaded from a previous output
+type previousComponent struct {
+	doc *yaml.Node
+	// live is set for exports of a deployed app, which carry read-only
+	// properties and omit secret values
+	live bool
+}
+
+// loadPreviousComponents reads the components of a previous output: either
+// a generated output directory or a YAML file holding one or more container
+// apps, such as the output of az containerapp show --output yaml. They are
+// keyed by container app name.
+func loadPreviousComponents(path string) (map[string]previousComponent, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read previous output: %w", err)
+	}
+
+	var files []string
+	if info.IsDir() {
+		for _, filename := range templateFiles(&Config{DeployPrometheus: true}) {
+			if _, err := os.Stat(filepath.Join(path, filename)); err == nil {
+				files = append(files, filepath.Join(path, filename))
+			}
+		}
+	} else {
+		files = []string{path}
+	}
+
+	components := make(map[string]previousComponent)
+	for _, file := range files {
+		docs, err := readContainerApps(file)
+		if err != nil {
+			return nil, err
+		}
+		for name, doc := range docs {
+			components[name] = previousComponent{doc: doc, live: mappingValue(doc, "id") != nil}
+		}
+	}
+
+	return components, nil
+}
+
+// readContainerApps reads the container apps in a YAML or JSON file, keyed
+// by name. The file may hold several YAML documents, each an app or a list
+// of apps such as the output of az containerapp list.
+func readContainerApps(file string) (map[string]*yaml.Node, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+
+	apps := make(map[string]*yaml.Node)
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var root yaml.Node
+		err := decoder.Decode(&root)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		if len(root.Content) == 0 {
+			continue
+		}
+		docs := []*yaml.Node{root.Content[0]}
+		if root.Content[0].Kind == yaml.SequenceNode {
+			docs = root.Content[0].Content
+		}
+		for _, doc := range docs {
+			if doc.Kind != yaml.MappingNode {
+				continue
+			}
+			name := mappingValue(doc, "name")
+			if name == nil || name.Value == "" {
+				return nil, fmt.Errorf("%s:%d: container app has no name", file, doc.Line)
+			}
+			apps[name.Value] = doc
+		}
+	}
+	return apps, nil
+}
+
+// diffComponents compares the rendered components, in deploy order, with the
+// previous ones
+func diffComponents(previous map[string]previousComponent, rendered []*iacComponent) []componentDiff {
+	var diffs []componentDiff
+	seen := make(map[string]bool)
+	for _, component := range rendered {
+		seen[component.name] = true
+		before, ok := previous[component.name]
+		if !ok {
+			diffs = append(diffs, componentDiff{name: component.name, file: component.file, added: true})
+			continue
+		}
+		var changes []fieldChange
+		diffNodes("", before.doc, component.doc, before.live, &changes)
+		diffs = append(diffs, componentDiff{name: component.name, file: component.file, changes: changes})
+	}
+
+	var removed []string
+	for name := range previous {
+		if !seen[name] {
+			removed = append(removed, name)
+		}
+	}
+	slices.Sort(removed)
+	for _, name := range removed {
+		diffs = append(diffs, componentDiff{name: name, removed: true})
+	}
+
+	return diffs
+}
+
+// diffNodes appends the differences between from and to, found at path. When
+// from is a live export, fields it has that the templates don't set are
+// read-only or defaulted by Azure and are ignored, as are secret values,
+// which are never exported.
+func diffNodes(path string, from, to *yaml.Node, live bool, changes *[]fieldChange) {
+	from, to = resolveAlias(from), resolveAlias(to)
+
+	if from.Kind != to.Kind {
+		*changes = append(*changes, fieldChange{op: '~', path: path, from: from, to: to})
+		return
+	}
+
+	switch to.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(to.Content); i += 2 {
+			key := to.Content[i].Value
+			childPath := path + "." + key
+			before := mappingValue(from, key)
+			switch {
+			case before != nil:
+				diffNodes(childPath, before, to.Content[i+1], live, changes)
+			case live && isSecretField(childPath):
+			default:
+				*changes = append(*changes, fieldChange{op: '+', path: childPath, to: to.Content[i+1]})
+			}
+		}
+		if live {
+			return
+		}
+		for i := 0; i+1 < len(from.Content); i += 2 {
+			if mappingValue(to, from.Content[i].Value) == nil {
+				*changes = append(*changes, fieldChange{op: '-', path: path + "." + from.Content[i].Value, from: from.Content[i+1]})
+			}
+		}
+	case yaml.SequenceNode:
+		key := sequenceKey(from, to)
+		if key == "" {
+			for i := 0; i < max(len(from.Content), len(to.Content)); i++ {
+				childPath := fmt.Sprintf("%s[%d]", path, i)
+				switch {
+				case i >= len(from.Content):
+					*changes = append(*changes, fieldChange{op: '+', path: childPath, to: to.Content[i]})
+				case i >= len(to.Content):
+					*changes = append(*changes, fieldChange{op: '-', path: childPath, from: from.Content[i]})
+				default:
+					diffNodes(childPath, from.Content[i], to.Content[i], live, changes)
+				}
+			}
+			return
+		}
+
+		for _, item := range to.Content {
+			item = resolveAlias(item)
+			id := mappingValue(item, key)
+			if id == nil {
+				continue
+			}
+			childPath := fmt.Sprintf("%s[%s]", path, id.Value)
+			if before := keyedItem(from, key, id.Value); before != nil {
+				diffNodes(childPath, before, item, live, changes)
+			} else {
+				*changes = append(*changes, fieldChange{op: '+', path: childPath, to: item})
+			}
+		}
+		for _, item := range from.Content {
+			item = resolveAlias(item)
+			id := mappingValue(item, key)
+			if id == nil {
+				continue
+			}
+			if keyedItem(to, key, id.Value) == nil {
+				*changes = append(*changes, fieldChange{op: '-', path: fmt.Sprintf("%s[%s]", path, id.Value), from: item})
+			}
+		}
+	default:
+		if !scalarsEqual(from, to) {
+			*changes = append(*changes, fieldChange{op: '~', path: path, from: from, to: to})
+		}
+	}
+}
+
+// sequenceKey returns the field that identifies the items of both sequences,
+// or "" if they must be compared by position
+func sequenceKey(a, b *yaml.Node) string {
+	for _, key := range sequenceKeys {
+		if identifiedBy(a, key) && identifiedBy(b, key) {
+			return key
+		}
+	}
+	return ""
+}
+
+// identifiedBy reports whether every item of seq is a mapping with a unique,
+// non-empty scalar value for key
+func identifiedBy(seq *yaml.Node, key string) bool {
+	ids := make(map[string]bool, len(seq.Content))
+	for _, item := range seq.Content {
+		id := mappingValue(resolveAlias(item), key)
+		if id == nil || id.Kind != yaml.ScalarNode || id.Value == "" || ids[id.Value] {
+			return false
+		}
+		ids[id.Value] = true
+	}
+	return true
+}
+
+// keyedItem returns the item of seq whose key field is id
+func keyedItem(seq *yaml.Node, key, id string) *yaml.Node {
+	for _, item := range seq.Content {
+		item = resolveAlias(item)
+		if value := mappingValue(item, key); value != nil && value.Value == id {
+			return item
+		}
+	}
+	return nil
+}
+
+// scalarsEqual compares scalars by value, treating numbers such as 2 and 2.0
+// as equal
+func scalarsEqual(a, b *yaml.Node) bool {
+	if a.Value == b.Value {
+		return true
+	}
+	numeric := func(n *yaml.Node) bool { return n.ShortTag() == "!!int" || n.ShortTag() == "!!float" }
+	if numeric(a) && numeric(b) {
+		x, errX := strconv.ParseFloat(a.Value, 64)
+		y, errY := strconv.ParseFloat(b.Value, 64)
+		return errX == nil && errY == nil && x == y
+	}
+	return false
+}
+
+// isSecretField reports whether path is a secret's value, whose contents are
+// never shown
+func isSecretField(path string) bool {
+	return strings.HasPrefix(path, secretsPath+"[") && strings.HasSuffix(path, "].value")
+}
+
+// String describes the change, masking secret values
+func (c fieldChange) String() string {
+	path := strings.TrimPrefix(c.path, ".")
+	if isSecretField(c.path) {
+		switch c.op {
+		case '+':
+			return fmt.Sprintf("+ %s: (secret set)", path)
+		case '-':
+			return fmt.Sprintf("- %s: (secret removed)", path)
+		default:
+			return fmt.Sprintf("~ %s: (secret changed)", path)
+		}
+	}
+
+	mask := strings.HasPrefix(c.path, secretsPath)
+	switch c.op {
+	case '+':
+		return fmt.Sprintf("+ %s: %s", path, formatNode(c.to, mask))
+	case '-':
+		return fmt.Sprintf("- %s: %s", path, formatNode(c.from, mask))
+	default:
+		return fmt.Sprintf("~ %s: %s => %s", path, formatNode(c.from, mask), formatNode(c.to, mask))
+	}
+}
+
+// formatNode renders node on a single line in YAML flow style. With mask set,
+// secret values within it are replaced by secretMask.
+func formatNode(node *yaml.Node, mask bool) string {
+	out, err := yaml.Marshal(flowCopy(node, mask))
+	if err != nil {
+		return "(" + err.Error() + ")"
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// flowCopy copies node, setting flow style and masking secret values
+func flowCopy(node *yaml.Node, mask bool) *yaml.Node {
+	node = resolveAlias(node)
+	c := *node
+	c.HeadComment, c.LineComment, c.FootComment = "", "", ""
+	if c.Kind == yaml.MappingNode || c.Kind == yaml.SequenceNode {
+		c.Style = yaml.FlowStyle
+	}
+	c.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		c.Content[i] = flowCopy(child, mask)
+		if mask && c.Kind == yaml.MappingNode && i%2 == 1 && node.Content[i-1].Value == "value" {
+			c.Content[i] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: secretMask}
+		}
+	}
+	return &c
+}
+
+// writeDiff prints the differences and reports whether there were any
+func writeDiff(w io.Writer, diffs []componentDiff) bool {
+	changed := 0
+	for _, diff := range diffs {
+		switch {
+		case diff.added:
+			fmt.Fprintf(w, "+ %s (%s): new component\n", diff.name, diff.file)
+		case diff.removed:
+			fmt.Fprintf(w, "- %s: no longer rendered\n", diff.name)
+		case len(diff.changes) > 0:
+			fmt.Fprintf(w, "~ %s (%s)\n", diff.name, diff.file)
+			for _, change := range diff.changes {
+				fmt.Fprintf(w, "    %s\n", change)
+			}
+		default:
+			continue
+		}
+		changed++
+	}
+
+	if changed == 0 {
+		fmt.Fprintf(w, "No differences in %d components\n", len(diffs))
+		return false
+	}
+	fmt.Fprintf(w, "%d of %d components differ\n", changed, len(diffs))
+	return true
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseDiffDoc parses a container app document for the diff tests
+func parseDiffDoc(t *testing.T, content string) *yaml.Node {
+	t.Helper()
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(content), &root); err != nil {
+		t.Fatalf("Failed to parse document: %v", err)
+	}
+	return root.Content[0]
+}
+
+// diffLines diffs two documents and returns the changes as printed
+func diffLines(t *testing.T, from, to string, live bool) []string {
+	t.Helper()
+	var changes []fieldChange
+	diffNodes("", parseDiffDoc(t, from), parseDiffDoc(t, to), live, &changes)
+	var lines []string
+	for _, change := range changes {
+		lines = append(lines, change.String())
+	}
+	return lines
+}
+
+const diffTestApp = `name: bindplane
+properties:
+  configuration:
+    secrets:
+      - name: license
+        value: "old-license"
+      - name: postgres-password
+        value: "same-password"
+  template:
+    containers:
+      - name: server
+        image: bindplane:1.0
+        resources:
+          cpu: 2.0
+        env:
+          - name: A
+            value: "1"
+          - name: B
+            value: "2"
+          - name: C
+            secretRef: license
+`
+
+func TestDiffNodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		to       string
+		expected []string
+	}{
+		{
+			name:     "unchanged",
+			to:       diffTestApp,
+			expected: nil,
+		},
+		{
+			name: "env vars matched by name and numbers by value",
+			to: strings.NewReplacer(
+				"          - name: A\n            value: \"1\"\n          - name: B\n            value: \"2\"\n",
+				"          - name: B\n            value: \"2\"\n          - name: A\n            value: \"1\"\n",
+				"cpu: 2.0", "cpu: 2",
+			).Replace(diffTestApp),
+			expected: nil,
+		},
+		{
+			name: "changed, added and removed env vars",
+			to: strings.NewReplacer(
+				"            value: \"2\"\n", "            value: \"3\"\n",
+				"          - name: A\n            value: \"1\"\n", "          - name: D\n            value: \"4\"\n",
+			).Replace(diffTestApp),
+			expected: []string{
+				"+ properties.template.containers[server].env[D]: {name: D, value: \"4\"}",
+				"~ properties.template.containers[server].env[B].value: \"2\" => \"3\"",
+				"- properties.template.containers[server].env[A]: {name: A, value: \"1\"}",
+			},
+		},
+		{
+			name: "secret values",
+			to: strings.NewReplacer(
+				"old-license", "new-license",
+				"      - name: postgres-password\n        value: \"same-password\"\n", "      - name: session-secret\n        value: \"new-secret\"\n",
+			).Replace(diffTestApp),
+			expected: []string{
+				"~ properties.configuration.secrets[license].value: (secret changed)",
+				"+ properties.configuration.secrets[session-secret]: {name: session-secret, value: '" + secretMask + "'}",
+				"- properties.configuration.secrets[postgres-password]: {name: postgres-password, value: '" + secretMask + "'}",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffLines(t, diffTestApp, tt.to, false)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("diff =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.expected, "\n"))
+			}
+			for _, line := range got {
+				for _, secret := range []string{"old-license", "new-license", "same-password", "new-secret"} {
+					if strings.Contains(line, secret) {
+						t.Errorf("Diff shows secret value %q: %s", secret, line)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDiffNodesAliases(t *testing.T) {
+	from := `name: bindplane
+x-env: &a {name: A, value: "1"}
+x-removed: &c {name: C, value: "3"}
+properties:
+  env:
+    - *a
+    - name: B
+      value: "2"
+    - *c
+`
+	to := strings.NewReplacer(`value: "2"`, `value: "4"`, "    - *c\n", "").Replace(from)
+
+	expected := []string{
+		"~ properties.env[B].value: \"2\" => \"4\"",
+		"- properties.env[C]: &c {name: C, value: \"3\"}",
+	}
+	if got := diffLines(t, from, to, false); !reflect.DeepEqual(got, expected) {
+		t.Errorf("diff =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestDiffNodesLive(t *testing.T) {
+	// az containerapp show adds read-only properties and omits secret values
+	live := `id: /subscriptions/test/resourceGroups/test-rg/providers/Microsoft.App/containerApps/bindplane
+name: bindplane
+systemData:
+  createdBy: someone
+properties:
+  provisioningState: Succeeded
+  configuration:
+    secrets:
+      - name: license
+      - name: postgres-password
+  template:
+    containers:
+      - name: server
+        image: bindplane:1.0
+        probes: []
+        resources:
+          cpu: 2.0
+          ephemeralStorage: 8Gi
+        env:
+          - name: A
+            value: "1"
+          - name: B
+            value: "2"
+          - name: C
+            secretRef: license
+`
+	if got := diffLines(t, live, diffTestApp, true); len(got) != 0 {
+		t.Errorf("Expected no differences from the live app, got:\n%s", strings.Join(got, "\n"))
+	}
+
+	got := diffLines(t, live, strings.Replace(diffTestApp, "bindplane:1.0", "bindplane:1.1", 1), true)
+	expected := []string{"~ properties.template.containers[server].image: bindplane:1.0 => bindplane:1.1"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("diff = %q, want %q", got, expected)
+	}
+}
+
+func TestLoadPreviousComponents(t *testing.T) {
+	dir := t.TempDir()
+
+	// A file of several apps exported with az containerapp show
+	export := filepath.Join(dir, "export.yaml")
+	content := "id: /subscriptions/test/apps/bindplane\nname: bindplane\n---\nname: otelcol\n"
+	if err := os.WriteFile(export, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write export: %v", err)
+	}
+	components, err := loadPreviousComponents(export)
+	if err != nil {
+		t.Fatalf("loadPreviousComponents failed: %v", err)
+	}
+	if len(components) != 2 || !components["bindplane"].live || components["otelcol"].live {
+		t.Errorf("Unexpected components: %+v", components)
+	}
+
+	// An output directory, ignoring files that aren't components
+	outputDir := filepath.Join(dir, "out")
+	if err := os.Mkdir(outputDir, 0755); err != nil {
+		t.Fatalf("Failed to create output directory: %v", err)
+	}
+	for name, content := range map[string]string{"jobs.yaml": "name: bindplane-jobs\n", "main.bicep": "param x string\n"} {
+		if err := os.WriteFile(filepath.Join(outputDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	components, err = loadPreviousComponents(outputDir)
+	if err != nil {
+		t.Fatalf("loadPreviousComponents failed: %v", err)
+	}
+	if _, ok := components["bindplane-jobs"]; !ok || len(components) != 1 {
+		t.Errorf("Unexpected components: %+v", components)
+	}
+
+	if err := os.WriteFile(export, []byte("type: Microsoft.App/containerApps\n"), 0644); err != nil {
+		t.Fatalf("Failed to write export: %v", err)
+	}
+	if _, err := loadPreviousComponents(export); err == nil || !strings.Contains(err.Error(), "no name") {
+		t.Errorf("Expected an error for an app without a name, got: %v", err)
+	}
+}
+
+func TestWriteDiff(t *testing.T) {
+	config := iacTestConfig(t)
+	data := iacTestData()
+	rendered, err := renderComponentsWith(config, data)
+	if err != nil {
+		t.Fatalf("renderComponentsWith failed: %v", err)
+	}
+
+	previous := make(map[string]previousComponent)
+	for _, component := range rendered[1:] {
+		previous[component.name] = previousComponent{doc: component.doc}
+	}
+	previous["old-app"] = previousComponent{doc: parseDiffDoc(t, "name: old-app\n")}
+
+	var out bytes.Buffer
+	if !writeDiff(&out, diffComponents(previous, rendered)) {
+		t.Fatal("Expected differences")
+	}
+	expected := "+ bindplane-transform-agent (transform-agent.yaml): new component\n" +
+		"- old-app: no longer rendered\n" +
+		"2 of 6 components differ\n"
+	if out.String() != expected {
+		t.Errorf("writeDiff() =\n%s\nwant\n%s", out.String(), expected)
+	}
+	assertNoSecrets(t, out.Bytes(), config, data)
+}
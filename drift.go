@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Status of a container app in a drift report
+const (
+	driftInSync     = "in-sync"
+	driftDrifted    = "drifted"
+	driftMissing    = "missing"
+	driftUnexpected = "unexpected"
+)
+
+// driftReport compares the live container apps with the rendered ones. It
+// is printed as JSON with -json.
+type driftReport struct {
+	// Source is where the live apps were read from: arm, or the path of the
+	// exported apps
+	Source     string           `json:"source"`
+	CheckedAt  time.Time        `json:"checkedAt"`
+	Drifted    bool             `json:"drifted"`
+	Components []componentDrift `json:"components"`
+}
+
+// componentDrift is the drift of one container app. Status is missing for a
+// rendered app that isn't deployed, and unexpected for a deployed Bindplane
+// app that is no longer rendered.
+type componentDrift struct {
+	Name    string        `json:"name"`
+	File    string        `json:"file,omitempty"`
+	Status  string        `json:"status"`
+	Changes []driftChange `json:"changes,omitempty"`
+}
+
+// driftChange is a field that differs. Op is changed, missing for a rendered
+// field the live app doesn't have, or unexpected for a live item the
+// templates don't render, such as an env var added in the portal.
+type driftChange struct {
+	Op       string `json:"op"`
+	Path     string `json:"path"`
+	Live     any    `json:"live,omitempty"`
+	Rendered any    `json:"rendered,omitempty"`
+
+	change fieldChange
+}
+
+// driftOps names the fieldChange ops from the live app's point of view
+var driftOps = map[byte]string{'~': "changed", '+': driftMissing, '-': driftUnexpected}
+
+// loadLiveComponents reads container apps exported with az containerapp show
+// or list, as JSON or YAML, from a file or the files of a directory
+func loadLiveComponents(path string) (map[string]*yaml.Node, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read live container apps: %w", err)
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files = nil
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read live container apps: %w", err)
+		}
+		for _, entry := range entries {
+			switch filepath.Ext(entry.Name()) {
+			case ".json", ".yaml", ".yml":
+				if !entry.IsDir() {
+					files = append(files, filepath.Join(path, entry.Name()))
+				}
+			}
+		}
+	}
+
+	live := make(map[string]*yaml.Node)
+	for _, file := range files {
+		apps, err := readContainerApps(file)
+		if err != nil {
+			return nil, err
+		}
+		for name, doc := range apps {
+			live[name] = doc
+		}
+	}
+	return live, nil
+}
+
+// fetchLiveComponents gets the Bindplane container apps deployed in the
+// configured resource group from Azure Resource Manager. Apps that don't
+// exist are left out.
+func fetchLiveComponents(ctx context.Context, client *armClient, config *Config) (map[string]*yaml.Node, error) {
+	live := make(map[string]*yaml.Node)
+	for _, filename := range deployOrder(&Config{DeployPrometheus: true}) {
+		name := containerAppNames[filename]
+		id, err := containerAppID(config, name)
+		if err != nil {
+			return nil, err
+		}
+		resp, body, err := client.do(ctx, http.MethodGet, client.resourceURL(id, containerAppsAPIVersion), nil)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get container app %s: %w", name, err)
+		}
+		var root yaml.Node
+		if err := yaml.Unmarshal(body, &root); err != nil || len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("failed to parse container app %s", name)
+		}
+		live[name] = root.Content[0]
+	}
+	return live, nil
+}
+
+// detectDrift compares the live apps with the rendered components. Live apps
+// that aren't Bindplane components are ignored, and so are secret values,
+// which Azure never returns.
+func detectDrift(source string, live map[string]*yaml.Node, rendered []*iacComponent) *driftReport {
+	previous := make(map[string]previousComponent)
+	for _, name := range containerAppNames {
+		if doc, ok := live[name]; ok {
+			previous[name] = previousComponent{doc: normalizeApp(doc), live: true}
+		}
+	}
+	var normalized []*iacComponent
+	for _, component := range rendered {
+		normalized = append(normalized, &iacComponent{name: component.name, file: component.file, doc: normalizeApp(component.doc)})
+	}
+
+	report := &driftReport{Source: source, CheckedAt: time.Now().UTC(), Components: []componentDrift{}}
+	for _, diff := range diffComponents(previous, normalized) {
+		drift := componentDrift{Name: diff.name, File: diff.file, Status: driftInSync}
+		switch {
+		case diff.added:
+			drift.Status = driftMissing
+		case diff.removed:
+			drift.Status = driftUnexpected
+			drift.File = fileOfApp(diff.name)
+		case len(diff.changes) > 0:
+			drift.Status = driftDrifted
+			for _, change := range diff.changes {
+				drift.Changes = append(drift.Changes, newDriftChange(change))
+			}
+		}
+		if drift.Status != driftInSync {
+			report.Drifted = true
+		}
+		report.Components = append(report.Components, drift)
+	}
+	return report
+}
+
+// fileOfApp returns the template file of a container app name
+func fileOfApp(name string) string {
+	for filename, app := range containerAppNames {
+		if app == name {
+			return filename
+		}
+	}
+	return ""
+}
+
+// newDriftChange converts a change from the live to the rendered app,
+// masking secret values
+func newDriftChange(change fieldChange) driftChange {
+	mask := strings.HasPrefix(change.path, secretsPath)
+	value := func(node *yaml.Node) any {
+		if node == nil {
+			return nil
+		}
+		if isSecretField(change.path) {
+			return secretMask
+		}
+		return jsonValue(flowCopy(node, mask), func(s string) any { return s })
+	}
+	return driftChange{
+		Op:       driftOps[change.op],
+		Path:     strings.TrimPrefix(change.path, "."),
+		Live:     value(change.from),
+		Rendered: value(change.to),
+		change:   change,
+	}
+}
+
+// normalizeApp returns a copy of a container app with the values Azure
+// reports in another form normalized: the location is lowercased without
+// spaces, as in eastus for East US, and resource IDs, which ARM may return
+// in another case, are lowercased
+func normalizeApp(doc *yaml.Node) *yaml.Node {
+	normalized := normalizeNode(doc)
+	if location := mappingValue(normalized, "location"); location != nil && location.Kind == yaml.ScalarNode {
+		location.Value = strings.ToLower(strings.ReplaceAll(location.Value, " ", ""))
+	}
+	return normalized
+}
+
+// normalizeNode copies node, lowercasing resource IDs in keys and values and
+// dropping the quoting of scalars
+func normalizeNode(node *yaml.Node) *yaml.Node {
+	node = resolveAlias(node)
+	c := *node
+	if c.Kind == yaml.ScalarNode {
+		// JSON exports quote every string
+		c.Style = 0
+		if strings.HasPrefix(strings.ToLower(c.Value), "/subscriptions/") {
+			c.Value = strings.ToLower(c.Value)
+		}
+	}
+	c.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		c.Content[i] = normalizeNode(child)
+	}
+	return &c
+}
+
+// writeDriftReport prints the report, as JSON when asJSON is set
+func writeDriftReport(w io.Writer, report *driftReport, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	drifted := 0
+	for _, drift := range report.Components {
+		switch drift.Status {
+		case driftMissing:
+			fmt.Fprintf(w, "+ %s (%s): not deployed\n", drift.Name, drift.File)
+		case driftUnexpected:
+			fmt.Fprintf(w, "- %s: deployed but no longer rendered\n", drift.Name)
+		case driftDrifted:
+			fmt.Fprintf(w, "~ %s (%s)\n", drift.Name, drift.File)
+			for _, change := range drift.Changes {
+				fmt.Fprintf(w, "    %s\n", change.change)
+			}
+		default:
+			continue
+		}
+		drifted++
+	}
+
+	if drifted == 0 {
+		fmt.Fprintf(w, "No drift in %d container apps (source: %s)\n", len(report.Components), report.Source)
+		return nil
+	}
+	fmt.Fprintf(w, "%d of %d container apps have drifted, shown as live => rendered (source: %s)\n", drifted, len(report.Components), report.Source)
+	return nil
+}
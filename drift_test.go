@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// liveApps returns the rendered components as Azure Resource Manager returns
+// them: with an ID and read-only properties, the location's display name,
+// resource IDs in another case and secrets without values
+func liveApps(t *testing.T, components []*iacComponent) map[string]map[string]any {
+	t.Helper()
+	apps := make(map[string]map[string]any)
+	for _, component := range components {
+		content, err := json.Marshal(jsonValue(component.doc, func(s string) any { return s }))
+		if err != nil {
+			t.Fatal(err)
+		}
+		content = bytes.ReplaceAll(content, []byte("/resourceGroups/"), []byte("/resourcegroups/"))
+		var app map[string]any
+		if err := json.Unmarshal(content, &app); err != nil {
+			t.Fatal(err)
+		}
+
+		app["id"] = "/subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.App/containerApps/" + component.name
+		app["location"] = "East US"
+		properties := app["properties"].(map[string]any)
+		properties["provisioningState"] = "Succeeded"
+		properties["latestRevisionName"] = component.name + "--rev1"
+		secrets, _ := properties["configuration"].(map[string]any)["secrets"].([]any)
+		for _, secret := range secrets {
+			delete(secret.(map[string]any), "value")
+		}
+		apps[component.name] = app
+	}
+	return apps
+}
+
+// liveContainer returns the first container of a live app
+func liveContainer(app map[string]any) map[string]any {
+	return app["properties"].(map[string]any)["template"].(map[string]any)["containers"].([]any)[0].(map[string]any)
+}
+
+// driftedLiveApps returns the live apps of the test config with the bindplane
+// logging level set to info, an env var added to jobs, otelcol missing and an
+// unrelated app
+func driftedLiveApps(t *testing.T) map[string]map[string]any {
+	t.Helper()
+	components, err := renderComponents(iacTestConfig(t), iacTestData())
+	if err != nil {
+		t.Fatalf("renderComponents failed: %v", err)
+	}
+	apps := liveApps(t, components)
+
+	for _, item := range liveContainer(apps["bindplane"])["env"].([]any) {
+		if env := item.(map[string]any); env["name"] == "BINDPLANE_LOGGING_LEVEL" {
+			env["value"] = "info"
+		}
+	}
+	container := liveContainer(apps["bindplane-jobs"])
+	container["env"] = append(container["env"].([]any), map[string]any{"name": "EXTRA", "value": "1"})
+	delete(apps, "otelcol")
+	apps["other-app"] = map[string]any{"name": "other-app", "id": "/subscriptions/x/other-app"}
+	return apps
+}
+
+func TestDetectDrift(t *testing.T) {
+	config := iacTestConfig(t)
+	components, err := renderComponents(config, iacTestData())
+	if err != nil {
+		t.Fatalf("renderComponents failed: %v", err)
+	}
+
+	// Exported apps without changes don't drift
+	content, err := json.Marshal(liveApps(t, components)["bindplane"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "bindplane.json")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	live, err := loadLiveComponents(path)
+	if err != nil {
+		t.Fatalf("loadLiveComponents failed: %v", err)
+	}
+	report := detectDrift(path, live, components[3:4])
+	if report.Drifted || report.Components[0].Status != driftInSync {
+		t.Errorf("Expected an unchanged export not to drift, got %+v", report)
+	}
+
+	content, err = json.Marshal(slicesOfApps(driftedLiveApps(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if live, err = loadLiveComponents(path); err != nil {
+		t.Fatalf("loadLiveComponents failed: %v", err)
+	}
+	config.DeployPrometheus = false
+	if components, err = renderComponents(config, iacTestData()); err != nil {
+		t.Fatalf("renderComponents failed: %v", err)
+	}
+	report = detectDrift("arm", live, components)
+
+	statuses := make(map[string]string)
+	for _, drift := range report.Components {
+		statuses[drift.Name] = drift.Status
+	}
+	expected := map[string]string{
+		"bindplane-transform-agent": driftInSync,
+		"bindplane-jobs":            driftDrifted,
+		"bindplane":                 driftDrifted,
+		"otelcol":                   driftMissing,
+		"bindplane-prometheus":      driftUnexpected,
+	}
+	if !report.Drifted || !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("detectDrift statuses = %v, want %v", statuses, expected)
+	}
+
+	var out bytes.Buffer
+	if err := writeDriftReport(&out, report, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"~ bindplane (bindplane.yaml)\n    ~ properties.template.containers[server].env[BINDPLANE_LOGGING_LEVEL].value: info => debug\n",
+		"~ bindplane-jobs (jobs.yaml)\n    - properties.template.containers[",
+		"].env[EXTRA]: {name: EXTRA, value: \"1\"}\n",
+		"+ otelcol (otelcol.yaml): not deployed\n",
+		"- bindplane-prometheus: deployed but no longer rendered\n",
+		"4 of 5 container apps have drifted, shown as live => rendered (source: arm)\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected the report to contain %q, got:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "location") || strings.Contains(out.String(), "managedEnvironmentId") {
+		t.Errorf("Expected the location and resource IDs to be normalized, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := writeDriftReport(&out, report, true); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Drifted    bool `json:"drifted"`
+		Components []struct {
+			Name    string `json:"name"`
+			Status  string `json:"status"`
+			Changes []struct {
+				Op       string `json:"op"`
+				Path     string `json:"path"`
+				Live     any    `json:"live"`
+				Rendered any    `json:"rendered"`
+			} `json:"changes"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("Expected a JSON report, got %v:\n%s", err, out.String())
+	}
+	bindplane := decoded.Components[2]
+	if !decoded.Drifted || bindplane.Name != "bindplane" || len(bindplane.Changes) != 1 {
+		t.Fatalf("Unexpected JSON report:\n%s", out.String())
+	}
+	if change := bindplane.Changes[0]; change.Op != "changed" || change.Live != "info" || change.Rendered != "debug" || change.Path != "properties.template.containers[server].env[BINDPLANE_LOGGING_LEVEL].value" {
+		t.Errorf("Unexpected bindplane change: %+v", change)
+	}
+}
+
+// slicesOfApps returns the apps as a list, like az containerapp list
+func slicesOfApps(apps map[string]map[string]any) []any {
+	var list []any
+	for _, app := range apps {
+		list = append(list, app)
+	}
+	return list
+}
+
+func TestRunDrift(t *testing.T) {
+	arm := newMockARM(t)
+	for name, app := range driftedLiveApps(t) {
+		content, err := json.Marshal(app)
+		if err != nil {
+			t.Fatal(err)
+		}
+		arm.live[name] = string(content)
+	}
+
+	args := append(cliTestArgs(t.TempDir()),
+		"-deploy-prometheus", "-json",
+		"-arm-endpoint", arm.URL, "-arm-authority-host", arm.URL,
+		"-arm-tenant-id", "test-tenant", "-arm-client-id", "test-client", "-arm-client-secret", "test-secret",
+	)
+	if err := runDrift(args); !errors.Is(err, errDifferences) {
+		t.Errorf("Expected drift to report differences, got: %v", err)
+	}
+
+	var gets []string
+	for _, request := range arm.requests {
+		gets = append(gets, request[strings.LastIndex(request, "/")+1:])
+	}
+	expected := []string{"bindplane-transform-agent", "bindplane-prometheus", "bindplane-jobs", "bindplane", "otelcol"}
+	if !reflect.DeepEqual(gets, expected) {
+		t.Errorf("drift got %q, want %q", gets, expected)
+	}
+}
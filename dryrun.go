@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// writeDryRun writes every component, rendered with its secrets masked, to w
+// as a multi-document YAML stream in deploy order, followed by the deploy plan
+// as comments. Nothing is written to the output directory.
+func writeDryRun(w io.Writer, config *Config, data *TemplateData) error {
+	masked := maskSensitive(data)
+
+	var out bytes.Buffer
+	for _, filename := range deployOrder(config) {
+		content, err := renderTemplate(config, masked, filename)
+		if err != nil {
+			return fmt.Errorf("failed to process template %s: %w", filename, err)
+		}
+
+		fmt.Fprintf(&out, "---\n# Source: %s\n", filename)
+		out.Write(content)
+		if !bytes.HasSuffix(content, []byte("\n")) {
+			out.WriteString("\n")
+		}
+	}
+
+	// The deploy script is rendered to check it, even though only the plan is shown
+	if _, err := renderDeploymentScript(config); err != nil {
+		return err
+	}
+
+	out.WriteString("\n")
+	for _, line := range deployPlan(config) {
+		fmt.Fprintf(&out, "# %s\n", line)
+	}
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// deployPlan describes what deploy.sh does, one step per line
+func deployPlan(config *Config) []string {
+	var header string
+	switch config.OutputFormat {
+	case outputFormatBicep:
+		header = fmt.Sprintf("az deployment group create --name %s with %s once per container app", deploymentName, bicepFile)
+	case outputFormatARM:
+		header = fmt.Sprintf("az deployment group create --name %s with %s once per container app", deploymentName, armFile)
+	case outputFormatTerraform:
+		header = "terraform apply once per container app"
+	default:
+		header = "az containerapp create, or update when it exists, for each component"
+	}
+
+	plan := []string{
+		fmt.Sprintf("Deploy plan: %s in resource group %s, environment %s", header, config.ResourceGroup, environmentName(config)),
+	}
+	step := 0
+	add := func(format string, args ...any) {
+		step++
+		plan = append(plan, fmt.Sprintf("  %d. %s", step, fmt.Sprintf(format, args...)))
+	}
+
+	if config.DeployPrometheus {
+		add("Set environment storage prometheus-pv to file share prometheus-data in storage account %s", config.StorageAccountName)
+	}
+	// Every format deploys the apps one at a time, waiting for each
+	for _, filename := range deployOrder(config) {
+		if config.HealthTimeout > 0 {
+			add("Create or update container app %s from %s, then wait up to %s for it to become healthy", containerAppNames[filename], filename, config.HealthTimeout)
+		} else {
+			add("Create or update container app %s from %s", containerAppNames[filename], filename)
+		}
+	}
+	return plan
+}
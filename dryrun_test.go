@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestWriteDryRun(t *testing.T) {
+	config := iacTestConfig(t)
+	data := iacTestData()
+
+	var out bytes.Buffer
+	if err := writeDryRun(&out, config, data); err != nil {
+		t.Fatalf("writeDryRun failed: %v", err)
+	}
+
+	assertNoSecrets(t, out.Bytes(), config, data)
+	if !strings.Contains(out.String(), `value: "`+secretMask+`"`) {
+		t.Errorf("Expected masked secret values, got:\n%s", out.String())
+	}
+
+	// The stream parses as one document per component, in deploy order
+	var names []string
+	decoder := yaml.NewDecoder(&out)
+	for {
+		var doc struct {
+			Name string `yaml:"name"`
+		}
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to parse dry run output: %v", err)
+		}
+		names = append(names, doc.Name)
+	}
+	expected := []string{"bindplane-transform-agent", "bindplane-prometheus", "bindplane-jobs", "bindplane", "otelcol"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Dry run documents = %q, want %q", names, expected)
+	}
+}
+
+func TestDeployPlan(t *testing.T) {
+	config := iacTestConfig(t)
+	config.OutputFormat = outputFormatBicep
+
+	expected := []string{
+		"Deploy plan: az deployment group create --name bindplane-aca with main.bicep once per container app in resource group test-rg, environment test-env",
+		"  1. Set environment storage prometheus-pv to file share prometheus-data in storage account teststorageaccount",
+		"  2. Create or update container app bindplane-transform-agent from transform-agent.yaml",
+		"  3. Create or update container app bindplane-prometheus from prometheus.yaml",
+		"  4. Create or update container app bindplane-jobs from jobs.yaml",
+		"  5. Create or update container app bindplane from bindplane.yaml",
+		"  6. Create or update container app otelcol from otelcol.yaml",
+	}
+	if got := deployPlan(config); !reflect.DeepEqual(got, expected) {
+		t.Errorf("deployPlan() = %q, want %q", got, expected)
+	}
+
+	// Every format waits for each app before deploying the next
+	config.HealthTimeout = 5 * time.Minute
+	want := "  2. Create or update container app bindplane-transform-agent from transform-agent.yaml, then wait up to 5m0s for it to become healthy"
+	for _, format := range outputFormats {
+		config.OutputFormat = format
+		if got := deployPlan(config); len(got) != 7 || got[2] != want {
+			t.Errorf("Expected each %s step to wait %q, got %q", format, want, got)
+		}
+	}
+}
+
+func TestRunGenerateDryRunWritesNothing(t *testing.T) {
+	outputDir := filepath.Join(t.TempDir(), "out")
+	if err := runGenerate(append(cliTestArgs(outputDir), "-dry-run")); err != nil {
+		t.Fatalf("generate -dry-run failed: %v", err)
+	}
+	if _, err := os.Stat(outputDir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected -dry-run not to create %s, got: %v", outputDir, err)
+	}
+}
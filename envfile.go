@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// envFile is the file next to deploy.sh that the script loads secrets from
+const envFile = ".env"
+
+// envFileCommands loads .env from the script's directory, refusing to use it
+// unless only its owner can read it
+func envFileCommands() []string {
+	return []string{
+		"# Secrets may be set in the environment or in .env next to this script",
+		"SCRIPT_DIR=\"$(cd \"$(dirname \"${BASH_SOURCE[0]}\")\" && pwd)\"",
+		"if [ -f \"$SCRIPT_DIR/" + envFile + "\" ]; then",
+		"  if [ -z \"$(find \"$SCRIPT_DIR/" + envFile + "\" -perm 600)\" ]; then",
+		"    echo \"$SCRIPT_DIR/" + envFile + " must have 0600 permissions\" >&2",
+		"    exit 1",
+		"  fi",
+		"  set -a",
+		"  . \"$SCRIPT_DIR/" + envFile + "\"",
+		"  set +a",
+		"fi",
+	}
+}
+
+// writeEnvFile writes the secrets the deploy script reads to .env in the
+// output directory with 0600 permissions. Secrets given as Key Vault
+// references are read from Key Vault instead, so nothing is written for them.
+// When there is nothing to write, a .env left by an earlier run is removed so
+// the script doesn't load its old secrets.
+func writeEnvFile(config *Config) error {
+	// The YAML output carries the template secrets itself
+	yamlOutput := config.OutputFormat == "" || config.OutputFormat == outputFormatYAML
+
+	var lines []string
+	for _, p := range scriptParameters(config) {
+		if p.templated() && yamlOutput {
+			continue
+		}
+		if p.keyVaultURI(config) != "" || p.value(config) == "" {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s=%s", envName(p.flag), shellQuote(p.value(config))))
+	}
+	envPath := filepath.Join(config.OutputDir, envFile)
+	if len(lines) == 0 {
+		err := os.Remove(envPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to remove %s: %w", envPath, err)
+		}
+		fmt.Printf("Removed: %s (no secrets left to write)\n", envPath)
+		return nil
+	}
+
+	content := "# Generated by bindplane-aca. Contains secrets; do not commit.\n" + strings.Join(lines, "\n") + "\n"
+	if err := os.WriteFile(envPath, []byte(content), 0600); err != nil {
+		return fmt.Errorf("failed to create %s: %w", envPath, err)
+	}
+
+	// WriteFile keeps the mode of an existing file
+	if err := os.Chmod(envPath, 0600); err != nil {
+		return fmt.Errorf("failed to restrict permissions of %s: %w", envPath, err)
+	}
+
+	fmt.Printf("Generated: %s (contains secrets; do not commit)\n", envPath)
+
+	return nil
+}
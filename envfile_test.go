@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateDeploymentCommandsSecrets(t *testing.T) {
+	config := iacTestConfig(t)
+	config.License = "test-license-key"
+
+	if err := generateDeploymentCommands(config); err != nil {
+		t.Fatalf("Failed to generate deployment script: %v", err)
+	}
+
+	script, err := os.ReadFile(filepath.Join(config.OutputDir, "deploy.sh"))
+	if err != nil {
+		t.Fatalf("Failed to read deploy.sh: %v", err)
+	}
+	if strings.Contains(string(script), config.StorageAccountKey) {
+		t.Errorf("deploy.sh contains the storage account key:\n%s", script)
+	}
+	if !strings.Contains(string(script), `--azure-file-account-key "${BINDPLANE_ACA_STORAGE_ACCOUNT_KEY:?`) {
+		t.Errorf("Expected deploy.sh to read the storage account key from the environment, got:\n%s", script)
+	}
+
+	envPath := filepath.Join(config.OutputDir, envFile)
+	info, err := os.Stat(envPath)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", envFile, err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected %s to have 0600 permissions, got %o", envFile, info.Mode().Perm())
+	}
+	env, err := os.ReadFile(envPath)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", envFile, err)
+	}
+	if !strings.Contains(string(env), "BINDPLANE_ACA_STORAGE_ACCOUNT_KEY='test-storage-key'") {
+		t.Errorf("Expected %s to contain the storage account key, got:\n%s", envFile, env)
+	}
+	// The YAML documents carry the license, so the script doesn't need it
+	if strings.Contains(string(env), "LICENSE") {
+		t.Errorf("Expected %s not to contain the license, got:\n%s", envFile, env)
+	}
+}
+
+func TestGenerateDeploymentCommandsIaCEnvFile(t *testing.T) {
+	config := iacTestConfig(t)
+	config.OutputFormat = outputFormatBicep
+	config.License = "test-license-key"
+	config.PostgresPassword = "test-password"
+
+	if err := generateDeploymentCommands(config); err != nil {
+		t.Fatalf("Failed to generate deployment script: %v", err)
+	}
+
+	env, err := os.ReadFile(filepath.Join(config.OutputDir, envFile))
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", envFile, err)
+	}
+	for _, want := range []string{
+		"BINDPLANE_ACA_LICENSE='test-license-key'",
+		"BINDPLANE_ACA_POSTGRES_PASSWORD='test-password'",
+		"BINDPLANE_ACA_STORAGE_ACCOUNT_KEY='test-storage-key'",
+	} {
+		if !strings.Contains(string(env), want) {
+			t.Errorf("Expected %s to contain %q, got:\n%s", envFile, want, env)
+		}
+	}
+}
+
+func TestGenerateDeploymentCommandsRemovesStaleEnvFile(t *testing.T) {
+	config := iacTestConfig(t)
+	if err := generateDeploymentCommands(config); err != nil {
+		t.Fatalf("Failed to generate deployment script: %v", err)
+	}
+	envPath := filepath.Join(config.OutputDir, envFile)
+	if _, err := os.Stat(envPath); err != nil {
+		t.Fatalf("Expected %s to be written: %v", envFile, err)
+	}
+
+	// With the key in Key Vault there is no secret left to write, and the
+	// old key must not be loaded by the new script
+	config.StorageAccountKey = ""
+	config.StorageAccountKeyKeyVaultURI = "https://test-vault.vault.azure.net/secrets/storage-account-key"
+	if err := generateDeploymentCommands(config); err != nil {
+		t.Fatalf("Failed to generate deployment script: %v", err)
+	}
+	if _, err := os.Stat(envPath); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected the stale %s to be removed, got: %v", envFile, err)
+	}
+
+	// Nothing to write and nothing to remove is fine too
+	if err := generateDeploymentCommands(config); err != nil {
+		t.Errorf("Failed to generate deployment script without %s: %v", envFile, err)
+	}
+}
+
+func TestCheckScriptSecrets(t *testing.T) {
+	_, markers := markSensitive(&Config{StorageAccountKey: "test-storage-key"})
+
+	err := checkScriptSecrets("deploy.sh", "#!/bin/bash\naz storage --key \"__bindplane_aca_sensitive_StorageAccountKey__\"\n", markers)
+	if err == nil {
+		t.Fatal("Expected error but got none")
+	}
+	if !strings.Contains(err.Error(), "deploy.sh:2: refusing to write sensitive field StorageAccountKey") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if err := checkScriptSecrets("deploy.sh", "#!/bin/bash\naz storage --key \"$KEY\"\n", markers); err != nil {
+		t.Errorf("Expected no error but got: %v", err)
+	}
+}
+
+func TestRenderScriptsCommonSecrets(t *testing.T) {
+	// Secrets equal to names and arguments used elsewhere in the scripts
+	config := iacTestConfig(t)
+	config.License, config.PostgresPassword, config.StorageAccountKey = "bindplane", "postgres", "1"
+	for _, format := range outputFormats {
+		config.OutputFormat = format
+		if _, err := renderDeploymentScript(config); err != nil {
+			t.Errorf("Expected the %s deploy script to render, got: %v", format, err)
+		}
+		if _, err := renderDestroyScript(config); err != nil {
+			t.Errorf("Expected the %s destroy script to render, got: %v", format, err)
+		}
+	}
+}
+
+func TestEnvFileCommands(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not available")
+	}
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "deploy.sh")
+	commands := append(envFileCommands(), `echo "$BINDPLANE_ACA_STORAGE_ACCOUNT_KEY"`)
+	if err := os.WriteFile(script, []byte(strings.Join(commands, "\n")+"\n"), 0755); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	envPath := filepath.Join(dir, envFile)
+	if err := os.WriteFile(envPath, []byte("BINDPLANE_ACA_STORAGE_ACCOUNT_KEY='it'\\''s a key'\n"), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", envFile, err)
+	}
+
+	out, err := exec.Command(bash, script).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Script failed: %v\n%s", err, out)
+	}
+	if got := strings.TrimSpace(string(out)); got != "it's a key" {
+		t.Errorf("Expected the key from %s, got %q", envFile, got)
+	}
+
+	if err := os.Chmod(envPath, 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err = exec.Command(bash, script).CombinedOutput()
+	if err == nil {
+		t.Fatalf("Expected script to reject a world readable %s, got:\n%s", envFile, out)
+	}
+	if !strings.Contains(string(out), "must have 0600 permissions") {
+		t.Errorf("Unexpected output: %s", out)
+	}
+}
@@ -1,3 +1,8 @@
 module bindplane-aca
 
 go 1.24.6
+
+require (
+	gopkg.in/yaml.v3 v3.0.1
+	mvdan.cc/sh/v3 v3.12.0
+)
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// defaultHealthTimeout is how long deploy.sh waits for each container app to
+// become healthy by default
+const defaultHealthTimeout = 10 * time.Minute
+
+// healthPollSeconds is how often deploy.sh polls a revision's health
+const healthPollSeconds = 10
+
+// healthLogLines is the number of log lines shown for an unhealthy app
+const healthLogLines = 100
+
+// checkHealthTimeout checks the health gate timeout; zero disables the gates
+func checkHealthTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("must not be negative")
+	}
+	return nil
+}
+
+// healthCheckCommands defines wait_healthy in deploy.sh. It polls the latest
+// revision of a container app until it is healthy and runs at least the
+// app's minimum number of replicas. If the revision fails, or the timeout
+// passes first, it prints the revision's recent logs and exits.
+func healthCheckCommands(config *Config) []string {
+	resourceGroup := shellQuote(config.ResourceGroup)
+	return []string{
+		"# Seconds to wait for each container app to become healthy, 0 to skip the checks",
+		fmt.Sprintf("HEALTH_TIMEOUT=%d", int(math.Ceil(config.HealthTimeout.Seconds()))),
+		"",
+		"# wait_healthy waits until the latest revision of a container app is healthy",
+		"# with its minimum number of replicas running, and fails with the revision's",
+		"# recent logs if that doesn't happen within HEALTH_TIMEOUT seconds",
+		"wait_healthy() {",
+		"  if [ \"$HEALTH_TIMEOUT\" -eq 0 ]; then",
+		"    return 0",
+		"  fi",
+		"  echo \"Waiting up to ${HEALTH_TIMEOUT}s for $1 to become healthy...\"",
+		"  local deadline=$((SECONDS + HEALTH_TIMEOUT))",
+		"  local app status revision min_replicas health state replicas",
+		"  while true; do",
+		"    # A failed or empty read is retried until the deadline rather than",
+		"    # ending the script through set -e",
+		fmt.Sprintf("    app=$(az containerapp show --name \"$1\" --resource-group %s --query \"join(' ', [properties.latestRevisionName, to_string(properties.template.scale.minReplicas)])\" --output tsv) || app=\"\"", resourceGroup),
+		"    read -r revision min_replicas <<< \"$app\" || true",
+		"    case \"$min_replicas\" in ''|null) min_replicas=1 ;; esac",
+		"    if [ -z \"$revision\" ]; then",
+		"      echo \"  $1: no revision found yet\"",
+		"    else",
+		fmt.Sprintf("      status=$(az containerapp revision show --name \"$1\" --resource-group %s --revision \"$revision\" --query \"join(' ', [properties.healthState, properties.runningState, to_string(properties.replicas)])\" --output tsv) || status=\"\"", resourceGroup),
+		"      read -r health state replicas <<< \"$status\" || true",
+		"      case \"$replicas\" in ''|null) replicas=0 ;; esac",
+		"      echo \"  $revision: ${health:-unknown}, ${state:-unknown}, $replicas of $min_replicas replicas\"",
+		"      if [ \"$health\" = Healthy ] && [ \"$replicas\" -ge \"$min_replicas\" ]; then",
+		"        return 0",
+		"      fi",
+		"      if [ \"$state\" = Failed ]; then",
+		"        break",
+		"      fi",
+		"    fi",
+		"    if [ \"$SECONDS\" -ge \"$deadline\" ]; then",
+		"      break",
+		"    fi",
+		fmt.Sprintf("    sleep %d", healthPollSeconds),
+		"  done",
+		"  echo \"$1 did not become healthy within ${HEALTH_TIMEOUT}s. Recent logs of revision ${revision:-(none)}:\" >&2",
+		"  if [ -n \"$revision\" ]; then",
+		"    # The logs are best effort; the deployment fails either way",
+		fmt.Sprintf("    az containerapp logs show --name \"$1\" --resource-group %s --revision \"$revision\" --tail %d --format text >&2 || echo \"(failed to fetch logs)\" >&2", resourceGroup, healthLogLines),
+		"  fi",
+		"  exit 1",
+		"}",
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateConfigHealthTimeout(t *testing.T) {
+	tests := []struct {
+		value    string
+		errorMsg string
+	}{
+		{value: "5m"},
+		{value: "0"},
+		{value: "-1s", errorMsg: "invalid health-timeout -1s (flag -health-timeout): must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			config, err := parseFlags(append(cliTestArgs(t.TempDir()), "-health-timeout", tt.value))
+			if err != nil {
+				t.Fatalf("parseFlags failed: %v", err)
+			}
+			err = validateConfig(config)
+			if tt.errorMsg == "" && err != nil {
+				t.Errorf("Expected no error, got: %v", err)
+			}
+			if tt.errorMsg != "" && (err == nil || !strings.Contains(err.Error(), tt.errorMsg)) {
+				t.Errorf("Expected error containing %q, got: %v", tt.errorMsg, err)
+			}
+		})
+	}
+}
+
+func TestHealthCheckCommands(t *testing.T) {
+	config := iacTestConfig(t)
+	config.HealthTimeout = 90 * time.Second
+
+	script := strings.Join(healthCheckCommands(config), "\n")
+	if !strings.Contains(script, "HEALTH_TIMEOUT=90\n") {
+		t.Errorf("Expected the timeout in seconds, got:\n%s", script)
+	}
+
+	config.HealthTimeout = 1500 * time.Millisecond
+	if script := strings.Join(healthCheckCommands(config), "\n"); !strings.Contains(script, "HEALTH_TIMEOUT=2\n") {
+		t.Errorf("Expected the timeout rounded up to whole seconds, got:\n%s", script)
+	}
+}
+
+func TestDeployWaitsForHealthyApps(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not available")
+	}
+
+	config := iacTestConfig(t)
+	config.HealthTimeout = time.Minute
+	if err := generateDeploymentCommands(config); err != nil {
+		t.Fatalf("Failed to generate deployment script: %v", err)
+	}
+	for _, filename := range templateFiles(config) {
+		if err := os.WriteFile(filepath.Join(config.OutputDir, filename), []byte("name: test\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", filename, err)
+		}
+	}
+	bin := writeFakeAZ(t)
+
+	deploy := func(failed string) (string, string, error) {
+		t.Helper()
+		log := filepath.Join(t.TempDir(), "az.log")
+		cmd := exec.Command(bash, filepath.Join(config.OutputDir, deployScript))
+		cmd.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"), "AZ_LOG="+log, "AZ_FAILED="+failed)
+		out, err := cmd.CombinedOutput()
+		calls, _ := os.ReadFile(log)
+		return string(calls), string(out), err
+	}
+
+	calls, out, err := deploy("")
+	if err != nil {
+		t.Fatalf("deploy.sh failed: %v\n%s", err, out)
+	}
+	for _, name := range []string{"bindplane-transform-agent", "bindplane-prometheus", "bindplane-jobs", "bindplane", "otelcol"} {
+		if !strings.Contains(calls, "containerapp revision show --name "+name+" ") {
+			t.Errorf("Expected deploy.sh to check the health of %s, got:\n%s", name, calls)
+		}
+	}
+
+	// A failed revision stops the deployment before the apps that depend on it
+	calls, out, err = deploy("bindplane-jobs")
+	if err == nil {
+		t.Fatalf("Expected deploy.sh to fail, got:\n%s", out)
+	}
+	for _, want := range []string{"bindplane-jobs did not become healthy within 60s", "log line from bindplane-jobs"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected deploy.sh output to contain %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(calls, "create --name bindplane ") || strings.Contains(calls, "--name otelcol") {
+		t.Errorf("Expected deploy.sh to stop after bindplane-jobs, got:\n%s", calls)
+	}
+}
+
+func TestWaitHealthyUnreadableApp(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not available")
+	}
+
+	config := iacTestConfig(t)
+	config.HealthTimeout = time.Second
+	if err := generateDeploymentCommands(config); err != nil {
+		t.Fatalf("Failed to generate deployment script: %v", err)
+	}
+	for _, filename := range templateFiles(config) {
+		if err := os.WriteFile(filepath.Join(config.OutputDir, filename), []byte("name: test\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", filename, err)
+		}
+	}
+	bin := writeFakeAZ(t)
+	// Poll without waiting the full interval
+	if err := os.WriteFile(filepath.Join(bin, "sleep"), []byte("#!/bin/bash\ncommand -p sleep 0.1\n"), 0755); err != nil {
+		t.Fatalf("Failed to write fake sleep: %v", err)
+	}
+
+	// az failing and az printing nothing both keep polling until the timeout
+	for _, variable := range []string{"AZ_UNREADABLE", "AZ_EMPTY"} {
+		t.Run(variable, func(t *testing.T) {
+			log := filepath.Join(t.TempDir(), "az.log")
+			cmd := exec.Command(bash, filepath.Join(config.OutputDir, deployScript))
+			cmd.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"), "AZ_LOG="+log, variable+"=bindplane-jobs")
+			out, err := cmd.CombinedOutput()
+			if err == nil {
+				t.Fatalf("Expected deploy.sh to fail, got:\n%s", out)
+			}
+			for _, want := range []string{"bindplane-jobs: no revision found yet", "bindplane-jobs did not become healthy within 1s. Recent logs of revision (none):"} {
+				if !strings.Contains(string(out), want) {
+					t.Errorf("Expected deploy.sh output to contain %q, got:\n%s", want, out)
+				}
+			}
+			if calls, _ := os.ReadFile(log); strings.Contains(string(calls), "--name otelcol") {
+				t.Errorf("Expected deploy.sh to stop after bindplane-jobs, got:\n%s", calls)
+			}
+		})
+	}
+}
+
+func TestIaCDeployWaitsBetweenStages(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not available")
+	}
+
+	tests := []struct {
+		format string
+		// reached is logged when the stage deploying bindplane-jobs runs and
+		// skipped the stage after it
+		reached, skipped string
+	}{
+		{format: outputFormatBicep, reached: "--parameters stage=3", skipped: "--parameters stage=4"},
+		{format: outputFormatARM, reached: "--parameters stage=3", skipped: "--parameters stage=4"},
+		{format: outputFormatTerraform, reached: "-target=terraform_data.bindplane_jobs_deployed", skipped: "-target=terraform_data.bindplane_deployed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			config := iacTestConfig(t)
+			config.OutputFormat = tt.format
+			config.HealthTimeout = time.Minute
+			if err := generateDeploymentCommands(config); err != nil {
+				t.Fatalf("Failed to generate deployment script: %v", err)
+			}
+			bin := writeFakeAZ(t)
+			if err := os.WriteFile(filepath.Join(bin, "terraform"), []byte("#!/bin/bash\necho \"terraform $*\" >> \"$AZ_LOG\"\n"), 0755); err != nil {
+				t.Fatalf("Failed to write fake terraform: %v", err)
+			}
+
+			log := filepath.Join(t.TempDir(), "az.log")
+			cmd := exec.Command(bash, filepath.Join(config.OutputDir, deployScript))
+			cmd.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"), "AZ_LOG="+log, "AZ_FAILED=bindplane-jobs")
+			for _, p := range secretParameters {
+				cmd.Env = append(cmd.Env, envName(p.flag)+"=test-"+p.flag)
+			}
+			out, err := cmd.CombinedOutput()
+			if err == nil {
+				t.Fatalf("Expected deploy.sh to fail, got:\n%s", out)
+			}
+
+			// Prometheus is healthy before the jobs are deployed, and the
+			// failed jobs stop the deployment before bindplane
+			calls, _ := os.ReadFile(log)
+			prometheus := strings.Index(string(calls), "containerapp revision show --name bindplane-prometheus ")
+			jobs := strings.Index(string(calls), tt.reached)
+			if prometheus < 0 || jobs < 0 || prometheus > jobs {
+				t.Errorf("Expected bindplane-prometheus to be checked before the stage %q, got:\n%s", tt.reached, calls)
+			}
+			if strings.Contains(string(calls), tt.skipped) {
+				t.Errorf("Expected deploy.sh to stop after bindplane-jobs, got:\n%s", calls)
+			}
+		})
+	}
+}
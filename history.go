@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// historyDir is the directory in the output directory holding one
+// generation per deployment
+const historyDir = "history"
+
+// historyLimit is how many generations are kept
+const historyLimit = 20
+
+// generationFile holds the metadata of a generation
+const generationFile = "generation.json"
+
+// generationVersion is the version of the generation metadata format
+const generationVersion = 1
+
+// rollbackFiles are the templates rollback re-applies, in deploy order
+var rollbackFiles = []string{"transform-agent.yaml", "jobs.yaml", "bindplane.yaml"}
+
+// generation is a deployed set of container apps. Its directory holds each
+// component rendered with sensitive markers in place of secret values,
+// so the history holds nothing secret.
+type generation struct {
+	Version    int       `json:"version"`
+	Number     int       `json:"generation"`
+	DeployedAt time.Time `json:"deployedAt"`
+	// Command is the command that deployed the generation
+	Command      string `json:"command"`
+	BindplaneTag string `json:"bindplaneTag"`
+	// Images maps each container app to the image of its first container
+	Images map[string]string `json:"images"`
+	// Files lists the rendered templates in deploy order
+	Files []string `json:"files"`
+	// RollbackOf is the generation a rollback re-applied
+	RollbackOf int `json:"rollbackOf,omitempty"`
+
+	dir string
+}
+
+// generationDir returns the directory of generation n
+func generationDir(config *Config, n int) string {
+	return filepath.Join(config.OutputDir, historyDir, fmt.Sprintf("%04d", n))
+}
+
+// listGenerations returns the recorded generations, oldest first
+func listGenerations(config *Config) ([]*generation, error) {
+	entries, err := os.ReadDir(filepath.Join(config.OutputDir, historyDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deployment history: %w", err)
+	}
+
+	var generations []*generation
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil || !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(config.OutputDir, historyDir, entry.Name())
+		content, err := os.ReadFile(filepath.Join(dir, generationFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read deployment history: %w", err)
+		}
+		var g generation
+		if err := json.Unmarshal(content, &g); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", filepath.Join(dir, generationFile), err)
+		}
+		if g.Version > generationVersion {
+			return nil, fmt.Errorf("%s has version %d; this version of %s supports version %d", filepath.Join(dir, generationFile), g.Version, programName, generationVersion)
+		}
+		g.dir = dir
+		generations = append(generations, &g)
+	}
+	slices.SortFunc(generations, func(a, b *generation) int { return a.Number - b.Number })
+	return generations, nil
+}
+
+// recordGeneration records the rendered components as a new generation and
+// removes the oldest generations beyond historyLimit. components are rendered
+// from data marked by markSensitive; markers maps the markers that must not
+// appear in them to their field names.
+func recordGeneration(config *Config, components []*iacComponent, markers map[string]string, g *generation) error {
+	generations, err := listGenerations(config)
+	if err != nil {
+		return err
+	}
+	g.Version, g.Number, g.Images, g.Files = generationVersion, 1, make(map[string]string), nil
+	if len(generations) > 0 {
+		g.Number = generations[len(generations)-1].Number + 1
+	}
+	g.dir = generationDir(config, g.Number)
+
+	for _, component := range components {
+		for marker, field := range markers {
+			if strings.Contains(string(component.content), marker) {
+				return fmt.Errorf("refusing to write sensitive field %s of %s into the deployment history", field, component.file)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(g.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", g.dir, err)
+	}
+	for _, component := range components {
+		if err := os.WriteFile(filepath.Join(g.dir, component.file), component.content, 0644); err != nil {
+			return fmt.Errorf("failed to record %s: %w", component.file, err)
+		}
+		g.Files = append(g.Files, component.file)
+		if image := componentImage(component); image != "" {
+			g.Images[component.name] = image
+		}
+	}
+
+	content, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode generation: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(g.dir, generationFile), append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to record generation: %w", err)
+	}
+
+	generations = append(generations, g)
+	for _, old := range generations[:max(0, len(generations)-historyLimit)] {
+		if err := os.RemoveAll(old.dir); err != nil {
+			return fmt.Errorf("failed to remove generation %d: %w", old.Number, err)
+		}
+	}
+
+	fmt.Printf("Recorded deployment generation %d in %s\n", g.Number, g.dir)
+	return nil
+}
+
+// recordDeployment records the configuration just deployed by command as a
+// new generation. Every secret renders as its marker. The markers of secret
+// parameters are kept and filled in again on rollback; any other secret, such
+// as a base64 encoded one, is refused, so the recorded output is the same as
+// from the real values.
+func recordDeployment(config *Config, data *TemplateData, command string) error {
+	marked, markers := markSensitive(data)
+	for _, p := range secretParameters {
+		delete(markers, sensitiveMarker(p.field))
+	}
+	components, err := renderComponents(config, marked)
+	if err != nil {
+		return err
+	}
+	return recordGeneration(config, components, markers, &generation{
+		DeployedAt:   time.Now().UTC(),
+		Command:      command,
+		BindplaneTag: config.BindplaneTag,
+	})
+}
+
+// componentImage returns the image of the component's first container
+func componentImage(component *iacComponent) string {
+	containers := mappingValue(mappingValue(component.field("properties"), "template"), "containers")
+	if containers == nil || containers.Kind != yaml.SequenceNode || len(containers.Content) == 0 {
+		return ""
+	}
+	if image := mappingValue(resolveAlias(containers.Content[0]), "image"); image != nil {
+		return image.Value
+	}
+	return ""
+}
+
+// components reads the given files of the generation, in order
+func (g *generation) components(files []string) ([]*iacComponent, error) {
+	var components []*iacComponent
+	for _, filename := range files {
+		content, err := os.ReadFile(filepath.Join(g.dir, filename))
+		if err != nil {
+			return nil, fmt.Errorf("generation %d: %w", g.Number, err)
+		}
+		component, err := parseComponent(filename, content)
+		if err != nil {
+			return nil, fmt.Errorf("generation %d: %w", g.Number, err)
+		}
+		components = append(components, component)
+	}
+	return components, nil
+}
+
+// String describes the generation on one line
+func (g *generation) String() string {
+	s := fmt.Sprintf("generation %d: %s with bindplane-tag %s at %s", g.Number, g.Command, g.BindplaneTag, g.DeployedAt.Format(time.RFC3339))
+	if g.RollbackOf > 0 {
+		s += fmt.Sprintf(", rolling back to generation %d", g.RollbackOf)
+	}
+	return s
+}
+
+// writeHistory prints the generations, newest first
+func writeHistory(w io.Writer, generations []*generation) {
+	if len(generations) == 0 {
+		fmt.Fprintln(w, "No deployments recorded")
+		return
+	}
+	for i := len(generations) - 1; i >= 0; i-- {
+		fmt.Fprintln(w, generations[i])
+		for _, filename := range rollbackFiles {
+			if image := generations[i].Images[containerAppNames[filename]]; image != "" {
+				fmt.Fprintf(w, "  %-26s %s\n", containerAppNames[filename], image)
+			}
+		}
+	}
+}
+
+// fillSecrets replaces the secret parameter markers of a component read from
+// the history with the configured secret values
+func fillSecrets(component *iacComponent, data *TemplateData) error {
+	var err error
+	var fill func(node *yaml.Node)
+	fill = func(node *yaml.Node) {
+		if node.Kind == yaml.ScalarNode {
+			node.Value = sensitivePattern.ReplaceAllStringFunc(node.Value, func(marker string) string {
+				field := sensitivePattern.FindStringSubmatch(marker)[1]
+				name := field
+				if p, ok := fieldParameter(field); ok {
+					name = p.flag
+				}
+				value, _ := sensitiveValue(data, field)
+				if value == "" && err == nil {
+					err = fmt.Errorf("%s of %s needs a %s value, but it is not set; configure the secret as it was when the generation was deployed", component.name, component.file, name)
+				}
+				return value
+			})
+		}
+		for _, child := range node.Content {
+			fill(child)
+		}
+	}
+	fill(component.doc)
+	return err
+}
+
+// migrationWarning warns when a rollback changes the jobs image, since jobs
+// migrates the database when a new Bindplane version starts and an older
+// version may not run against a migrated database
+func migrationWarning(from, to *generation) string {
+	current, target := from.Images["bindplane-jobs"], to.Images["bindplane-jobs"]
+	if current == "" || target == "" || current == target {
+		return ""
+	}
+	return fmt.Sprintf("rolling bindplane-jobs back from %s to %s. Jobs migrates the database when a new version starts, "+
+		"and the older version may not support a database migrated by the newer one; if Bindplane fails to start after the rollback, "+
+		"restore the database from a backup taken before the upgrade", current, target)
+}
+
+// recordRollback records a generation of the current one with the rollback
+// files of target
+func recordRollback(config *Config, current, target *generation) error {
+	var components []*iacComponent
+	for _, filename := range current.Files {
+		source := current
+		if slices.Contains(rollbackFiles, filename) && slices.Contains(target.Files, filename) {
+			source = target
+		}
+		read, err := source.components([]string{filename})
+		if err != nil {
+			return err
+		}
+		components = append(components, read...)
+	}
+	// The files were checked when their generations were recorded
+	return recordGeneration(config, components, nil, &generation{
+		DeployedAt:   time.Now().UTC(),
+		Command:      "rollback",
+		BindplaneTag: target.BindplaneTag,
+		RollbackOf:   target.Number,
+	})
+}
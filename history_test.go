@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecordGeneration(t *testing.T) {
+	config := iacTestConfig(t)
+	data := iacTestData()
+	if err := recordDeployment(config, data, "deploy"); err != nil {
+		t.Fatalf("recordDeployment failed: %v", err)
+	}
+	data.BindplaneTag, config.BindplaneTag = "1.95.0", "1.95.0"
+	if err := recordDeployment(config, data, "apply"); err != nil {
+		t.Fatalf("recordDeployment failed: %v", err)
+	}
+
+	generations, err := listGenerations(config)
+	if err != nil {
+		t.Fatalf("listGenerations failed: %v", err)
+	}
+	if len(generations) != 2 {
+		t.Fatalf("Expected 2 generations, got %d", len(generations))
+	}
+	latest := generations[1]
+	if latest.Number != 2 || latest.Command != "apply" || latest.BindplaneTag != "1.95.0" || latest.dir != generationDir(config, 2) {
+		t.Errorf("Unexpected generation: %+v", latest)
+	}
+	if !reflect.DeepEqual(latest.Files, deployOrder(config)) {
+		t.Errorf("Files = %q, want %q", latest.Files, deployOrder(config))
+	}
+	if image := latest.Images["bindplane-transform-agent"]; image != "ghcr.io/observiq/bindplane-transform-agent:1.95.0-bindplane" {
+		t.Errorf("Unexpected transform agent image %q", image)
+	}
+
+	// The history holds placeholders in place of secrets
+	content, err := os.ReadFile(filepath.Join(latest.dir, "bindplane.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "test-license-key") || !strings.Contains(string(content), sensitiveMarker("License")) {
+		t.Errorf("Expected the license to be a placeholder in the history, got:\n%s", content)
+	}
+
+	// Old generations are removed
+	for range historyLimit {
+		if err := recordDeployment(config, data, "deploy"); err != nil {
+			t.Fatalf("recordDeployment failed: %v", err)
+		}
+	}
+	if generations, err = listGenerations(config); err != nil {
+		t.Fatalf("listGenerations failed: %v", err)
+	}
+	if len(generations) != historyLimit || generations[0].Number != 3 {
+		t.Errorf("Expected the latest %d generations from 3, got %d from %d", historyLimit, len(generations), generations[0].Number)
+	}
+
+	// Secrets equal to names used in the templates are recorded as placeholders
+	common := iacTestData()
+	common.License, common.PostgresPassword = "bindplane", "postgres"
+	if err := recordDeployment(config, common, "deploy"); err != nil {
+		t.Errorf("Expected common secret values to be recorded, got: %v", err)
+	}
+
+	// Secrets that aren't parameters are never written
+	dir := t.TempDir()
+	override := strings.Replace(validContainerApp, "  configuration:\n", "  configuration:\n    secrets:\n      - name: license\n        value: {{.Base64License}}\n", 1)
+	if err := os.WriteFile(filepath.Join(dir, "bindplane.yaml"), []byte(override), 0644); err != nil {
+		t.Fatal(err)
+	}
+	overridden := *config
+	overridden.TemplatesDir = dir
+	if err := recordDeployment(&overridden, data, "deploy"); err == nil || !strings.Contains(err.Error(), "refusing to write sensitive field Base64License of bindplane.yaml into the deployment history") {
+		t.Errorf("Expected the base64 license to be refused, got: %v", err)
+	}
+	if _, err := listGenerations(config); err != nil {
+		t.Errorf("Expected a refused generation to leave the history readable, got: %v", err)
+	}
+}
+
+func TestFillSecrets(t *testing.T) {
+	config := iacTestConfig(t)
+	if err := recordDeployment(config, iacTestData(), "deploy"); err != nil {
+		t.Fatalf("recordDeployment failed: %v", err)
+	}
+	generations, err := listGenerations(config)
+	if err != nil {
+		t.Fatalf("listGenerations failed: %v", err)
+	}
+	components, err := generations[0].components([]string{"bindplane.yaml"})
+	if err != nil {
+		t.Fatalf("components failed: %v", err)
+	}
+
+	data := iacTestData()
+	data.License = "current-license"
+	if err := fillSecrets(components[0], data); err != nil {
+		t.Fatalf("fillSecrets failed: %v", err)
+	}
+	license := keyedItem(mappingValue(mappingValue(components[0].field("properties"), "configuration"), "secrets"), "name", "license")
+	if value := mappingValue(license, "value").Value; value != "current-license" {
+		t.Errorf("Expected the current license, got %q", value)
+	}
+
+	if components, err = generations[0].components([]string{"bindplane.yaml"}); err != nil {
+		t.Fatalf("components failed: %v", err)
+	}
+	data.SessionSecret = ""
+	if err := fillSecrets(components[0], data); err == nil || !strings.Contains(err.Error(), "needs a session-secret value") {
+		t.Errorf("Expected a missing secret to be reported, got: %v", err)
+	}
+}
+
+func TestMigrationWarning(t *testing.T) {
+	current := &generation{Number: 2, Images: map[string]string{"bindplane-jobs": "bindplane-ee:1.95.0"}}
+	if warning := migrationWarning(current, &generation{Images: map[string]string{"bindplane-jobs": "bindplane-ee:1.95.0"}}); warning != "" {
+		t.Errorf("Expected no warning when the jobs image is unchanged, got %q", warning)
+	}
+	warning := migrationWarning(current, &generation{Images: map[string]string{"bindplane-jobs": "bindplane-ee:1.94.3"}})
+	if !strings.Contains(warning, "rolling bindplane-jobs back from bindplane-ee:1.95.0 to bindplane-ee:1.94.3") {
+		t.Errorf("Expected a database migration warning, got %q", warning)
+	}
+
+	// Deployments with another bindplane-tag record another jobs image
+	config, data := iacTestConfig(t), iacTestData()
+	for _, tag := range []string{"1.94.3", "1.94.3", "1.95.0"} {
+		data.BindplaneTag, config.BindplaneTag = tag, tag
+		if err := recordDeployment(config, data, "deploy"); err != nil {
+			t.Fatalf("recordDeployment failed: %v", err)
+		}
+	}
+	generations, err := listGenerations(config)
+	if err != nil {
+		t.Fatalf("listGenerations failed: %v", err)
+	}
+	if warning := migrationWarning(generations[1], generations[0]); warning != "" {
+		t.Errorf("Expected no warning between deployments of the same tag, got %q", warning)
+	}
+	warning = migrationWarning(generations[2], generations[1])
+	if !strings.Contains(warning, "rolling bindplane-jobs back from ghcr.io/observiq/bindplane-ee:1.95.0 to ghcr.io/observiq/bindplane-ee:1.94.3") {
+		t.Errorf("Expected a database migration warning after bumping the tag, got %q", warning)
+	}
+}
+
+func TestRunRollback(t *testing.T) {
+	stubRunCommand(t)
+	arm := newMockARM(t)
+	for _, name := range []string{"AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET"} {
+		t.Setenv(name, "")
+	}
+
+	outputDir := filepath.Join(t.TempDir(), "out")
+	args := func(extra ...string) []string {
+		return append(append(cliTestArgs(outputDir),
+			"-health-timeout", "0",
+			"-arm-endpoint", arm.URL, "-arm-authority-host", arm.URL,
+			"-arm-tenant-id", "test-tenant", "-arm-client-id", "test-client", "-arm-client-secret", "test-secret",
+		), extra...)
+	}
+
+	if err := runRollback(args()); err == nil || !strings.Contains(err.Error(), "nothing to roll back to") {
+		t.Errorf("Expected rollback without history to fail, got: %v", err)
+	}
+	if err := runApply(args()); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if err := runApply(args("-bindplane-tag", "1.95.0")); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	arm.requests = nil
+
+	if err := runRollback(args("-bindplane-tag", "1.95.0", "-license", "rotated-license")); err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	apps := "/subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.App/containerApps/"
+	var puts []string
+	for _, request := range arm.requests {
+		if name, ok := strings.CutPrefix(request, "PUT "+apps); ok {
+			puts = append(puts, name)
+		}
+	}
+	if expected := []string{"bindplane-transform-agent", "bindplane-jobs", "bindplane"}; !reflect.DeepEqual(puts, expected) {
+		t.Errorf("rollback put %q, want %q", puts, expected)
+	}
+
+	// The previous image is deployed with the current secrets
+	agent := arm.bodies[apps+"bindplane-transform-agent"]["properties"].(map[string]any)["template"].(map[string]any)["containers"].([]any)[0].(map[string]any)
+	if agent["image"] != "ghcr.io/observiq/bindplane-transform-agent:"+defaultBindplaneTag+"-bindplane" {
+		t.Errorf("Expected the previous transform agent image, got %v", agent["image"])
+	}
+	license := arm.bodies[apps+"bindplane"]["properties"].(map[string]any)["configuration"].(map[string]any)["secrets"].([]any)[0].(map[string]any)
+	if license["value"] != "rotated-license" {
+		t.Errorf("Expected the current license, got %v", license["value"])
+	}
+
+	config := &Config{OutputDir: outputDir}
+	generations, err := listGenerations(config)
+	if err != nil {
+		t.Fatalf("listGenerations failed: %v", err)
+	}
+	if latest := generations[len(generations)-1]; len(generations) != 3 || latest.RollbackOf != 1 || latest.BindplaneTag != defaultBindplaneTag {
+		t.Errorf("Expected the rollback to be recorded as generation 3, got %+v", latest)
+	}
+
+	// The state follows the rollback, so plan shows the tag as a change again
+	if err := runPlan(append(cliTestArgs(outputDir), "-bindplane-tag", "1.95.0", "-exit-code")); !errors.Is(err, errDifferences) {
+		t.Errorf("Expected plan to show the rolled back apps as changed, got: %v", err)
+	}
+	if err := runRollback(args("-to", "3")); err == nil || !strings.Contains(err.Error(), "generation 3 is the latest deployment") {
+		t.Errorf("Expected rolling back to the latest generation to fail, got: %v", err)
+	}
+}
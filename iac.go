@@ -0,0 +1,395 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// containerAppsAPIVersion is the Microsoft.App API version used by the
+// infrastructure as code outputs
+const containerAppsAPIVersion = "2024-03-01"
+
+// deploymentName is the name given to az deployment group create runs
+const deploymentName = "bindplane-aca"
+
+// secretParameter is a sensitive value passed to the infrastructure as code
+// outputs as a secure parameter instead of being written into the output.
+type secretParameter struct {
+	// flag is the command line flag that supplies the value
+	flag        string
+	description string
+	// field is the sensitive Config field holding the value. The templates
+	// render the value when TemplateData has a sensitive field of that name.
+	field string
+	// keyVaultURI returns the Key Vault secret URI configured in place of the
+	// value, if any
+	keyVaultURI func(*Config) string
+}
+
+// secretParameters lists every secret the infrastructure as code outputs take
+// as a parameter
+var secretParameters = []secretParameter{
+	{
+		flag:        "license",
+		description: "Bindplane license key",
+		field:       "License",
+		keyVaultURI: func(c *Config) string { return c.LicenseKeyVaultURI },
+	},
+	{
+		flag:        "postgres-password",
+		description: "PostgreSQL password",
+		field:       "PostgresPassword",
+		keyVaultURI: func(c *Config) string { return c.PostgresPasswordKeyVaultURI },
+	},
+	{
+		flag:        "session-secret",
+		description: "Bindplane session secret",
+		field:       "SessionSecret",
+		keyVaultURI: func(c *Config) string { return c.SessionSecretKeyVaultURI },
+	},
+	{
+		flag:        "azure-connection-string",
+		description: "Azure Service Bus connection string",
+		field:       "AzureConnectionString",
+		keyVaultURI: func(c *Config) string { return c.AzureConnectionStringKeyVaultURI },
+	},
+	{
+		flag:        "storage-account-key",
+		description: "Azure Storage Account key",
+		field:       "StorageAccountKey",
+		keyVaultURI: func(c *Config) string { return c.StorageAccountKeyKeyVaultURI },
+	},
+}
+
+// name returns the parameter name, the camel cased flag name
+func (p secretParameter) name() string {
+	return camelCase(p.flag)
+}
+
+// value returns the configured value of the parameter
+func (p secretParameter) value(config *Config) string {
+	value, _ := sensitiveValue(config, p.field)
+	return value
+}
+
+// templated reports whether the templates render the parameter
+func (p secretParameter) templated() bool {
+	_, ok := sensitiveValue(&TemplateData{}, p.field)
+	return ok
+}
+
+// scriptParameters returns the secret parameters the deploy script must pass
+// to the deployment. Secrets rendered as Key Vault references are resolved by
+// Container Apps and are not passed at all.
+func scriptParameters(config *Config) []secretParameter {
+	var parameters []secretParameter
+	for _, p := range secretParameters {
+		if p.flag == "storage-account-key" && !config.DeployPrometheus {
+			continue
+		}
+		if p.templated() && p.keyVaultURI(config) != "" {
+			continue
+		}
+		parameters = append(parameters, p)
+	}
+	return parameters
+}
+
+// scriptValue returns the shell expression the deploy script uses for the
+// parameter: a lookup in Key Vault when a secret URI is configured, otherwise
+// the required environment variable, which may be set in .env.
+func (p secretParameter) scriptValue(config *Config) string {
+	if uri := p.keyVaultURI(config); uri != "" {
+		return keyVaultSecretCommand(uri)
+	}
+	env := envName(p.flag)
+	return fmt.Sprintf("${%s:?%s must be set in the environment or %s}", env, env, envFile)
+}
+
+// keyVaultSecretCommand returns a command substitution that reads the secret
+// at uri when the deploy script runs
+func keyVaultSecretCommand(uri string) string {
+	return fmt.Sprintf("$(az keyvault secret show --id %s --query value --output tsv)", shellQuote(uri))
+}
+
+// lookupSecretParameter returns the secret parameter with the given name
+func lookupSecretParameter(name string) (secretParameter, bool) {
+	for _, p := range secretParameters {
+		if p.name() == name {
+			return p, true
+		}
+	}
+	return secretParameter{}, false
+}
+
+// fieldParameter returns the secret parameter holding the named sensitive
+// field
+func fieldParameter(field string) (secretParameter, bool) {
+	for _, p := range secretParameters {
+		if p.field == field {
+			return p, true
+		}
+	}
+	return secretParameter{}, false
+}
+
+// camelCase converts a dashed name such as postgres-password to postgresPassword
+func camelCase(name string) string {
+	parts := strings.Split(name, "-")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// parameterizedData returns a copy of data with every templated secret
+// parameter, set or not, replaced by its sensitive marker
+func parameterizedData(data *TemplateData) *TemplateData {
+	parameterized, _ := replaceSensitive(data, func(field, value string) string {
+		if _, ok := fieldParameter(field); ok {
+			return sensitiveMarker(field)
+		}
+		return value
+	})
+	return parameterized
+}
+
+// stringSegment is a piece of a rendered string, either literal text or a
+// reference to a parameter
+type stringSegment struct {
+	text  string
+	param string
+}
+
+// splitPlaceholders splits s into literal text and references to the secret
+// parameters whose markers it contains
+func splitPlaceholders(s string) []stringSegment {
+	var segments []stringSegment
+	last := 0
+	for _, match := range sensitivePattern.FindAllStringSubmatchIndex(s, -1) {
+		p, ok := fieldParameter(s[match[2]:match[3]])
+		if !ok {
+			continue
+		}
+		if match[0] > last {
+			segments = append(segments, stringSegment{text: s[last:match[0]]})
+		}
+		segments = append(segments, stringSegment{param: p.name()})
+		last = match[1]
+	}
+	if last < len(s) || len(segments) == 0 {
+		segments = append(segments, stringSegment{text: s[last:]})
+	}
+	return segments
+}
+
+// iacComponent is a rendered container app template parsed for conversion
+// into another output format
+type iacComponent struct {
+	// name is the container app name
+	name string
+	// file is the template the component was rendered from
+	file string
+	// content is the rendered template
+	content []byte
+	// doc is the rendered template's top level mapping
+	doc *yaml.Node
+}
+
+// field returns the value of key in the component's top level mapping
+func (c *iacComponent) field(key string) *yaml.Node {
+	return mappingValue(c.doc, key)
+}
+
+// identifier returns a camel cased identifier for the component, e.g.
+// bindplaneTransformAgent for bindplane-transform-agent
+func (c *iacComponent) identifier() string {
+	return camelCase(c.name)
+}
+
+// deployOrder returns the template files in the order they are deployed
+func deployOrder(config *Config) []string {
+	order := []string{"transform-agent.yaml"}
+	if config.DeployPrometheus {
+		order = append(order, "prometheus.yaml")
+	}
+	return append(order, "jobs.yaml", "bindplane.yaml", "otelcol.yaml")
+}
+
+// stageDescription describes the stage parameter of the Bicep and ARM
+// outputs. deploy.sh raises it one container app at a time and waits for each
+// to become healthy before the next.
+const stageDescription = "Deploy the container apps up to this one, in deploy order; the default deploys them all"
+
+// componentStage returns the stage that deploys the component rendered from
+// filename, its 1 based position in deploy order, or 0 if it isn't rendered
+func componentStage(components []*iacComponent, filename string) int {
+	for i, component := range components {
+		if component.file == filename {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// containerAppNames maps each template file to the name of the container app
+// it deploys
+var containerAppNames = map[string]string{
+	"transform-agent.yaml": "bindplane-transform-agent",
+	"prometheus.yaml":      "bindplane-prometheus",
+	"jobs.yaml":            "bindplane-jobs",
+	"bindplane.yaml":       "bindplane",
+	"otelcol.yaml":         "otelcol",
+}
+
+// renderComponents renders each template in deploy order with secrets
+// replaced by their sensitive markers
+func renderComponents(config *Config, data *TemplateData) ([]*iacComponent, error) {
+	return renderComponentsWith(config, parameterizedData(data))
+}
+
+// renderComponentsWith renders and parses each template in deploy order with
+// data as given
+func renderComponentsWith(config *Config, data *TemplateData) ([]*iacComponent, error) {
+	var components []*iacComponent
+	for _, filename := range deployOrder(config) {
+		content, err := renderTemplate(config, data, filename)
+		if err != nil {
+			return nil, err
+		}
+
+		component, err := parseComponent(filename, content)
+		if err != nil {
+			return nil, err
+		}
+		components = append(components, component)
+	}
+
+	return components, nil
+}
+
+// parseComponent parses a template rendered from filename
+func parseComponent(filename string, content []byte) (*iacComponent, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse rendered template %s: %w", filename, err)
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("rendered template %s is not a YAML mapping", filename)
+	}
+
+	component := &iacComponent{file: filename, content: content, doc: root.Content[0]}
+	name := component.field("name")
+	if name == nil || name.Value == "" {
+		return nil, fmt.Errorf("rendered template %s has no name", filename)
+	}
+	component.name = name.Value
+	return component, nil
+}
+
+// hasIngress reports whether the component configures ingress
+func (c *iacComponent) hasIngress() bool {
+	return mappingValue(mappingValue(c.field("properties"), "configuration"), "ingress") != nil
+}
+
+// environmentName returns the Container Apps environment name from its ID
+func environmentName(config *Config) string {
+	return path.Base(config.ACAEnvironmentID)
+}
+
+// mappingValue returns the value of key in a YAML mapping node
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// resolveAlias follows YAML aliases to the node they refer to
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+// deploymentGroupCommands deploys templateFile with az deployment group create,
+// reading secure parameters from the environment. kind names the template
+// format in the script's messages.
+func deploymentGroupCommands(config *Config, templateFile, kind string) []string {
+	commands := []string{
+		"#!/bin/bash",
+		fmt.Sprintf("# Generated %s deployment commands for Bindplane Azure Container Apps", kind),
+		"",
+		"set -e",
+		"",
+		"OUTPUT_DIR=" + shellQuote(config.OutputDir),
+		"",
+	}
+	commands = append(commands, envFileCommands()...)
+	commands = append(commands,
+		"",
+		"# Secure parameters are read from the environment or Key Vault so they never appear in this script",
+	)
+
+	var parameters []string
+	for _, p := range scriptParameters(config) {
+		env := envName(p.flag)
+		if p.keyVaultURI(config) != "" {
+			commands = append(commands, fmt.Sprintf("%s=\"%s\"", env, p.scriptValue(config)))
+		} else {
+			commands = append(commands, fmt.Sprintf(": \"%s\"", p.scriptValue(config)))
+		}
+		parameters = append(parameters, fmt.Sprintf("  --parameters %s=\"$%s\" \\", p.name(), env))
+	}
+
+	commands = append(commands,
+		"",
+		"# deploy_stage deploys the container apps up to the given stage, in deploy order",
+		"deploy_stage() {",
+		"  az deployment group create \\",
+		fmt.Sprintf("    --name %s \\", deploymentName),
+		fmt.Sprintf("    --resource-group %s \\", shellQuote(config.ResourceGroup)),
+		fmt.Sprintf("    --template-file \"$OUTPUT_DIR/%s\" \\", templateFile),
+	)
+	for _, parameter := range parameters {
+		commands = append(commands, "  "+parameter)
+	}
+	commands = append(commands,
+		"    --parameters stage=\"$1\" \\",
+		"    --output none",
+		"}",
+		"",
+	)
+	commands = append(commands, healthCheckCommands(config)...)
+	commands = append(commands,
+		"",
+		fmt.Sprintf("echo \"Deploying Bindplane to Azure Container Apps with %s...\"", kind),
+		"# Each stage adds one container app and waits for it to become healthy",
+	)
+	for i, filename := range deployOrder(config) {
+		commands = append(commands,
+			fmt.Sprintf("deploy_stage %d", i+1),
+			"wait_healthy "+containerAppNames[filename],
+		)
+	}
+	commands = append(commands,
+		"",
+		"echo \"Deployment complete!\"",
+		"",
+		"echo \"Container app FQDNs:\"",
+		fmt.Sprintf("az deployment group show --name %s --resource-group %s --query properties.outputs --output json", deploymentName, shellQuote(config.ResourceGroup)),
+	)
+
+	return commands
+}
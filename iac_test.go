@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// iacTestConfig returns the config used to render the infrastructure as code
+// golden files
+func iacTestConfig(t *testing.T) *Config {
+	return &Config{
+		ACAEnvironmentID:   "/subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.App/managedEnvironments/test-env",
+		StorageAccountName: "teststorageaccount",
+		StorageAccountKey:  "test-storage-key",
+		ResourceGroup:      "test-rg",
+		OutputDir:          t.TempDir(),
+		DeployPrometheus:   true,
+	}
+}
+
+// iacTestData returns the template data used to render the infrastructure as
+// code golden files
+func iacTestData() *TemplateData {
+	return &TemplateData{
+		ACAEnvironmentID:         "/subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.App/managedEnvironments/test-env",
+		PostgresHost:             "test-postgres.postgres.database.azure.com",
+		PostgresUsername:         "test_user",
+		PostgresDatabase:         "test_db",
+		License:                  "test-license-key",
+		PostgresPassword:         "test-password",
+		PostgresSSLMode:          "disable",
+		PostgresPort:             5432,
+		Base64License:            base64.StdEncoding.EncodeToString([]byte("test-license-key")),
+		Base64PostgresPassword:   base64.StdEncoding.EncodeToString([]byte("test-password")),
+		Base64StorageAccountName: base64.StdEncoding.EncodeToString([]byte("teststorageaccount")),
+		Base64StorageAccountKey:  base64.StdEncoding.EncodeToString([]byte("test-storage-key")),
+		ResourceGroup:            "test-rg",
+		BindplaneTag:             "1.94.3",
+		SessionSecret:            "test-session-secret",
+		BindplaneRemoteURL:       "http://localhost:3001",
+		AzureConnectionString:    "test-connection-string",
+		AzureTopic:               "test-topic",
+		AzureSubscriptionID:      "test-subscription-id",
+		AzureResourceGroup:       "test-rg",
+		AzureNamespace:           "test-namespace",
+		ManagedIdentityID:        "/subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/test-uai",
+		AzureClientID:            "test-client-id",
+
+		BindplanePostgresMaxConnections:     50,
+		BindplanePostgresMaxIdleConnections: 15,
+		JobsPostgresMaxConnections:          20,
+	}
+}
+
+// compareGolden compares output with the golden file at goldenPath, creating
+// the golden file if it doesn't exist
+func compareGolden(t *testing.T, goldenPath string, output []byte) {
+	t.Helper()
+
+	expected, err := os.ReadFile(goldenPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			if err := os.MkdirAll(filepath.Dir(goldenPath), 0755); err != nil {
+				t.Fatalf("Failed to create golden directory: %v", err)
+			}
+			if err := os.WriteFile(goldenPath, output, 0644); err != nil {
+				t.Fatalf("Failed to write golden file %s: %v", goldenPath, err)
+			}
+			t.Logf("Created golden file %s", goldenPath)
+			return
+		}
+		t.Fatalf("Failed to read golden file %s: %v", goldenPath, err)
+	}
+
+	if !bytes.Equal(output, expected) {
+		t.Errorf("Output doesn't match golden file %s.\nExpected:\n%s\nGot:\n%s", goldenPath, string(expected), string(output))
+	}
+}
+
+// assertNoSecrets fails if output contains any secret value from data
+func assertNoSecrets(t *testing.T, output []byte, config *Config, data *TemplateData) {
+	t.Helper()
+
+	secrets := []string{config.StorageAccountKey}
+	for _, p := range secretParameters {
+		if value, ok := sensitiveValue(data, p.field); ok {
+			secrets = append(secrets, value)
+		}
+	}
+	for _, secret := range secrets {
+		if secret != "" && bytes.Contains(output, []byte(secret)) {
+			t.Errorf("Output contains secret value %q", secret)
+		}
+	}
+}
+
+func TestSecretParameterNames(t *testing.T) {
+	var names []string
+	for _, p := range secretParameters {
+		names = append(names, p.name())
+	}
+
+	expected := []string{"license", "postgresPassword", "sessionSecret", "azureConnectionString", "storageAccountKey"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Parameter names mismatch. Expected: %v, Got: %v", expected, names)
+	}
+}
+
+func TestSecretParameterFields(t *testing.T) {
+	var templated []string
+	for _, p := range secretParameters {
+		if _, ok := sensitiveValue(&Config{}, p.field); !ok {
+			t.Errorf("Parameter %s field %s is not a sensitive Config field", p.name(), p.field)
+		}
+		if p.templated() {
+			templated = append(templated, p.name())
+		}
+	}
+
+	expected := []string{"license", "postgresPassword", "sessionSecret", "azureConnectionString"}
+	if !reflect.DeepEqual(templated, expected) {
+		t.Errorf("Templated parameters mismatch. Expected: %v, Got: %v", expected, templated)
+	}
+}
+
+func TestSplitPlaceholders(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []stringSegment
+	}{
+		{"plain", []stringSegment{{text: "plain"}}},
+		{"", []stringSegment{{text: ""}}},
+		{sensitiveMarker("License"), []stringSegment{{param: "license"}}},
+		{
+			"host=db;password=" + sensitiveMarker("PostgresPassword") + ";",
+			[]stringSegment{{text: "host=db;password="}, {param: "postgresPassword"}, {text: ";"}},
+		},
+		// Markers of sensitive fields that aren't parameters are left as text
+		{sensitiveMarker("Base64License"), []stringSegment{{text: sensitiveMarker("Base64License")}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			if got := splitPlaceholders(tc.input); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Segments mismatch. Expected: %v, Got: %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestRenderComponents(t *testing.T) {
+	config := iacTestConfig(t)
+	data := iacTestData()
+
+	components, err := renderComponents(config, data)
+	if err != nil {
+		t.Fatalf("Failed to render components: %v", err)
+	}
+
+	var names []string
+	for _, c := range components {
+		names = append(names, c.name)
+	}
+	expected := []string{"bindplane-transform-agent", "bindplane-prometheus", "bindplane-jobs", "bindplane", "otelcol"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Component order mismatch. Expected: %v, Got: %v", expected, names)
+	}
+
+	// The caller's data must not be modified by the placeholder substitution
+	if data.License != "test-license-key" {
+		t.Errorf("Template data was modified: %s", data.License)
+	}
+
+	config.DeployPrometheus = false
+	components, err = renderComponents(config, data)
+	if err != nil {
+		t.Fatalf("Failed to render components: %v", err)
+	}
+	for _, c := range components {
+		if strings.Contains(c.name, "prometheus") {
+			t.Errorf("Unexpected prometheus component when deploy-prometheus is false")
+		}
+	}
+}
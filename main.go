@@ -1,59 +1,105 @@
 package main
 
 import (
+	"bytes"
 	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
 	"text/template"
+	"time"
 )
 
-// TemplateData holds all the values to be injected into the templates
+// TemplateData holds all the values to be injected into the templates.
+// Fields tagged sensitive may only be rendered as container app secrets.
 type TemplateData struct {
-	ACAEnvironmentID         string
-	PostgresHost             string
-	PostgresUsername         string
-	PostgresDatabase         string
-	License                  string
-	PostgresPassword         string
-	PostgresSSLMode          string
-	Base64License            string
-	Base64PostgresPassword   string
+	ACAEnvironmentID string
+	PostgresHost     string
+	PostgresUsername string
+	PostgresDatabase string
+	License          string `sensitive:"true"`
+	PostgresPassword string `sensitive:"true"`
+	PostgresSSLMode  string
+	PostgresPort     int
+	// PostgresCACert is the PEM encoded CA certificate bundle mounted into the
+	// Bindplane containers, if any
+	PostgresCACert           string
+	Base64License            string `sensitive:"true"`
+	Base64PostgresPassword   string `sensitive:"true"`
 	Base64StorageAccountName string
-	Base64StorageAccountKey  string
+	Base64StorageAccountKey  string `sensitive:"true"`
 	ResourceGroup            string
 	BindplaneTag             string
-	SessionSecret            string
+	SessionSecret            string `sensitive:"true"`
 	BindplaneRemoteURL       string
-	AzureConnectionString    string
+	AzureConnectionString    string `sensitive:"true"`
 	AzureTopic               string
 	AzureSubscriptionID      string
 	AzureResourceGroup       string
 	AzureNamespace           string
 	ManagedIdentityID        string
 	AzureClientID            string
+
+	// BindplaneMultipleRevisions keeps several bindplane revisions active so
+	// rollout can shift traffic between them
+	BindplaneMultipleRevisions bool
+
+	// PostgreSQL connection pool sizes for each component, zero for the
+	// Bindplane default
+	BindplanePostgresMaxConnections     int
+	BindplanePostgresMaxIdleConnections int
+	JobsPostgresMaxConnections          int
+	JobsPostgresMaxIdleConnections      int
+
+	// Key Vault secret URIs used in place of the literal secrets when set
+	LicenseKeyVaultURI               string
+	PostgresPasswordKeyVaultURI      string
+	SessionSecretKeyVaultURI         string
+	AzureConnectionStringKeyVaultURI string
 }
 
-// Config holds command line arguments
+// defaultBindplaneTag is the Bindplane image tag used unless -bindplane-tag
+// is set
+const defaultBindplaneTag = "1.94.3"
+
+// Output formats supported by -output-format
+const (
+	outputFormatYAML      = "yaml"
+	outputFormatBicep     = "bicep"
+	outputFormatTerraform = "terraform"
+	outputFormatARM       = "arm"
+)
+
+// outputFormats lists the valid -output-format values
+var outputFormats = []string{outputFormatYAML, outputFormatBicep, outputFormatTerraform, outputFormatARM}
+
+// Config holds command line arguments. Fields tagged sensitive are never
+// written into deploy.sh.
 type Config struct {
 	ACAEnvironmentID      string
 	PostgresHost          string
 	PostgresUsername      string
 	PostgresDatabase      string
-	License               string
-	PostgresPassword      string
+	License               string `sensitive:"true"`
+	PostgresPassword      string `sensitive:"true"`
 	PostgresSSLMode       string
+	PostgresPort          int
+	PostgresCACertFile    string
 	StorageAccountName    string
-	StorageAccountKey     string
+	StorageAccountKey     string `sensitive:"true"`
 	ResourceGroup         string
 	OutputDir             string
 	TemplatesDir          string
 	BindplaneTag          string
-	SessionSecret         string
+	SessionSecret         string `sensitive:"true"`
 	BindplaneRemoteURL    string
-	AzureConnectionString string
+	AzureConnectionString string `sensitive:"true"`
 	AzureTopic            string
 	AzureSubscriptionID   string
 	AzureResourceGroup    string
@@ -61,17 +107,39 @@ type Config struct {
 	ManagedIdentityID     string
 	AzureClientID         string
 	DeployPrometheus      bool
+	ConfigFile            string
+	OutputFormat          string
+	HealthTimeout         time.Duration
+
+	// BindplaneRevisionsMode is the active revisions mode of the bindplane
+	// app, one of revisionsModes
+	BindplaneRevisionsMode string
+
+	// PostgreSQL connection pool sizes for each component, zero for the
+	// Bindplane default
+	BindplanePostgresMaxConnections     int
+	BindplanePostgresMaxIdleConnections int
+	JobsPostgresMaxConnections          int
+	JobsPostgresMaxIdleConnections      int
+
+	// Key Vault secret URIs, alternatives to the literal secrets above
+	LicenseKeyVaultURI               string
+	PostgresPasswordKeyVaultURI      string
+	SessionSecretKeyVaultURI         string
+	AzureConnectionStringKeyVaultURI string
+	StorageAccountKeyKeyVaultURI     string
+
+	// sources maps flag names to where their value came from
+	sources map[string]string
 }
 
 func main() {
-	config := parseFlags()
-
-	if err := validateConfig(config); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
-	}
+	os.Exit(run(os.Args[1:]))
+}
 
-	templateData := &TemplateData{
+// newTemplateData builds the template data from a validated config
+func newTemplateData(config *Config) (*TemplateData, error) {
+	data := &TemplateData{
 		ACAEnvironmentID:         config.ACAEnvironmentID,
 		PostgresHost:             config.PostgresHost,
 		PostgresUsername:         config.PostgresUsername,
@@ -79,6 +147,7 @@ func main() {
 		License:                  config.License,
 		PostgresPassword:         config.PostgresPassword,
 		PostgresSSLMode:          config.PostgresSSLMode,
+		PostgresPort:             config.PostgresPort,
 		Base64License:            base64.StdEncoding.EncodeToString([]byte(config.License)),
 		Base64PostgresPassword:   base64.StdEncoding.EncodeToString([]byte(config.PostgresPassword)),
 		Base64StorageAccountName: base64.StdEncoding.EncodeToString([]byte(config.StorageAccountName)),
@@ -94,93 +163,287 @@ func main() {
 		AzureNamespace:           config.AzureNamespace,
 		ManagedIdentityID:        config.ManagedIdentityID,
 		AzureClientID:            config.AzureClientID,
+
+		BindplaneMultipleRevisions: config.BindplaneRevisionsMode == revisionsModeMultiple,
+
+		BindplanePostgresMaxConnections:     config.BindplanePostgresMaxConnections,
+		BindplanePostgresMaxIdleConnections: config.BindplanePostgresMaxIdleConnections,
+		JobsPostgresMaxConnections:          config.JobsPostgresMaxConnections,
+		JobsPostgresMaxIdleConnections:      config.JobsPostgresMaxIdleConnections,
+
+		LicenseKeyVaultURI:               config.LicenseKeyVaultURI,
+		PostgresPasswordKeyVaultURI:      config.PostgresPasswordKeyVaultURI,
+		SessionSecretKeyVaultURI:         config.SessionSecretKeyVaultURI,
+		AzureConnectionStringKeyVaultURI: config.AzureConnectionStringKeyVaultURI,
 	}
 
-	if err := processTemplates(config, templateData); err != nil {
-		fmt.Fprintf(os.Stderr, "Error processing templates: %v\n", err)
-		os.Exit(1)
+	if config.PostgresCACertFile != "" {
+		caCert, err := readPostgresCACert(config.PostgresCACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read postgres-ca-cert-file %s: %w", config.PostgresCACertFile, err)
+		}
+		data.PostgresCACert = caCert
 	}
 
-	generateDeploymentCommands(config)
+	return data, nil
+}
 
-	fmt.Printf("Templates processed successfully. Output files generated in: %s\n", config.OutputDir)
+// parseFlags parses the flags of the generate command
+func parseFlags(args []string) (*Config, error) {
+	return parseConfigFlags(newFlagSet("generate", generateUsage), args)
 }
 
-func parseFlags() *Config {
+// parseConfigFlags registers the flags that make up a Config on fs, next to
+// any the command registered itself, then parses args and fills the values
+// not given on the command line from the environment and the config file
+func parseConfigFlags(fs *flag.FlagSet, args []string) (*Config, error) {
 	config := &Config{}
 
-	flag.StringVar(&config.ACAEnvironmentID, "aca-environment-id", "", "Azure Container Apps Environment ID (required)")
-	flag.StringVar(&config.PostgresHost, "postgres-host", "", "PostgreSQL hostname (required)")
-	flag.StringVar(&config.PostgresUsername, "postgres-username", "", "PostgreSQL username (required)")
-	flag.StringVar(&config.PostgresDatabase, "postgres-database", "", "PostgreSQL database name (required)")
-	flag.StringVar(&config.License, "license", "", "Bindplane license key (required)")
-	flag.StringVar(&config.PostgresPassword, "postgres-password", "", "PostgreSQL password (required)")
-	flag.StringVar(&config.PostgresSSLMode, "postgres-ssl-mode", "disable", "PostgreSQL SSL mode (disable, require, verify-ca, verify-full)")
-	flag.StringVar(&config.StorageAccountName, "storage-account-name", "", "Azure Storage Account name (required)")
-	flag.StringVar(&config.StorageAccountKey, "storage-account-key", "", "Azure Storage Account key (required)")
-	flag.StringVar(&config.ResourceGroup, "resource-group", "", "Azure Resource Group name (required)")
-	flag.StringVar(&config.OutputDir, "output-dir", "out", "Output directory for generated files")
-	flag.StringVar(&config.TemplatesDir, "templates-dir", "templates", "Templates directory")
-	flag.StringVar(&config.BindplaneTag, "bindplane-tag", "1.94.3", "Bindplane image tag (default 1.94.3)")
-	flag.StringVar(&config.SessionSecret, "session-secret", "", "Bindplane session secret (required)")
-	flag.StringVar(&config.BindplaneRemoteURL, "bindplane-remote-url", "http://localhost:3001", "Bindplane remote URL (default http://localhost:3001)")
-	flag.StringVar(&config.AzureConnectionString, "azure-connection-string", "", "Azure Service Bus connection string (required)")
-	flag.StringVar(&config.AzureTopic, "azure-topic", "", "Azure Service Bus topic name (required)")
-	flag.StringVar(&config.AzureSubscriptionID, "azure-subscription-id", "", "Azure subscription ID (required)")
-	flag.StringVar(&config.AzureResourceGroup, "azure-resource-group", "", "Azure resource group name (required)")
-	flag.StringVar(&config.AzureNamespace, "azure-namespace", "", "Azure Service Bus namespace (required)")
-	flag.StringVar(&config.ManagedIdentityID, "managed-identity-id", "", "User-assigned managed identity ID (required for UAI path)")
-	flag.StringVar(&config.AzureClientID, "azure-client-id", "", "Azure managed identity client ID (required for UAI path)")
-	flag.BoolVar(&config.DeployPrometheus, "deploy-prometheus", false, "Deploy Prometheus (default false)")
-
-	flag.Parse()
-
-	return config
+	fs.StringVar(&config.ConfigFile, "config", "", "Path to a YAML or JSON config file (command line flags take precedence)")
+
+	fs.StringVar(&config.ACAEnvironmentID, "aca-environment-id", "", "Azure Container Apps Environment ID (required)")
+	fs.StringVar(&config.PostgresHost, "postgres-host", "", "PostgreSQL hostname (required)")
+	fs.StringVar(&config.PostgresUsername, "postgres-username", "", "PostgreSQL username (required)")
+	fs.StringVar(&config.PostgresDatabase, "postgres-database", "", "PostgreSQL database name (required)")
+	fs.StringVar(&config.License, "license", "", "Bindplane license key (required)")
+	fs.StringVar(&config.PostgresPassword, "postgres-password", "", "PostgreSQL password (required)")
+	fs.StringVar(&config.PostgresSSLMode, "postgres-ssl-mode", "disable", "PostgreSQL SSL mode ("+strings.Join(postgresSSLModes, ", ")+")")
+	fs.IntVar(&config.PostgresPort, "postgres-port", 5432, "PostgreSQL port (0 for the Bindplane default)")
+	fs.StringVar(&config.PostgresCACertFile, "postgres-ca-cert-file", "", "PEM CA certificate file used to verify the PostgreSQL server, mounted into Bindplane as a secret volume")
+	fs.IntVar(&config.BindplanePostgresMaxConnections, "bindplane-postgres-max-connections", 50, "Maximum PostgreSQL connections per bindplane replica (0 for the Bindplane default)")
+	fs.IntVar(&config.BindplanePostgresMaxIdleConnections, "bindplane-postgres-max-idle-connections", 15, "Maximum idle PostgreSQL connections per bindplane replica (0 for the Bindplane default)")
+	fs.IntVar(&config.JobsPostgresMaxConnections, "jobs-postgres-max-connections", 20, "Maximum PostgreSQL connections for bindplane-jobs (0 for the Bindplane default)")
+	fs.IntVar(&config.JobsPostgresMaxIdleConnections, "jobs-postgres-max-idle-connections", 0, "Maximum idle PostgreSQL connections for bindplane-jobs (0 for the Bindplane default)")
+	fs.StringVar(&config.StorageAccountName, "storage-account-name", "", "Azure Storage Account name (required)")
+	fs.StringVar(&config.StorageAccountKey, "storage-account-key", "", "Azure Storage Account key (required)")
+	fs.StringVar(&config.ResourceGroup, "resource-group", "", "Azure Resource Group name (required)")
+	fs.StringVar(&config.OutputDir, "output-dir", "out", "Output directory for generated files")
+	fs.StringVar(&config.TemplatesDir, "templates-dir", "", "Directory of template overrides; files here replace the embedded templates of the same name")
+	fs.StringVar(&config.BindplaneTag, "bindplane-tag", defaultBindplaneTag, "Bindplane image tag")
+	fs.StringVar(&config.SessionSecret, "session-secret", "", "Bindplane session secret (required)")
+	fs.StringVar(&config.BindplaneRemoteURL, "bindplane-remote-url", "http://localhost:3001", "Bindplane remote URL")
+	fs.StringVar(&config.BindplaneRevisionsMode, "bindplane-revisions-mode", revisionsModeSingle, "Active revisions mode of the bindplane app: single, or multiple to upgrade it with the rollout command")
+	fs.StringVar(&config.AzureConnectionString, "azure-connection-string", "", "Azure Service Bus connection string (required)")
+	fs.StringVar(&config.AzureTopic, "azure-topic", "", "Azure Service Bus topic name (required)")
+	fs.StringVar(&config.AzureSubscriptionID, "azure-subscription-id", "", "Azure subscription ID (required)")
+	fs.StringVar(&config.AzureResourceGroup, "azure-resource-group", "", "Azure resource group name (required)")
+	fs.StringVar(&config.AzureNamespace, "azure-namespace", "", "Azure Service Bus namespace (required)")
+	fs.StringVar(&config.ManagedIdentityID, "managed-identity-id", "", "User-assigned managed identity ID (required for UAI path)")
+	fs.StringVar(&config.AzureClientID, "azure-client-id", "", "Azure managed identity client ID (required for UAI path)")
+	fs.BoolVar(&config.DeployPrometheus, "deploy-prometheus", false, "Deploy Prometheus")
+	fs.StringVar(&config.OutputFormat, "output-format", outputFormatYAML, "Output format: yaml (az containerapp YAML), bicep, terraform or arm")
+	fs.DurationVar(&config.HealthTimeout, "health-timeout", defaultHealthTimeout, "How long deploy.sh waits for each container app to become healthy before failing with its logs (0 to skip the checks)")
+	fs.StringVar(&config.LicenseKeyVaultURI, "license-kv-uri", "", "Key Vault secret URI for the Bindplane license (alternative to -license)")
+	fs.StringVar(&config.PostgresPasswordKeyVaultURI, "postgres-password-kv-uri", "", "Key Vault secret URI for the PostgreSQL password (alternative to -postgres-password)")
+	fs.StringVar(&config.SessionSecretKeyVaultURI, "session-secret-kv-uri", "", "Key Vault secret URI for the session secret (alternative to -session-secret)")
+	fs.StringVar(&config.AzureConnectionStringKeyVaultURI, "azure-connection-string-kv-uri", "", "Key Vault secret URI for the Service Bus connection string (alternative to -azure-connection-string)")
+	fs.StringVar(&config.StorageAccountKeyKeyVaultURI, "storage-account-key-kv-uri", "", "Key Vault secret URI for the storage account key (alternative to -storage-account-key)")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if err := resolveSources(fs, config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
 }
 
 func validateConfig(config *Config) error {
 	required := map[string]string{
-		"aca-environment-id":      config.ACAEnvironmentID,
-		"postgres-host":           config.PostgresHost,
-		"postgres-username":       config.PostgresUsername,
-		"postgres-database":       config.PostgresDatabase,
-		"license":                 config.License,
-		"postgres-password":       config.PostgresPassword,
-		"storage-account-name":    config.StorageAccountName,
-		"storage-account-key":     config.StorageAccountKey,
-		"resource-group":          config.ResourceGroup,
-		"session-secret":          config.SessionSecret,
-		"azure-connection-string": config.AzureConnectionString,
-		"azure-topic":             config.AzureTopic,
-		"azure-subscription-id":   config.AzureSubscriptionID,
-		"azure-resource-group":    config.AzureResourceGroup,
-		"azure-namespace":         config.AzureNamespace,
+		"aca-environment-id":    config.ACAEnvironmentID,
+		"postgres-host":         config.PostgresHost,
+		"postgres-username":     config.PostgresUsername,
+		"postgres-database":     config.PostgresDatabase,
+		"storage-account-name":  config.StorageAccountName,
+		"resource-group":        config.ResourceGroup,
+		"azure-topic":           config.AzureTopic,
+		"azure-subscription-id": config.AzureSubscriptionID,
+		"azure-resource-group":  config.AzureResourceGroup,
+		"azure-namespace":       config.AzureNamespace,
 		// For UAI flow, these must be provided. We'll require them unconditionally for simplicity
 		"managed-identity-id": config.ManagedIdentityID,
 		"azure-client-id":     config.AzureClientID,
 	}
 
+	// Secrets may be given literally or as a Key Vault reference, but not both
+	secrets := []struct {
+		flag, value, kvValue string
+	}{
+		{"license", config.License, config.LicenseKeyVaultURI},
+		{"postgres-password", config.PostgresPassword, config.PostgresPasswordKeyVaultURI},
+		{"storage-account-key", config.StorageAccountKey, config.StorageAccountKeyKeyVaultURI},
+		{"session-secret", config.SessionSecret, config.SessionSecretKeyVaultURI},
+		{"azure-connection-string", config.AzureConnectionString, config.AzureConnectionStringKeyVaultURI},
+	}
+
 	var missing []string
 	for flag, value := range required {
 		if value == "" {
-			missing = append(missing, flag)
+			missing = append(missing, fmt.Sprintf("%s (%s)", flag, config.sourceOf(flag)))
+		}
+	}
+
+	var errs []error
+	for _, secret := range secrets {
+		kvFlag := secret.flag + "-kv-uri"
+		switch {
+		case secret.value == "" && secret.kvValue == "":
+			missing = append(missing, fmt.Sprintf("%s (%s) or %s (%s)", secret.flag, config.sourceOf(secret.flag), kvFlag, config.sourceOf(kvFlag)))
+		case secret.value != "" && secret.kvValue != "":
+			errs = append(errs, fmt.Errorf("only one of %s (%s) and %s (%s) may be set",
+				secret.flag, config.sourceOf(secret.flag), kvFlag, config.sourceOf(kvFlag)))
+		case secret.kvValue != "" && !keyVaultSecretURIPattern.MatchString(secret.kvValue):
+			errs = append(errs, fmt.Errorf("invalid %s %q (%s): must be a Key Vault secret URI such as https://my-vault.vault.azure.net/secrets/my-secret",
+				kvFlag, secret.kvValue, config.sourceOf(kvFlag)))
 		}
 	}
 
 	if len(missing) > 0 {
-		return fmt.Errorf("missing required flags: %s", strings.Join(missing, ", "))
+		sort.Strings(missing)
+		errs = append([]error{fmt.Errorf("missing required values: %s", strings.Join(missing, ", "))}, errs...)
 	}
 
-	return nil
+	// Check the format of the values that are set
+	checks := []struct {
+		flag  string
+		value string
+		check func(string) error
+	}{
+		{"aca-environment-id", config.ACAEnvironmentID, func(id string) error {
+			return checkResourceID(id, "Microsoft.App", "managedEnvironments", config.AzureSubscriptionID)
+		}},
+		{"managed-identity-id", config.ManagedIdentityID, func(id string) error {
+			return checkResourceID(id, "Microsoft.ManagedIdentity", "userAssignedIdentities", config.AzureSubscriptionID)
+		}},
+		{"storage-account-name", config.StorageAccountName, checkStorageAccountName},
+		{"azure-namespace", config.AzureNamespace, checkServiceBusNamespaceName},
+		{"azure-topic", config.AzureTopic, checkServiceBusTopicName},
+		{"postgres-ssl-mode", config.PostgresSSLMode, checkPostgresSSLMode},
+		{"bindplane-revisions-mode", config.BindplaneRevisionsMode, checkRevisionsMode},
+		{"postgres-ca-cert-file", config.PostgresCACertFile, func(path string) error {
+			_, err := readPostgresCACert(path)
+			return err
+		}},
+	}
+	for _, c := range checks {
+		if c.value == "" {
+			continue
+		}
+		if err := c.check(c.value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s %q (%s): %w", c.flag, c.value, config.sourceOf(c.flag), err))
+		}
+	}
+
+	if err := checkPostgresPort(config.PostgresPort); err != nil {
+		errs = append(errs, fmt.Errorf("invalid postgres-port %d (%s): %w", config.PostgresPort, config.sourceOf("postgres-port"), err))
+	}
+	pools := []struct {
+		component               string
+		maxConnections, maxIdle int
+	}{
+		{"bindplane", config.BindplanePostgresMaxConnections, config.BindplanePostgresMaxIdleConnections},
+		{"jobs", config.JobsPostgresMaxConnections, config.JobsPostgresMaxIdleConnections},
+	}
+	for _, pool := range pools {
+		if err := checkPostgresConnections(pool.maxConnections, pool.maxIdle); err != nil {
+			maxFlag, idleFlag := pool.component+"-postgres-max-connections", pool.component+"-postgres-max-idle-connections"
+			errs = append(errs, fmt.Errorf("invalid %s (%s) and %s (%s): %w",
+				maxFlag, config.sourceOf(maxFlag), idleFlag, config.sourceOf(idleFlag), err))
+		}
+	}
+
+	if err := checkHealthTimeout(config.HealthTimeout); err != nil {
+		errs = append(errs, fmt.Errorf("invalid health-timeout %s (%s): %w", config.HealthTimeout, config.sourceOf("health-timeout"), err))
+	}
+
+	if config.OutputFormat != "" && !slices.Contains(outputFormats, config.OutputFormat) {
+		errs = append(errs, fmt.Errorf("invalid output-format %q (%s): must be one of %s", config.OutputFormat, config.sourceOf("output-format"), strings.Join(outputFormats, ", ")))
+	}
+
+	return errors.Join(errs...)
+}
+
+// keyVaultSecretURIPattern matches Key Vault secret URIs, with or without a
+// secret version
+var keyVaultSecretURIPattern = regexp.MustCompile(`^https://[A-Za-z0-9-]+\.vault\.[A-Za-z0-9.-]+/secrets/[A-Za-z0-9-]+(/[A-Za-z0-9]+)?/?$`)
+
+// outputFile is a generated file, named relative to the output directory
+type outputFile struct {
+	name    string
+	content []byte
 }
 
 func processTemplates(config *Config, data *TemplateData) error {
+	files, err := renderOutputs(config, data)
+	if err != nil {
+		return err
+	}
+
 	// Create output directory
 	if err := os.MkdirAll(config.OutputDir, 0755); err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	// Template files to process (prometheus is optional)
+	for _, file := range files {
+		outputPath := filepath.Join(config.OutputDir, file.name)
+		if err := os.WriteFile(outputPath, file.content, 0644); err != nil {
+			return fmt.Errorf("failed to create output file %s: %w", outputPath, err)
+		}
+		fmt.Printf("Generated: %s\n", outputPath)
+	}
+
+	return nil
+}
+
+// renderOutputs renders the files of the configured output format without
+// writing anything
+func renderOutputs(config *Config, data *TemplateData) ([]outputFile, error) {
+	switch config.OutputFormat {
+	case outputFormatBicep:
+		content, err := renderBicep(config, data)
+		if err != nil {
+			return nil, err
+		}
+		return []outputFile{{bicepFile, content}}, nil
+	case outputFormatARM:
+		content, err := renderARM(config, data)
+		if err != nil {
+			return nil, err
+		}
+		return []outputFile{{armFile, content}}, nil
+	case outputFormatTerraform:
+		rendered, err := renderTerraform(config, data)
+		if err != nil {
+			return nil, err
+		}
+		var files []outputFile
+		for _, filename := range terraformFiles {
+			if content, ok := rendered[filename]; ok {
+				files = append(files, outputFile{filename, content})
+			}
+		}
+		return files, nil
+	}
+
+	var files []outputFile
+	for _, filename := range templateFiles(config) {
+		content, err := renderTemplate(config, data, filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process template %s: %w", filename, err)
+		}
+		files = append(files, outputFile{filename, content})
+	}
+
+	return files, nil
+}
+
+// templateFiles returns the template files to process (prometheus is optional)
+func templateFiles(config *Config) []string {
 	templateFiles := []string{
 		"bindplane.yaml",
 		"jobs.yaml",
@@ -192,53 +455,114 @@ func processTemplates(config *Config, data *TemplateData) error {
 		const insertIdx = 2
 		templateFiles = append(templateFiles[:insertIdx], append([]string{"prometheus.yaml"}, templateFiles[insertIdx:]...)...)
 	}
+	return templateFiles
+}
 
-	for _, filename := range templateFiles {
-		if err := processTemplate(config, data, filename); err != nil {
-			return fmt.Errorf("failed to process template %s: %w", filename, err)
-		}
+// renderTemplate executes the named template against data, failing if it
+// leaves a required value empty or renders a secret outside
+// configuration.secrets
+func renderTemplate(config *Config, data *TemplateData, filename string) ([]byte, error) {
+	// Read template, preferring an override in the templates directory
+	templateContent, source, err := readTemplate(config.TemplatesDir, filename)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
-}
+	// Parse and execute template
+	tmpl, err := template.New(filename).Funcs(templateFuncs).Option("missingkey=error").Parse(annotateRequired(string(templateContent)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", filename, err)
+	}
 
-func processTemplate(config *Config, data *TemplateData, filename string) error {
-	templatePath := filepath.Join(config.TemplatesDir, filename)
-	outputPath := filepath.Join(config.OutputDir, filename)
+	var output bytes.Buffer
+	if err := tmpl.Execute(&output, data); err != nil {
+		return nil, fmt.Errorf("failed to execute template %s: %w", filename, err)
+	}
 
-	// Read template file
-	templateContent, err := os.ReadFile(templatePath)
+	source = strings.TrimPrefix(source, "embedded:")
+	content, err := checkRequiredValues(source, templateContent, output.Bytes())
 	if err != nil {
-		return fmt.Errorf("failed to read template file %s: %w", templatePath, err)
+		return nil, err
 	}
 
-	// Parse and execute template
-	tmpl, err := template.New(filename).Parse(string(templateContent))
-	if err != nil {
-		return fmt.Errorf("failed to parse template %s: %w", filename, err)
+	if err := validateContainerApp(source, templateContent, content); err != nil {
+		return nil, err
 	}
 
-	// Create output file
-	outputFile, err := os.Create(outputPath)
+	// Render again with the secrets marked to check where they end up
+	marked, markers := markSensitive(data)
+	var markedOutput bytes.Buffer
+	if err := tmpl.Execute(&markedOutput, marked); err != nil {
+		return nil, fmt.Errorf("failed to execute template %s: %w", filename, err)
+	}
+	if err := checkSecretPlacement(source, markedOutput.Bytes(), markers); err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
+
+// deployScript is the name of the generated deployment script
+const deployScript = "deploy.sh"
+
+func generateDeploymentCommands(config *Config) error {
+	commandsFile := filepath.Join(config.OutputDir, deployScript)
+
+	script, err := renderDeploymentScript(config)
 	if err != nil {
-		return fmt.Errorf("failed to create output file %s: %w", outputPath, err)
+		return err
 	}
-	defer outputFile.Close()
 
-	// Execute template
-	if err := tmpl.Execute(outputFile, data); err != nil {
-		return fmt.Errorf("failed to execute template %s: %w", filename, err)
+	if err := os.WriteFile(commandsFile, script, 0755); err != nil {
+		return fmt.Errorf("failed to create deployment script: %w", err)
 	}
 
-	fmt.Printf("Generated: %s\n", outputPath)
+	// WriteFile keeps the mode of an existing file, so make sure it's executable
+	if err := os.Chmod(commandsFile, 0755); err != nil {
+		return fmt.Errorf("failed to make deployment script executable: %w", err)
+	}
 
-	return nil
+	fmt.Printf("Deployment script generated: %s\n", commandsFile)
+
+	return writeEnvFile(config)
 }
 
-func generateDeploymentCommands(config *Config) {
-	commandsFile := filepath.Join(config.OutputDir, "deploy.sh")
+// renderDeploymentScript renders deploy.sh for the configured output format,
+// refusing to include any secret
+func renderDeploymentScript(config *Config) ([]byte, error) {
+	// Render again with the secrets marked to check none ends up in the script
+	marked, markers := markSensitive(config)
+	markedScript := strings.Join(deploymentCommands(marked), "\n")
+	if err := checkScriptSecrets(filepath.Join(config.OutputDir, deployScript), markedScript, markers); err != nil {
+		return nil, err
+	}
 
-	outputDirVar := fmt.Sprintf("OUTPUT_DIR=\"%s\"", config.OutputDir)
+	return []byte(strings.Join(deploymentCommands(config), "\n") + "\n"), nil
+}
+
+// deploymentCommands returns the lines of deploy.sh for the configured output
+// format
+func deploymentCommands(config *Config) []string {
+	switch config.OutputFormat {
+	case outputFormatBicep:
+		return deploymentGroupCommands(config, bicepFile, "Bicep")
+	case outputFormatARM:
+		return deploymentGroupCommands(config, armFile, "ARM template")
+	case outputFormatTerraform:
+		return terraformDeploymentCommands(config)
+	default:
+		return yamlDeploymentCommands(config)
+	}
+}
+
+// yamlDeploymentCommands deploys each rendered YAML file, creating each
+// container app or updating it when it already exists, so the script can be
+// rerun to upgrade a deployment
+func yamlDeploymentCommands(config *Config) []string {
+	outputDirVar := "OUTPUT_DIR=" + shellQuote(config.OutputDir)
+	resourceGroup := shellQuote(config.ResourceGroup)
+	storageAccountName := shellQuote(config.StorageAccountName)
+	storageAccountKey, _ := lookupSecretParameter("storageAccountKey")
 	commands := []string{
 		"#!/bin/bash",
 		"# Generated deployment commands for Bindplane Azure Container Apps",
@@ -246,59 +570,73 @@ func generateDeploymentCommands(config *Config) {
 		"set -e",
 		"",
 		outputDirVar,
+		"RESOURCE_GROUP=" + resourceGroup,
+		"",
+	}
+	commands = append(commands, envFileCommands()...)
+	commands = append(commands, "")
+	commands = append(commands, healthCheckCommands(config)...)
+	return append(commands,
+		"",
+		"# deploy_app creates a container app from its YAML, or updates it when it",
+		"# already exists",
+		"deploy_app() {",
+		"  if az containerapp show --name \"$1\" --resource-group \"$RESOURCE_GROUP\" --output none 2>/dev/null; then",
+		"    echo \"Updating existing container app $1\"",
+		"    az containerapp update --name \"$1\" --resource-group \"$RESOURCE_GROUP\" --yaml \"$2\"",
+		"  else",
+		"    echo \"Creating container app $1\"",
+		"    az containerapp create --name \"$1\" --resource-group \"$RESOURCE_GROUP\" --yaml \"$2\"",
+		"  fi",
+		"}",
 		"",
 		"echo \"Deploying Bindplane to Azure Container Apps...\"",
 		"",
 		"# Ensure environment storage exists for Azure Files volumes",
-		fmt.Sprintf("ENV_NAME=$(basename %s)", config.ACAEnvironmentID),
-		fmt.Sprintf("echo \"Using Container Apps environment: $ENV_NAME in resource group %s\"", config.ResourceGroup),
+		"ENV_NAME="+shellQuote(environmentName(config)),
+		"echo \"Using Container Apps environment: $ENV_NAME in resource group $RESOURCE_GROUP\"",
 		"",
 		"# Deploy Prometheus only if prometheus.yaml is present",
 		"if [ -f \"$OUTPUT_DIR/prometheus.yaml\" ]; then",
-		fmt.Sprintf("  az containerapp env storage set --name \"$ENV_NAME\" --resource-group %s --storage-name prometheus-pv --azure-file-account-name \"%s\" --azure-file-account-key \"%s\" --azure-file-share-name prometheus-data --access-mode ReadWrite || true", config.ResourceGroup, config.StorageAccountName, config.StorageAccountKey),
+		"  # An existing storage can only have its account key updated, so refuse one",
+		"  # that points at another file share",
+		"  if EXISTING_SHARE=$(az containerapp env storage show --name \"$ENV_NAME\" --resource-group \"$RESOURCE_GROUP\" --storage-name prometheus-pv --query \"join('/', [properties.azureFile.accountName, properties.azureFile.shareName])\" --output tsv 2>/dev/null); then",
+		"    if [ \"$EXISTING_SHARE\" != "+shellQuote(config.StorageAccountName+"/prometheus-data")+" ]; then",
+		"      echo \"Environment storage prometheus-pv already uses file share $EXISTING_SHARE; remove it or use that storage account\" >&2",
+		"      exit 1",
+		"    fi",
+		"  fi",
+		fmt.Sprintf("  az containerapp env storage set --name \"$ENV_NAME\" --resource-group \"$RESOURCE_GROUP\" --storage-name prometheus-pv --azure-file-account-name %s --azure-file-account-key \"%s\" --azure-file-share-name prometheus-data --access-mode ReadWrite --output none", storageAccountName, storageAccountKey.scriptValue(config)),
 		"fi",
 		"",
 		"# Deploy in order to ensure proper dependencies",
 		"",
 		"echo \"Deploying Transform Agent...\"",
-		fmt.Sprintf("az containerapp create --name bindplane-transform-agent --resource-group %s --yaml \"$OUTPUT_DIR/transform-agent.yaml\"", config.ResourceGroup),
+		"deploy_app bindplane-transform-agent \"$OUTPUT_DIR/transform-agent.yaml\"",
+		"wait_healthy bindplane-transform-agent",
 		"",
 		"if [ -f \"$OUTPUT_DIR/prometheus.yaml\" ]; then",
 		"  echo \"Deploying Prometheus...\"",
-		fmt.Sprintf("  az containerapp create --name bindplane-prometheus --resource-group %s --yaml \"$OUTPUT_DIR/prometheus.yaml\"", config.ResourceGroup),
+		"  deploy_app bindplane-prometheus \"$OUTPUT_DIR/prometheus.yaml\"",
+		"  wait_healthy bindplane-prometheus",
 		"fi",
 		"",
 		"echo \"Deploying Jobs component...\"",
-		fmt.Sprintf("az containerapp create --name bindplane-jobs --resource-group %s --yaml \"$OUTPUT_DIR/jobs.yaml\"", config.ResourceGroup),
+		"deploy_app bindplane-jobs \"$OUTPUT_DIR/jobs.yaml\"",
+		"wait_healthy bindplane-jobs",
 		"",
 		"echo \"Deploying main Bindplane application...\"",
-		fmt.Sprintf("az containerapp create --name bindplane --resource-group %s --yaml \"$OUTPUT_DIR/bindplane.yaml\"", config.ResourceGroup),
+		"deploy_app bindplane \"$OUTPUT_DIR/bindplane.yaml\"",
+		"wait_healthy bindplane",
 		"",
 		"echo \"Deploying OTel Collector...\"",
-		fmt.Sprintf("az containerapp create --name otelcol --resource-group %s --yaml \"$OUTPUT_DIR/otelcol.yaml\"", config.ResourceGroup),
+		"deploy_app otelcol \"$OUTPUT_DIR/otelcol.yaml\"",
+		"wait_healthy otelcol",
 		"",
 		"echo \"Deployment complete!\"",
 		"",
 		"echo \"Skipping per-app RBAC: using user-assigned identity pre-granted at namespace scope.\"",
 		"echo \"Checking deployment status...\"",
-		fmt.Sprintf("az containerapp list --resource-group %s --query \"[].{Name:name,Status:properties.provisioningState}\" --output table", config.ResourceGroup),
-	}
-
-	file, err := os.Create(commandsFile)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: failed to create deployment script: %v\n", err)
-		return
-	}
-	defer file.Close()
-
-	for _, cmd := range commands {
-		fmt.Fprintln(file, cmd)
-	}
-
-	// Make script executable
-	if err := os.Chmod(commandsFile, 0755); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: failed to make deployment script executable: %v\n", err)
-	}
-
-	fmt.Printf("Deployment script generated: %s\n", commandsFile)
+		"az containerapp list --resource-group \"$RESOURCE_GROUP\" --query \"[].{Name:name,Status:properties.provisioningState}\" --output table",
+	)
 }
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"encoding/base64"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"text/template"
@@ -19,7 +21,8 @@ func TestTemplateProcessing(t *testing.T) {
 		PostgresDatabase:         "test_db",
 		License:                  "test-license-key",
 		PostgresPassword:         "test-password",
-		PostgresSSLMode:          "disabled",
+		PostgresSSLMode:          "disable",
+		PostgresPort:             5432,
 		Base64License:            base64.StdEncoding.EncodeToString([]byte("test-license-key")),
 		Base64PostgresPassword:   base64.StdEncoding.EncodeToString([]byte("test-password")),
 		Base64StorageAccountName: base64.StdEncoding.EncodeToString([]byte("teststorageaccount")),
@@ -35,6 +38,10 @@ func TestTemplateProcessing(t *testing.T) {
 		AzureNamespace:           "test-namespace",
 		ManagedIdentityID:        "test-managed-identity-id",
 		AzureClientID:            "test-client-id",
+
+		BindplanePostgresMaxConnections:     50,
+		BindplanePostgresMaxIdleConnections: 15,
+		JobsPostgresMaxConnections:          20,
 	}
 
 	templateFiles := []string{
@@ -47,23 +54,12 @@ func TestTemplateProcessing(t *testing.T) {
 
 	for _, filename := range templateFiles {
 		t.Run(filename, func(t *testing.T) {
-			// Read template
-			templatePath := filepath.Join("templates", filename)
-			templateContent, err := os.ReadFile(templatePath)
-			if err != nil {
-				t.Fatalf("Failed to read template %s: %v", templatePath, err)
-			}
-
-			// Parse and execute template
-			tmpl, err := template.New(filename).Parse(string(templateContent))
+			// Render the embedded template
+			rendered, err := renderTemplate(&Config{}, testData, filename)
 			if err != nil {
-				t.Fatalf("Failed to parse template %s: %v", filename, err)
-			}
-
-			var output bytes.Buffer
-			if err := tmpl.Execute(&output, testData); err != nil {
-				t.Fatalf("Failed to execute template %s: %v", filename, err)
+				t.Fatalf("Failed to render template %s: %v", filename, err)
 			}
+			output := bytes.NewBuffer(rendered)
 
 			// Read golden file
 			goldenPath := filepath.Join("testdata", filename)
@@ -89,6 +85,30 @@ func TestTemplateProcessing(t *testing.T) {
 	}
 }
 
+// validTestConfig returns a config that passes validateConfig, for tests to
+// change the fields under test
+func validTestConfig() *Config {
+	return &Config{
+		ACAEnvironmentID:      "/subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.App/managedEnvironments/test-env",
+		PostgresHost:          "test-host",
+		PostgresUsername:      "test-user",
+		PostgresDatabase:      "test-db",
+		License:               "test-license",
+		PostgresPassword:      "test-pass",
+		StorageAccountName:    "teststorage",
+		StorageAccountKey:     "test-key",
+		ResourceGroup:         "test-rg",
+		SessionSecret:         "test-session-secret",
+		AzureConnectionString: "test-connection-string",
+		AzureTopic:            "test-topic",
+		AzureSubscriptionID:   "test-subscription-id",
+		AzureResourceGroup:    "test-rg",
+		AzureNamespace:        "test-namespace",
+		ManagedIdentityID:     "/subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/test-uai",
+		AzureClientID:         "test-client-id",
+	}
+}
+
 func TestValidateConfig(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -97,26 +117,8 @@ func TestValidateConfig(t *testing.T) {
 		errorMsg  string
 	}{
 		{
-			name: "valid config",
-			config: &Config{
-				ACAEnvironmentID:      "test-env",
-				PostgresHost:          "test-host",
-				PostgresUsername:      "test-user",
-				PostgresDatabase:      "test-db",
-				License:               "test-license",
-				PostgresPassword:      "test-pass",
-				StorageAccountName:    "test-storage",
-				StorageAccountKey:     "test-key",
-				ResourceGroup:         "test-rg",
-				SessionSecret:         "test-session-secret",
-				AzureConnectionString: "test-connection-string",
-				AzureTopic:            "test-topic",
-				AzureSubscriptionID:   "test-subscription-id",
-				AzureResourceGroup:    "test-rg",
-				AzureNamespace:        "test-namespace",
-				ManagedIdentityID:     "test-managed-identity-id",
-				AzureClientID:         "test-client-id",
-			},
+			name:      "valid config",
+			config:    validTestConfig(),
 			wantError: false,
 		},
 		{
@@ -149,6 +151,29 @@ func TestValidateConfig(t *testing.T) {
 			wantError: true,
 			errorMsg:  "postgres-host",
 		},
+		{
+			name: "key vault references",
+			config: &Config{
+				ACAEnvironmentID:                 "/subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.App/managedEnvironments/test-env",
+				PostgresHost:                     "test-host",
+				PostgresUsername:                 "test-user",
+				PostgresDatabase:                 "test-db",
+				LicenseKeyVaultURI:               "https://test-vault.vault.azure.net/secrets/license",
+				PostgresPasswordKeyVaultURI:      "https://test-vault.vault.azure.net/secrets/postgres-password/0123456789abcdef",
+				StorageAccountName:               "teststorage",
+				StorageAccountKeyKeyVaultURI:     "https://test-vault.vault.azure.net/secrets/storage-account-key",
+				ResourceGroup:                    "test-rg",
+				SessionSecretKeyVaultURI:         "https://test-vault.vault.azure.net/secrets/session-secret",
+				AzureConnectionStringKeyVaultURI: "https://test-vault.vault.azure.net/secrets/azure-connection-string",
+				AzureTopic:                       "test-topic",
+				AzureSubscriptionID:              "test-subscription-id",
+				AzureResourceGroup:               "test-rg",
+				AzureNamespace:                   "test-namespace",
+				ManagedIdentityID:                "/subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/test-uai",
+				AzureClientID:                    "test-client-id",
+			},
+			wantError: false,
+		},
 		{
 			name: "missing multiple fields",
 			config: &Config{
@@ -178,6 +203,81 @@ func TestValidateConfig(t *testing.T) {
 	}
 }
 
+func TestValidateConfigKeyVault(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(*Config)
+		expected string
+	}{
+		{
+			name: "secret and key vault reference",
+			change: func(c *Config) {
+				c.LicenseKeyVaultURI = "https://test-vault.vault.azure.net/secrets/license"
+			},
+			expected: "only one of license (not set) and license-kv-uri (not set) may be set",
+		},
+		{
+			name: "invalid key vault uri",
+			change: func(c *Config) {
+				c.License = ""
+				c.LicenseKeyVaultURI = "http://test-vault/license"
+			},
+			expected: `invalid license-kv-uri "http://test-vault/license" (not set): must be a Key Vault secret URI such as https://my-vault.vault.azure.net/secrets/my-secret`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := validTestConfig()
+			tt.change(config)
+			err := validateConfig(config)
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("validateConfig() = %v, want %q", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidateConfigReportsAllViolations(t *testing.T) {
+	config := &Config{
+		ACAEnvironmentID:      "/subscriptions/other-subscription-id/resourceGroups/test-rg/providers/Microsoft.App/managedEnvironments/test-env",
+		PostgresHost:          "test-host",
+		PostgresUsername:      "test-user",
+		PostgresDatabase:      "test-db",
+		License:               "test-license",
+		PostgresPassword:      "test-pass",
+		StorageAccountName:    "Test-Storage",
+		StorageAccountKey:     "test-key",
+		ResourceGroup:         "test-rg",
+		SessionSecret:         "test-session-secret",
+		AzureConnectionString: "test-connection-string",
+		AzureTopic:            "-test-topic",
+		AzureSubscriptionID:   "test-subscription-id",
+		AzureResourceGroup:    "test-rg",
+		AzureNamespace:        "ns",
+		ManagedIdentityID:     "/subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.App/managedEnvironments/test-env",
+		AzureClientID:         "test-client-id",
+	}
+
+	err := validateConfig(config)
+	if err == nil {
+		t.Fatal("Expected error but got none")
+	}
+	for _, want := range []string{
+		"invalid aca-environment-id",
+		"subscription other-subscription-id doesn't match azure-subscription-id test-subscription-id",
+		"invalid managed-identity-id",
+		"expected a Microsoft.ManagedIdentity/userAssignedIdentities resource",
+		`invalid storage-account-name "Test-Storage"`,
+		`invalid azure-namespace "ns"`,
+		`invalid azure-topic "-test-topic"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got: %v", want, err)
+		}
+	}
+}
+
 func TestBase64Encoding(t *testing.T) {
 	testCases := []struct {
 		input    string
@@ -309,3 +409,102 @@ secrets:
 		}
 	}
 }
+
+func TestYAMLDeploymentCommandsIdempotent(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not available")
+	}
+
+	config := iacTestConfig(t)
+	if err := generateDeploymentCommands(config); err != nil {
+		t.Fatalf("Failed to generate deployment script: %v", err)
+	}
+	for _, filename := range templateFiles(config) {
+		if err := os.WriteFile(filepath.Join(config.OutputDir, filename), []byte("name: test\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", filename, err)
+		}
+	}
+
+	bin := writeFakeAZ(t)
+
+	deploy := func(existing, storage string) ([]string, string, error) {
+		t.Helper()
+		log := filepath.Join(t.TempDir(), "az.log")
+		cmd := exec.Command(bash, filepath.Join(config.OutputDir, deployScript))
+		cmd.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"), "AZ_LOG="+log, "AZ_EXISTING="+existing, "AZ_STORAGE="+storage)
+		out, err := cmd.CombinedOutput()
+		calls, _ := os.ReadFile(log)
+		var deploys []string
+		for _, call := range strings.Split(string(calls), "\n") {
+			if fields := strings.Fields(call); len(fields) > 3 && (fields[1] == "create" || fields[1] == "update" || fields[3] == "set") {
+				deploys = append(deploys, strings.Join(fields[:4], " "))
+			}
+		}
+		return deploys, string(out), err
+	}
+
+	// A first deploy creates everything, a rerun updates the existing apps
+	deploys, out, err := deploy("", "")
+	if err != nil {
+		t.Fatalf("deploy.sh failed: %v\n%s", err, out)
+	}
+	expected := []string{
+		"containerapp env storage set",
+		"containerapp create --name bindplane-transform-agent",
+		"containerapp create --name bindplane-prometheus",
+		"containerapp create --name bindplane-jobs",
+		"containerapp create --name bindplane",
+		"containerapp create --name otelcol",
+	}
+	if !reflect.DeepEqual(deploys, expected) {
+		t.Errorf("First deploy ran %q, want %q", deploys, expected)
+	}
+
+	deploys, out, err = deploy("bindplane-jobs bindplane", "teststorageaccount/prometheus-data")
+	if err != nil {
+		t.Fatalf("deploy.sh failed: %v\n%s", err, out)
+	}
+	expected[3] = "containerapp update --name bindplane-jobs"
+	expected[4] = "containerapp update --name bindplane"
+	if !reflect.DeepEqual(deploys, expected) {
+		t.Errorf("Rerun ran %q, want %q", deploys, expected)
+	}
+
+	// Storage pointing at another share is an error rather than ignored
+	deploys, out, err = deploy("", "otheraccount/other-share")
+	if err == nil {
+		t.Fatalf("Expected deploy.sh to fail for a different file share, got:\n%s", out)
+	}
+	if !strings.Contains(out, "already uses file share otheraccount/other-share") || len(deploys) != 0 {
+		t.Errorf("Unexpected result: ran %q\n%s", deploys, out)
+	}
+}
+
+// writeFakeAZ writes an az command that logs its arguments to AZ_LOG and
+// returns the directory holding it. It knows the container apps listed in
+// AZ_EXISTING, the environment storage share in AZ_STORAGE and whether the
+// Prometheus file share exists from AZ_SHARE. Every revision is healthy with
+// one replica, except those of the apps listed in AZ_FAILED.
+func writeFakeAZ(t *testing.T) string {
+	t.Helper()
+	bin := t.TempDir()
+	fakeAZ := `#!/bin/bash
+echo "$*" >> "$AZ_LOG"
+case "$1 $2" in
+  "containerapp show")
+    if [[ " $AZ_UNREADABLE " == *" $4 "* ]]; then echo "ERROR: $4 could not be read" >&2; exit 1; fi
+    if [[ " $AZ_EMPTY " == *" $4 "* ]]; then exit 0; fi
+    if [[ "$*" == *--query* ]]; then echo "$4--rev1 1"; else [[ " $AZ_EXISTING " == *" $4 "* ]]; fi ;;
+  "containerapp revision")
+    if [[ " $AZ_FAILED " == *" $5 "* ]]; then echo "Unhealthy Failed 0"; else echo "Healthy Running 1"; fi ;;
+  "containerapp logs") echo "log line from $5" ;;
+  "containerapp env") [ "$3 $4" != "storage show" ] || { [ -n "$AZ_STORAGE" ] && echo "$AZ_STORAGE"; } ;;
+  "storage share-rm") [ "$3" != exists ] || { [ -n "$AZ_SHARE" ] && echo true || echo false; } ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(bin, "az"), []byte(fakeAZ), 0755); err != nil {
+		t.Fatalf("Failed to write fake az: %v", err)
+	}
+	return bin
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// postgresSSLModes lists the libpq sslmode values Bindplane accepts, see
+// https://www.postgresql.org/docs/current/libpq-ssl.html#LIBPQ-SSL-PROTECTION
+var postgresSSLModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// azurePostgresHostSuffix is the domain of Azure Database for PostgreSQL
+// servers, which require SSL by default
+const azurePostgresHostSuffix = ".postgres.database.azure.com"
+
+// checkPostgresSSLMode checks that mode is a libpq sslmode
+func checkPostgresSSLMode(mode string) error {
+	if !slices.Contains(postgresSSLModes, mode) {
+		return fmt.Errorf("must be one of %s", strings.Join(postgresSSLModes, ", "))
+	}
+	return nil
+}
+
+// checkPostgresPort checks that port is a TCP port. Zero leaves the port
+// unset so Bindplane's default of 5432 applies.
+func checkPostgresPort(port int) error {
+	if port < 0 || port > 65535 {
+		return errors.New("must be between 1 and 65535, or 0 for the Bindplane default")
+	}
+	return nil
+}
+
+// checkPostgresConnections checks a component's connection pool settings.
+// Zero leaves a setting unset so the Bindplane default applies.
+func checkPostgresConnections(maxConnections, maxIdleConnections int) error {
+	switch {
+	case maxConnections < 0:
+		return fmt.Errorf("max connections %d must not be negative", maxConnections)
+	case maxIdleConnections < 0:
+		return fmt.Errorf("max idle connections %d must not be negative", maxIdleConnections)
+	case maxConnections > 0 && maxIdleConnections > maxConnections:
+		return fmt.Errorf("max idle connections %d is greater than max connections %d", maxIdleConnections, maxConnections)
+	}
+	return nil
+}
+
+// readPostgresCACert reads the PEM encoded CA certificate bundle at path,
+// checking that it holds at least one certificate
+func readPostgresCACert(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	certificates := 0
+	for rest := content; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return "", fmt.Errorf("unexpected PEM block %q, expected CERTIFICATE", block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return "", fmt.Errorf("invalid certificate: %w", err)
+		}
+		certificates++
+	}
+	if certificates == 0 {
+		return "", errors.New("no PEM encoded certificates found")
+	}
+
+	return string(content), nil
+}
+
+// postgresWarnings returns warnings about PostgreSQL settings that are valid
+// but probably a mistake
+func postgresWarnings(config *Config) []string {
+	var warnings []string
+	host := strings.ToLower(strings.TrimSuffix(config.PostgresHost, "."))
+	if strings.HasSuffix(host, azurePostgresHostSuffix) && config.PostgresSSLMode == "disable" {
+		warnings = append(warnings, fmt.Sprintf(
+			"postgres-ssl-mode is disable (%s) but %s is an Azure Database for PostgreSQL server, which requires SSL unless require_secure_transport is turned off; use require or verify-full",
+			config.sourceOf("postgres-ssl-mode"), config.PostgresHost))
+	}
+	return warnings
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// requiredMarker is the comment templates put after a value that must not
+// render empty, e.g. value: {{.PostgresHost}} # required
+const requiredMarker = "# required"
+
+// requiredCommentPattern matches a required marker annotated with the
+// template line it came from
+var requiredCommentPattern = regexp.MustCompile(`# required:(\d+)`)
+
+// requiredSuffixPattern matches an annotated required marker at the end of a
+// rendered line
+var requiredSuffixPattern = regexp.MustCompile(`[ \t]*# required:\d+[ \t\r]*$`)
+
+// templateFieldPattern matches the TemplateData fields referenced by actions
+// such as {{.PostgresHost}}, {{if .PostgresHost}} or {{yamlQuote .PostgresHost}}
+var templateFieldPattern = regexp.MustCompile(`\{\{-?\s*(?:[A-Za-z]+\s+)?\.([A-Za-z0-9_]+)`)
+
+// annotateRequired appends the template line number to every required marker
+// so that rendered values can be traced back to the line that produced them
+func annotateRequired(template string) string {
+	lines := strings.Split(template, "\n")
+	for i, line := range lines {
+		if strings.HasSuffix(strings.TrimRight(line, " \t\r"), requiredMarker) {
+			lines[i] = strings.TrimRight(line, " \t\r") + ":" + strconv.Itoa(i+1)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkRequiredValues fails for every value marked required in the template
+// that rendered empty, reporting the template file, line and field. It
+// returns content with the annotated markers removed. Only the line comments
+// YAML finds are removed, so values that contain the marker text are kept.
+func checkRequiredValues(source string, template, content []byte) ([]byte, error) {
+	if !requiredCommentPattern.Match(content) {
+		return content, nil
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse rendered template %s: %w", source, err)
+	}
+
+	templateLines := strings.Split(string(template), "\n")
+	// marked holds the rendered lines that end with a required marker
+	marked := make(map[int]bool)
+	var errs []error
+	var walk func(node *yaml.Node, path string)
+	walk = func(node *yaml.Node, path string) {
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				walk(child, path)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				childPath := path + "." + key.Value
+				// An empty value's comment is attached to its key
+				for _, node := range []*yaml.Node{key, value} {
+					match := requiredCommentPattern.FindStringSubmatch(node.LineComment)
+					if match == nil {
+						continue
+					}
+					marked[node.Line] = true
+					if value.Kind != yaml.ScalarNode || value.Value != "" {
+						continue
+					}
+					line, _ := strconv.Atoi(match[1])
+					errs = append(errs, fmt.Errorf("%s:%d: required field %s rendered an empty value at %s",
+						source, line, templateFields(templateLines, line), strings.TrimPrefix(childPath, ".")))
+				}
+				walk(value, childPath)
+			}
+		case yaml.SequenceNode:
+			for i, child := range node.Content {
+				walk(child, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	}
+	walk(&root, "")
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(content), "\n")
+	for line := range marked {
+		if line >= 1 && line <= len(lines) {
+			lines[line-1] = requiredSuffixPattern.ReplaceAllString(lines[line-1], "")
+		}
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// templateFields returns the TemplateData fields referenced on the given
+// template line
+func templateFields(lines []string, line int) string {
+	if line < 1 || line > len(lines) {
+		return "(unknown)"
+	}
+	var fields []string
+	for _, match := range templateFieldPattern.FindAllStringSubmatch(lines[line-1], -1) {
+		fields = append(fields, match[1])
+	}
+	if len(fields) == 0 {
+		return "(unknown)"
+	}
+	return strings.Join(fields, ", ")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestAnnotateRequired(t *testing.T) {
+	template := "name: test\nvalue: {{.PostgresHost}} # required\nother: x # not required\n"
+	expected := "name: test\nvalue: {{.PostgresHost}} # required:2\nother: x # not required\n"
+	if got := annotateRequired(template); got != expected {
+		t.Errorf("Unexpected annotated template:\n%s", got)
+	}
+}
+
+func TestRenderTemplateRequiredValues(t *testing.T) {
+	data := iacTestData()
+	data.PostgresHost = ""
+	data.AzureTopic = ""
+
+	_, err := renderTemplate(&Config{}, data, "bindplane.yaml")
+	if err == nil {
+		t.Fatal("Expected error but got none")
+	}
+	for _, want := range []string{
+		"templates/bindplane.yaml:108: required field PostgresHost rendered an empty value at properties.template.containers[0].env[",
+		"templates/bindplane.yaml:130: required field AzureTopic rendered an empty value at properties.template.containers[0].env[",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestRenderTemplateStripsRequiredMarkers(t *testing.T) {
+	content, err := renderTemplate(&Config{}, iacTestData(), "bindplane.yaml")
+	if err != nil {
+		t.Fatalf("Failed to render template: %v", err)
+	}
+	if strings.Contains(string(content), requiredMarker) {
+		t.Errorf("Expected required markers to be removed, got:\n%s", content)
+	}
+}
+
+func TestRenderTemplateKeepsMarkerTextInValues(t *testing.T) {
+	data := iacTestData()
+	data.SessionSecret = "secret # required:1"
+	data.PostgresPassword = "# required:25"
+
+	content, err := renderTemplate(&Config{}, data, "bindplane.yaml")
+	if err != nil {
+		t.Fatalf("Failed to render template: %v", err)
+	}
+	var app struct {
+		Properties struct {
+			Configuration struct {
+				Secrets []struct {
+					Name  string `yaml:"name"`
+					Value string `yaml:"value"`
+				} `yaml:"secrets"`
+			} `yaml:"configuration"`
+		} `yaml:"properties"`
+	}
+	if err := yaml.Unmarshal(content, &app); err != nil {
+		t.Fatalf("Failed to parse rendered template: %v\n%s", err, content)
+	}
+	values := make(map[string]string)
+	for _, secret := range app.Properties.Configuration.Secrets {
+		values[secret.Name] = secret.Value
+	}
+	for name, want := range map[string]string{"session-secret": data.SessionSecret, "postgres-password": data.PostgresPassword} {
+		if values[name] != want {
+			t.Errorf("Secret %s = %q, want %q", name, values[name], want)
+		}
+	}
+	if strings.Count(string(content), "# required") != 2 {
+		t.Errorf("Expected only the marker text in the values to be left, got:\n%s", content)
+	}
+}
+
+func TestRenderTemplateOverrideErrors(t *testing.T) {
+	testCases := map[string]struct {
+		template string
+		want     string
+	}{
+		"unknown field": {
+			template: "name: test\nvalue: {{.PostgresHots}}\n",
+			want:     "can't evaluate field PostgresHots",
+		},
+		"empty required value": {
+			template: "name: test\nproperties:\n  value: \"{{.PostgresHost}}\" # required\n",
+			want:     "bindplane.yaml:3: required field PostgresHost rendered an empty value at properties.value",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "bindplane.yaml"), []byte(tc.template), 0644); err != nil {
+				t.Fatalf("Failed to write override: %v", err)
+			}
+
+			_, err := renderTemplate(&Config{TemplatesDir: dir}, &TemplateData{}, "bindplane.yaml")
+			if err == nil {
+				t.Fatal("Expected error but got none")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Expected error to contain %q, got: %v", tc.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Active revisions modes of the bindplane app, set with -bindplane-revisions-mode
+const (
+	revisionsModeSingle   = "single"
+	revisionsModeMultiple = "multiple"
+)
+
+// revisionsModes lists the valid -bindplane-revisions-mode values
+var revisionsModes = []string{revisionsModeSingle, revisionsModeMultiple}
+
+// Defaults of the rollout flags
+const (
+	defaultRolloutSteps    = "10,50,100"
+	defaultRolloutInterval = 5 * time.Minute
+	defaultRolloutLabel    = "canary"
+)
+
+// revisionNamePattern matches revision suffixes and traffic labels: lowercase
+// letters, digits and single dashes, starting with a letter
+var revisionNamePattern = regexp.MustCompile(`^[a-z][a-z0-9]*(-[a-z0-9]+)*$`)
+
+// suffixSeparators matches the runs of characters of a tag that can't be in a
+// revision suffix
+var suffixSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+
+// checkRevisionsMode checks the active revisions mode of the bindplane app
+func checkRevisionsMode(mode string) error {
+	if !slices.Contains(revisionsModes, mode) {
+		return fmt.Errorf("must be one of %s", strings.Join(revisionsModes, ", "))
+	}
+	return nil
+}
+
+// rolloutOptions control how rollout shifts traffic to a new revision
+type rolloutOptions struct {
+	// steps are the increasing percentages of traffic sent to the new
+	// revision, ending with 100
+	steps []int
+	// interval is how long each step is held, checking the new revision's
+	// health, before the next
+	interval time.Duration
+	// label is the traffic label of the new revision, which gets its own URL
+	label string
+	// suffix names the new revision <app>--<suffix>
+	suffix string
+}
+
+// newRolloutOptions checks the rollout flags. An empty suffix defaults to one
+// made of the Bindplane tag and the time.
+func newRolloutOptions(steps string, interval time.Duration, label, suffix, tag string, now time.Time) (*rolloutOptions, error) {
+	o := &rolloutOptions{interval: interval, label: label, suffix: suffix}
+	for _, step := range strings.Split(steps, ",") {
+		weight, err := strconv.Atoi(strings.TrimSpace(step))
+		if err != nil || weight < 1 || weight > 100 {
+			return nil, fmt.Errorf("invalid steps %q: %q is not a percentage from 1 to 100", steps, step)
+		}
+		if len(o.steps) > 0 && weight <= o.steps[len(o.steps)-1] {
+			return nil, fmt.Errorf("invalid steps %q: the percentages must increase", steps)
+		}
+		o.steps = append(o.steps, weight)
+	}
+	if o.steps[len(o.steps)-1] != 100 {
+		return nil, fmt.Errorf("invalid steps %q: the last step must be 100", steps)
+	}
+	if interval < 0 {
+		return nil, fmt.Errorf("invalid step-interval %s: must not be negative", interval)
+	}
+	if !revisionNamePattern.MatchString(label) {
+		return nil, fmt.Errorf("invalid label %q: must be lowercase letters, digits and single dashes, starting with a letter", label)
+	}
+
+	if o.suffix == "" {
+		tag := strings.Trim(suffixSeparators.ReplaceAllString(strings.ToLower(tag), "-"), "-")
+		o.suffix = strings.TrimRight("v"+tag[:min(len(tag), 20)], "-") + "-" + now.UTC().Format("0102150405")
+	}
+	if !revisionNamePattern.MatchString(o.suffix) {
+		return nil, fmt.Errorf("invalid revision-suffix %q: must be lowercase letters, digits and single dashes, starting with a letter", o.suffix)
+	}
+	return o, nil
+}
+
+// trafficWeight is an entry of a container app's ingress traffic
+type trafficWeight struct {
+	revision string
+	// latest sends the weight to the latest revision instead of a named one
+	latest bool
+	weight int
+	label  string
+}
+
+// rolloutAppStatus is the part of a container app rollout reads to find the
+// revision serving traffic
+type rolloutAppStatus struct {
+	Properties struct {
+		LatestReadyRevisionName string `json:"latestReadyRevisionName"`
+		Configuration           struct {
+			Ingress struct {
+				Traffic []struct {
+					RevisionName   string `json:"revisionName"`
+					LatestRevision bool   `json:"latestRevision"`
+					Weight         int    `json:"weight"`
+				} `json:"traffic"`
+			} `json:"ingress"`
+		} `json:"configuration"`
+	} `json:"properties"`
+}
+
+// stableRevision returns the revision serving the most traffic
+func (s *rolloutAppStatus) stableRevision() string {
+	stable, weight := s.Properties.LatestReadyRevisionName, -1
+	for _, traffic := range s.Properties.Configuration.Ingress.Traffic {
+		if traffic.Weight > weight {
+			stable, weight = traffic.RevisionName, traffic.Weight
+			if traffic.LatestRevision {
+				stable = s.Properties.LatestReadyRevisionName
+			}
+		}
+	}
+	return stable
+}
+
+// rollout deploys the component, rendered with its secrets, as a new labeled
+// revision next to the one serving traffic, then shifts traffic to it in
+// steps, checking its health at each. When every step passes the new revision
+// is promoted and the old one deactivated; when one fails all traffic goes
+// back to the old revision and the new one is deactivated. The promoted
+// revision is the latest one, so its traffic follows the latest revision
+// and the revisions later deploys create receive it.
+func (d *armDeployer) rollout(ctx context.Context, component *iacComponent, o *rolloutOptions) error {
+	configuration := mappingValue(component.field("properties"), "configuration")
+	if mode := mappingValue(configuration, "activeRevisionsMode"); mode == nil || mode.Value != "Multiple" {
+		return fmt.Errorf("%s must set activeRevisionsMode: Multiple for a rollout; set bindplane-revisions-mode to multiple", component.file)
+	}
+	ingress := mappingValue(configuration, "ingress")
+	if ingress == nil {
+		return fmt.Errorf("%s has no ingress to shift traffic with", component.file)
+	}
+	id, err := containerAppID(d.config, component.name)
+	if err != nil {
+		return err
+	}
+
+	var app rolloutAppStatus
+	if err := d.client.get(ctx, id, containerAppsAPIVersion, &app); err != nil {
+		return fmt.Errorf("failed to read container app %s, which must be deployed before a rollout: %w", component.name, err)
+	}
+	stable := app.stableRevision()
+	if stable == "" {
+		return fmt.Errorf("%s has no ready revision to roll out from", component.name)
+	}
+	revision := component.name + "--" + o.suffix
+	if revision == stable {
+		return fmt.Errorf("revision %s already serves %s; choose another revision-suffix", revision, component.name)
+	}
+
+	template := mappingValue(component.field("properties"), "template")
+	setMappingValue(template, "revisionSuffix", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: o.suffix})
+	put := func(traffic ...trafficWeight) error {
+		setMappingValue(ingress, "traffic", trafficNode(traffic))
+		if err := d.client.put(ctx, id, containerAppsAPIVersion, containerAppBody(component)); err != nil {
+			return fmt.Errorf("failed to set the traffic of %s: %w", component.name, err)
+		}
+		return nil
+	}
+
+	fmt.Fprintf(d.out, "Deploying %s as revision %s, labeled %s, next to %s...\n", component.name, revision, o.label, stable)
+	if err := put(trafficWeight{revision: stable, weight: 100}, trafficWeight{revision: revision, label: o.label}); err != nil {
+		return err
+	}
+	if err := d.waitRevisionHealthy(ctx, component.name, id, revision); err != nil {
+		return d.abortRollout(ctx, id, stable, revision, put, err)
+	}
+
+	for _, weight := range o.steps {
+		fmt.Fprintf(d.out, "Shifting %d%% of %s traffic to %s...\n", weight, component.name, revision)
+		if err := put(trafficWeight{revision: stable, weight: 100 - weight}, trafficWeight{revision: revision, weight: weight, label: o.label}); err != nil {
+			return d.abortRollout(ctx, id, stable, revision, put, err)
+		}
+		if err := d.holdHealthy(ctx, component.name, id, revision, o.interval); err != nil {
+			return d.abortRollout(ctx, id, stable, revision, put, err)
+		}
+	}
+
+	fmt.Fprintf(d.out, "Promoting %s and deactivating %s...\n", revision, stable)
+	if err := put(trafficWeight{latest: true, weight: 100, label: o.label}); err != nil {
+		return err
+	}
+	if err := d.deactivateRevision(ctx, id, stable); err != nil {
+		return err
+	}
+	fmt.Fprintf(d.out, "Rollout complete: %s serves all traffic of %s\n", revision, component.name)
+	return nil
+}
+
+// holdHealthy checks the health of the revision for interval, at least once,
+// failing as soon as it is unhealthy
+func (d *armDeployer) holdHealthy(ctx context.Context, name, id, revision string, interval time.Duration) error {
+	deadline := time.Now().Add(interval)
+	for {
+		h, err := d.readRevisionHealth(ctx, name, id, revision)
+		if err != nil {
+			return err
+		}
+		if !h.healthy() {
+			return fmt.Errorf("%s became unhealthy: %s", name, d.unhealthyRevision(name, h))
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(min(d.healthPoll, remaining)):
+		}
+	}
+}
+
+// abortRollout sends all traffic back to the stable revision and deactivates
+// the new one after the rollout failed with cause
+func (d *armDeployer) abortRollout(ctx context.Context, id, stable, revision string, put func(...trafficWeight) error, cause error) error {
+	fmt.Fprintf(d.out, "Rollout failed, sending all traffic back to %s...\n", stable)
+	if err := put(trafficWeight{revision: stable, weight: 100}); err != nil {
+		return fmt.Errorf("%w; sending all traffic back to %s also failed: %w", cause, stable, err)
+	}
+	if err := d.deactivateRevision(ctx, id, revision); err != nil {
+		return fmt.Errorf("%w; all traffic is back on %s, but %w", cause, stable, err)
+	}
+	return fmt.Errorf("rollout of %s aborted, all traffic is back on %s: %w", revision, stable, cause)
+}
+
+// deactivateRevision deactivates a revision of the app, stopping its replicas
+func (d *armDeployer) deactivateRevision(ctx context.Context, id, revision string) error {
+	if err := d.client.post(ctx, id+"/revisions/"+revision+"/deactivate", containerAppsAPIVersion); err != nil {
+		return fmt.Errorf("failed to deactivate revision %s: %w", revision, err)
+	}
+	return nil
+}
+
+// trafficNode returns the ingress traffic of the weights
+func trafficNode(weights []trafficWeight) *yaml.Node {
+	scalar := func(tag, value string) *yaml.Node {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+	}
+	traffic := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for _, w := range weights {
+		item := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{
+			scalar("!!str", "revisionName"), scalar("!!str", w.revision),
+		}}
+		if w.latest {
+			item.Content = []*yaml.Node{scalar("!!str", "latestRevision"), scalar("!!bool", "true")}
+		}
+		item.Content = append(item.Content, scalar("!!str", "weight"), scalar("!!int", strconv.Itoa(w.weight)))
+		if w.label != "" {
+			item.Content = append(item.Content, scalar("!!str", "label"), scalar("!!str", w.label))
+		}
+		traffic.Content = append(traffic.Content, item)
+	}
+	return traffic
+}
+
+// setMappingValue sets key of a mapping to value, adding the key if it is
+// missing
+func setMappingValue(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = value
+			return
+		}
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
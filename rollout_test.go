@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewRolloutOptions(t *testing.T) {
+	now := time.Date(2026, 10, 16, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name     string
+		steps    string
+		label    string
+		suffix   string
+		tag      string
+		expected *rolloutOptions
+		errorMsg string
+	}{
+		{name: "defaults", steps: defaultRolloutSteps, label: defaultRolloutLabel, tag: "1.95.0",
+			expected: &rolloutOptions{steps: []int{10, 50, 100}, label: "canary", suffix: "v1-95-0-1016150405"}},
+		{name: "blue/green", steps: "100", label: "green", suffix: "green", tag: "1.95.0",
+			expected: &rolloutOptions{steps: []int{100}, label: "green", suffix: "green"}},
+		{name: "long tag", steps: "100", label: "canary", tag: "1.97.0-SNAPSHOT-e0838d114",
+			expected: &rolloutOptions{steps: []int{100}, label: "canary", suffix: "v1-97-0-snapshot-e083-1016150405"}},
+		{name: "decreasing", steps: "50,10,100", label: "canary", errorMsg: `invalid steps "50,10,100": the percentages must increase`},
+		{name: "not ending at 100", steps: "10,50", label: "canary", errorMsg: `invalid steps "10,50": the last step must be 100`},
+		{name: "zero", steps: "0,100", label: "canary", errorMsg: `invalid steps "0,100": "0" is not a percentage from 1 to 100`},
+		{name: "label", steps: "100", label: "Canary", errorMsg: `invalid label "Canary"`},
+		{name: "suffix", steps: "100", label: "canary", suffix: "v1.95", errorMsg: `invalid revision-suffix "v1.95"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := newRolloutOptions(tt.steps, 0, tt.label, tt.suffix, tt.tag, now)
+			if tt.errorMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("Expected error containing %q, got: %v", tt.errorMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newRolloutOptions failed: %v", err)
+			}
+			if !reflect.DeepEqual(o, tt.expected) {
+				t.Errorf("newRolloutOptions = %+v, want %+v", o, tt.expected)
+			}
+		})
+	}
+}
+
+// rolloutTestComponent renders the bindplane app of the test config
+func rolloutTestComponent(t *testing.T, config *Config, multipleRevisions bool) *iacComponent {
+	t.Helper()
+	data := iacTestData()
+	data.BindplaneMultipleRevisions = multipleRevisions
+	components, err := renderComponentsWith(config, data)
+	if err != nil {
+		t.Fatalf("renderComponentsWith failed: %v", err)
+	}
+	return components[slices.IndexFunc(components, func(c *iacComponent) bool { return c.name == "bindplane" })]
+}
+
+// rolloutRequests returns the PUTs and POSTs sent to the mock
+func rolloutRequests(arm *mockARM) []string {
+	var requests []string
+	for _, request := range arm.requests {
+		if !strings.HasPrefix(request, "GET ") {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// rolloutTraffic returns the traffic of the last PUT of the bindplane app
+func rolloutTraffic(arm *mockARM, id string) []any {
+	return arm.bodies[id]["properties"].(map[string]any)["configuration"].(map[string]any)["ingress"].(map[string]any)["traffic"].([]any)
+}
+
+func TestARMRollout(t *testing.T) {
+	arm := newMockARM(t)
+	deployer, out := armTestDeployer(t, arm)
+	id := "/subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.App/containerApps/bindplane"
+	arm.bodies[id] = map[string]any{}
+
+	err := deployer.rollout(context.Background(), rolloutTestComponent(t, deployer.config, false), &rolloutOptions{steps: []int{100}, label: "canary", suffix: "v2"})
+	if err == nil || !strings.Contains(err.Error(), "bindplane.yaml must set activeRevisionsMode: Multiple") {
+		t.Errorf("Expected a single revision app to be refused, got: %v", err)
+	}
+
+	arm.requests = nil
+	options := &rolloutOptions{steps: []int{50, 100}, label: "canary", suffix: "v2"}
+	if err := deployer.rollout(context.Background(), rolloutTestComponent(t, deployer.config, true), options); err != nil {
+		t.Fatalf("rollout failed: %v\n%s", err, out)
+	}
+	expected := []string{"PUT " + id, "PUT " + id, "PUT " + id, "PUT " + id, "POST " + id + "/revisions/bindplane--rev1/deactivate"}
+	if requests := rolloutRequests(arm); !reflect.DeepEqual(requests, expected) {
+		t.Errorf("rollout sent %q, want %q", requests, expected)
+	}
+	for _, want := range []string{
+		"Deploying bindplane as revision bindplane--v2, labeled canary, next to bindplane--rev1...\n",
+		"Shifting 50% of bindplane traffic to bindplane--v2...\n  bindplane--v2: Healthy, Running, 1 of 1 replicas\n",
+		"Rollout complete: bindplane--v2 serves all traffic of bindplane\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected the output to contain %q, got:\n%s", want, out)
+		}
+	}
+	template := arm.bodies[id]["properties"].(map[string]any)["template"].(map[string]any)
+	if template["revisionSuffix"] != "v2" {
+		t.Errorf("Expected the revision suffix to be set, got %v", template["revisionSuffix"])
+	}
+	promoted := []any{map[string]any{"latestRevision": true, "weight": float64(100), "label": "canary"}}
+	if traffic := rolloutTraffic(arm, id); !reflect.DeepEqual(traffic, promoted) {
+		t.Errorf("Expected all traffic on the new revision, got %v", traffic)
+	}
+
+	// An unhealthy revision sends the traffic back
+	arm.requests = nil
+	arm.unhealthy["bindplane--v3"] = true
+	options.suffix = "v3"
+	err = deployer.rollout(context.Background(), rolloutTestComponent(t, deployer.config, true), options)
+	if err == nil || !strings.Contains(err.Error(), "rollout of bindplane--v3 aborted, all traffic is back on bindplane--rev1: bindplane did not become healthy within 1m0s: revision bindplane--v3 is Failed (container crashed)") {
+		t.Errorf("Expected the rollout to be aborted, got: %v", err)
+	}
+	expected = []string{"PUT " + id, "PUT " + id, "POST " + id + "/revisions/bindplane--v3/deactivate"}
+	if requests := rolloutRequests(arm); !reflect.DeepEqual(requests, expected) {
+		t.Errorf("aborted rollout sent %q, want %q", requests, expected)
+	}
+	stable := []any{map[string]any{"revisionName": "bindplane--rev1", "weight": float64(100)}}
+	if traffic := rolloutTraffic(arm, id); !reflect.DeepEqual(traffic, stable) {
+		t.Errorf("Expected all traffic back on the stable revision, got %v", traffic)
+	}
+}
+
+func TestRunRollout(t *testing.T) {
+	stubRunCommand(t)
+	arm := newMockARM(t)
+	for _, name := range []string{"AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET"} {
+		t.Setenv(name, "")
+	}
+
+	outputDir := filepath.Join(t.TempDir(), "out")
+	args := func(extra ...string) []string {
+		return append(append(cliTestArgs(outputDir),
+			"-health-timeout", "0",
+			"-arm-endpoint", arm.URL, "-arm-authority-host", arm.URL,
+			"-arm-tenant-id", "test-tenant", "-arm-client-id", "test-client", "-arm-client-secret", "test-secret",
+		), extra...)
+	}
+
+	if err := runRollout(args()); err == nil || !strings.Contains(err.Error(), "rollout needs bindplane-revisions-mode multiple, so deploy, apply and drift agree with the revisions it creates, but it is single (not set)") {
+		t.Errorf("Expected rollout to need multiple revisions, got: %v", err)
+	}
+	if err := runRollout(args("-bindplane-revisions-mode", "blue")); err == nil || !strings.Contains(err.Error(), `invalid bindplane-revisions-mode "blue" (flag -bindplane-revisions-mode): must be one of single, multiple`) {
+		t.Errorf("Expected an invalid revisions mode to be reported, got: %v", err)
+	}
+	if err := runApply(args("-bindplane-revisions-mode", "multiple")); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+
+	// Traffic only moves after a health check
+	err := runRollout(args("-bindplane-revisions-mode", "multiple", "-bindplane-tag", "1.95.0", "-health-timeout", "0"))
+	if err == nil || !strings.Contains(err.Error(), "rollout needs a health-timeout above 0 to wait for the new revision before shifting traffic to it, but it is 0s (flag -health-timeout)") {
+		t.Errorf("Expected rollout to refuse -health-timeout 0, got: %v", err)
+	}
+
+	arm.requests = nil
+	if err := runRollout(args("-bindplane-revisions-mode", "multiple", "-bindplane-tag", "1.95.0", "-health-timeout", "1m", "-steps", "100", "-step-interval", "0", "-revision-suffix", "green", "-label", "green")); err != nil {
+		t.Fatalf("rollout failed: %v", err)
+	}
+	if requests := rolloutRequests(arm); len(requests) != 4 || !strings.HasSuffix(requests[3], "/revisions/bindplane--rev1/deactivate") {
+		t.Errorf("Expected bindplane to be rolled out in one step, got %q", requests)
+	}
+
+	// The new revision runs the image of the new tag
+	id := "/subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.App/containerApps/bindplane"
+	container := arm.bodies[id]["properties"].(map[string]any)["template"].(map[string]any)["containers"].([]any)[0].(map[string]any)
+	if container["image"] != "ghcr.io/observiq/bindplane-ee:1.95.0" {
+		t.Errorf("Expected the rollout revision to run the 1.95.0 image, got %v", container["image"])
+	}
+	generations, err := listGenerations(&Config{OutputDir: outputDir})
+	if err != nil {
+		t.Fatalf("listGenerations failed: %v", err)
+	}
+	if latest := generations[len(generations)-1]; len(generations) != 2 || latest.Command != "rollout" || latest.Images["bindplane"] != "ghcr.io/observiq/bindplane-ee:1.95.0" {
+		t.Errorf("Expected the rollout to be recorded, got %+v", latest)
+	}
+
+	// The next apply creates a revision that receives the traffic
+	if err := runApply(args("-bindplane-revisions-mode", "multiple", "-bindplane-tag", "1.96.0")); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	container = arm.bodies[id]["properties"].(map[string]any)["template"].(map[string]any)["containers"].([]any)[0].(map[string]any)
+	if container["image"] != "ghcr.io/observiq/bindplane-ee:1.96.0" {
+		t.Errorf("Expected apply to deploy the 1.96.0 image, got %v", container["image"])
+	}
+	latest := []any{map[string]any{"latestRevision": true, "weight": float64(100), "label": "green"}}
+	if traffic := rolloutTraffic(arm, id); !reflect.DeepEqual(traffic, latest) {
+		t.Errorf("Expected the traffic to follow the latest revision after apply, got %v", traffic)
+	}
+}
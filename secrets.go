@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// secretMask is shown in place of secret values in dry run output
+const secretMask = "********"
+
+// maskSensitive returns a copy of data, a TemplateData or Config, with every
+// non-empty field tagged sensitive replaced by secretMask
+func maskSensitive[T TemplateData | Config](data *T) *T {
+	masked, _ := replaceSensitive(data, func(_, value string) string {
+		if value == "" {
+			return ""
+		}
+		return secretMask
+	})
+	return masked
+}
+
+// sensitivePattern matches the markers substituted for sensitive fields,
+// capturing the field name
+var sensitivePattern = regexp.MustCompile(`__bindplane_aca_sensitive_([A-Za-z0-9]+)__`)
+
+// sensitiveMarker returns the value rendered in place of the sensitive field
+// so that the places it is interpolated can be found again. The
+// infrastructure as code outputs and the deployment history turn the markers
+// of secret parameters into parameter references.
+func sensitiveMarker(field string) string {
+	return "__bindplane_aca_sensitive_" + field + "__"
+}
+
+// markSensitive returns a copy of data, a TemplateData or Config, with every
+// non-empty field tagged sensitive replaced by its marker, and the markers
+// mapped to the field names. Looking for the markers in output rendered from
+// the copy finds exactly where secrets are interpolated, where looking for the
+// values themselves also matches short or common secrets anywhere.
+func markSensitive[T TemplateData | Config](data *T) (*T, map[string]string) {
+	return replaceSensitive(data, func(field, value string) string {
+		if value == "" {
+			return ""
+		}
+		return sensitiveMarker(field)
+	})
+}
+
+// replaceSensitive returns a copy of data with every field tagged sensitive
+// set to the result of replace for the field name and value, and the changed
+// values mapped to the field names
+func replaceSensitive[T TemplateData | Config](data *T, replace func(field, value string) string) (*T, map[string]string) {
+	replaced := *data
+	replacements := make(map[string]string)
+	v := reflect.ValueOf(&replaced).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("sensitive") != "true" {
+			continue
+		}
+		if value := replace(t.Field(i).Name, v.Field(i).String()); value != v.Field(i).String() {
+			v.Field(i).SetString(value)
+			replacements[value] = t.Field(i).Name
+		}
+	}
+	return &replaced, replacements
+}
+
+// sensitiveValue returns the value of the named field of data, a TemplateData
+// or Config, and whether data has such a field tagged sensitive
+func sensitiveValue[T TemplateData | Config](data *T, field string) (string, bool) {
+	f, ok := reflect.TypeOf(data).Elem().FieldByName(field)
+	if !ok || f.Tag.Get("sensitive") != "true" {
+		return "", false
+	}
+	return reflect.ValueOf(data).Elem().FieldByIndex(f.Index).String(), true
+}
+
+// checkSecretPlacement parses a template rendered from data marked by
+// markSensitive and fails if any marker is rendered anywhere other than a
+// configuration.secrets value, such as a plain env value. markers maps each
+// marker to its field name.
+func checkSecretPlacement(filename string, content []byte, markers map[string]string) error {
+	if len(markers) == 0 {
+		return nil
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return fmt.Errorf("failed to parse rendered template %s: %w", filename, err)
+	}
+
+	var errs []error
+	var walk func(node *yaml.Node, path string)
+	walk = func(node *yaml.Node, path string) {
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				walk(child, path)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				walk(node.Content[i+1], path+"."+node.Content[i].Value)
+			}
+		case yaml.SequenceNode:
+			for i, child := range node.Content {
+				walk(child, fmt.Sprintf("%s[%d]", path, i))
+			}
+		case yaml.ScalarNode:
+			if isSecretValuePath(path) {
+				return
+			}
+			for marker, field := range markers {
+				if strings.Contains(node.Value, marker) {
+					errs = append(errs, fmt.Errorf("%s:%d: sensitive field %s is rendered as a plain value at %s; move it to configuration.secrets and use secretRef",
+						filename, node.Line, field, strings.TrimPrefix(path, ".")))
+				}
+			}
+		}
+	}
+	walk(&root, "")
+
+	return errors.Join(errs...)
+}
+
+// isSecretValuePath reports whether path is the value of a container app
+// secret, e.g. .properties.configuration.secrets[0].value
+func isSecretValuePath(path string) bool {
+	const prefix = ".properties.configuration.secrets["
+	return strings.HasPrefix(path, prefix) && strings.HasSuffix(path, "].value") &&
+		!strings.Contains(strings.TrimSuffix(strings.TrimPrefix(path, prefix), "].value"), ".")
+}
+
+// checkScriptSecrets fails if a script generated from a config marked by
+// markSensitive contains any marker. Scripts read secrets from the
+// environment or .env instead. markers maps each marker to its field name.
+func checkScriptSecrets(filename, script string, markers map[string]string) error {
+	var errs []error
+	for i, line := range strings.Split(script, "\n") {
+		for marker, field := range markers {
+			if strings.Contains(line, marker) {
+				errs = append(errs, fmt.Errorf("%s:%d: refusing to write sensitive field %s into the deploy script; it must be read from the environment or %s",
+					filename, i+1, field, envFile))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
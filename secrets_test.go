@@ -0,0 +1,249 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckSecretPlacement(t *testing.T) {
+	markers := map[string]string{sensitiveMarker("License"): "License"}
+
+	valid := `
+name: bindplane
+properties:
+  configuration:
+    secrets:
+      - name: license
+        value: __bindplane_aca_sensitive_License__
+  template:
+    containers:
+      - name: server
+        env:
+          - name: BINDPLANE_LICENSE
+            secretRef: license
+`
+	if err := checkSecretPlacement("bindplane.yaml", []byte(valid), markers); err != nil {
+		t.Errorf("Expected no error but got: %v", err)
+	}
+
+	invalid := `
+name: bindplane
+properties:
+  template:
+    containers:
+      - name: server
+        env:
+          - name: BINDPLANE_LICENSE
+            value: key=__bindplane_aca_sensitive_License__
+`
+	err := checkSecretPlacement("bindplane.yaml", []byte(invalid), markers)
+	if err == nil {
+		t.Fatal("Expected error but got none")
+	}
+	for _, want := range []string{"bindplane.yaml:9", "License", "properties.template.containers[0].env[0].value"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestMarkSensitive(t *testing.T) {
+	data := &TemplateData{PostgresHost: "test-host", License: "test-license"}
+
+	marked, markers := markSensitive(data)
+
+	if marked.License != sensitiveMarker("License") || marked.PostgresPassword != "" || marked.PostgresHost != "test-host" {
+		t.Errorf("Unexpected marked data: %+v", marked)
+	}
+	if expected := map[string]string{sensitiveMarker("License"): "License"}; !reflect.DeepEqual(markers, expected) {
+		t.Errorf("markers = %v, want %v", markers, expected)
+	}
+	if data.License != "test-license" {
+		t.Errorf("Expected the original to be unchanged, got: %q", data.License)
+	}
+}
+
+func TestTemplatesUseSecretRefs(t *testing.T) {
+	config := &Config{DeployPrometheus: true}
+	marked, markers := markSensitive(iacTestData())
+
+	for _, filename := range templateFiles(config) {
+		t.Run(filename, func(t *testing.T) {
+			content, err := renderTemplate(config, marked, filename)
+			if err != nil {
+				t.Fatalf("Failed to render template: %v", err)
+			}
+			if err := checkSecretPlacement(filename, content, markers); err != nil {
+				t.Errorf("Template renders secrets as plain values: %v", err)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateSecretPlacement(t *testing.T) {
+	// Secrets equal to names and values used elsewhere in the templates
+	config := &Config{DeployPrometheus: true}
+	data := iacTestData()
+	data.License, data.PostgresPassword, data.SessionSecret = "bindplane", "postgres", "1"
+	for _, filename := range templateFiles(config) {
+		if _, err := renderTemplate(config, data, filename); err != nil {
+			t.Errorf("Expected common secret values to render, got: %v", err)
+		}
+	}
+
+	dir := t.TempDir()
+	override := strings.Replace(validContainerApp, "value: {{.PostgresHost}}", `value: {{yamlQuote .License}}`, 1)
+	if err := os.WriteFile(filepath.Join(dir, "bindplane.yaml"), []byte(override), 0644); err != nil {
+		t.Fatalf("Failed to write override: %v", err)
+	}
+	_, err := renderTemplate(&Config{TemplatesDir: dir}, data, "bindplane.yaml")
+	want := filepath.Join(dir, "bindplane.yaml") + ":17: sensitive field License is rendered as a plain value at properties.template.containers[0].env[0].value"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Expected error containing %q, got: %v", want, err)
+	}
+}
+
+func TestKeyVaultReferences(t *testing.T) {
+	literalConfig, literalData := iacTestConfig(t), iacTestData()
+
+	config := iacTestConfig(t)
+	config.StorageAccountKey = ""
+	config.StorageAccountKeyKeyVaultURI = "https://test-vault.vault.azure.net/secrets/storage-account-key"
+	config.LicenseKeyVaultURI = "https://test-vault.vault.azure.net/secrets/license"
+	config.PostgresPasswordKeyVaultURI = "https://test-vault.vault.azure.net/secrets/postgres-password"
+	config.SessionSecretKeyVaultURI = "https://test-vault.vault.azure.net/secrets/session-secret"
+	config.AzureConnectionStringKeyVaultURI = "https://test-vault.vault.azure.net/secrets/azure-connection-string"
+
+	data := iacTestData()
+	data.License, data.Base64License = "", ""
+	data.PostgresPassword, data.Base64PostgresPassword = "", ""
+	data.SessionSecret, data.AzureConnectionString, data.Base64StorageAccountKey = "", "", ""
+	data.LicenseKeyVaultURI = config.LicenseKeyVaultURI
+	data.PostgresPasswordKeyVaultURI = config.PostgresPasswordKeyVaultURI
+	data.SessionSecretKeyVaultURI = config.SessionSecretKeyVaultURI
+	data.AzureConnectionStringKeyVaultURI = config.AzureConnectionStringKeyVaultURI
+
+	wantURIs := map[string]string{
+		"license":                 config.LicenseKeyVaultURI,
+		"postgres-password":       config.PostgresPasswordKeyVaultURI,
+		"session-secret":          config.SessionSecretKeyVaultURI,
+		"azure-connection-string": config.AzureConnectionStringKeyVaultURI,
+	}
+	for _, filename := range []string{"bindplane.yaml", "jobs.yaml"} {
+		t.Run(filename, func(t *testing.T) {
+			content, err := renderTemplate(config, data, filename)
+			if err != nil {
+				t.Fatalf("Failed to render template: %v", err)
+			}
+			app, err := parseContainerApp(filename, content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, secret := range app.Properties.Configuration.Secrets {
+				if secret.Value != "" {
+					t.Errorf("Secret %s has a literal value", secret.Name)
+				}
+				if secret.KeyVaultURL != wantURIs[secret.Name] {
+					t.Errorf("Secret %s has keyVaultUrl %q, want %q", secret.Name, secret.KeyVaultURL, wantURIs[secret.Name])
+				}
+				if secret.Identity != data.ManagedIdentityID {
+					t.Errorf("Secret %s has identity %q, want %q", secret.Name, secret.Identity, data.ManagedIdentityID)
+				}
+			}
+		})
+	}
+
+	bicep, err := renderBicep(config, data)
+	if err != nil {
+		t.Fatalf("Failed to render Bicep: %v", err)
+	}
+	if strings.Contains(string(bicep), "param license") {
+		t.Errorf("Expected no license parameter with a Key Vault reference, got:\n%s", bicep)
+	}
+
+	scripts := map[string][]string{
+		"yaml":      yamlDeploymentCommands(config),
+		"bicep":     deploymentGroupCommands(config, bicepFile, "Bicep"),
+		"terraform": terraformDeploymentCommands(config),
+	}
+	for format, commands := range scripts {
+		script := strings.Join(commands, "\n")
+		if !strings.Contains(script, "az keyvault secret show --id "+shellQuote(config.StorageAccountKeyKeyVaultURI)) {
+			t.Errorf("Expected %s deploy script to read the storage account key from Key Vault, got:\n%s", format, script)
+		}
+		for _, p := range secretParameters {
+			if p.templated() && strings.Contains(script, envName(p.flag)) {
+				t.Errorf("Expected %s deploy script not to require %s, got:\n%s", format, envName(p.flag), script)
+			}
+		}
+		assertNoSecrets(t, []byte(script), literalConfig, literalData)
+	}
+}
+
+func TestTemplatesQuoteSecrets(t *testing.T) {
+	config := iacTestConfig(t)
+	data := iacTestData()
+	data.License = `lic"ense\key`
+	data.PostgresPassword = `p@ss" # word\`
+	data.SessionSecret = `\"session\n`
+	data.AzureConnectionString = "Endpoint=sb://test/;SharedAccessKey=\"k\\e'y\x01\u00e9\U0001F600\""
+	data.PostgresCACert = "-----BEGIN CERTIFICATE-----\n  MIIB\tAA==\n-----END CERTIFICATE-----\n"
+	want := map[string]string{
+		"license":                 data.License,
+		"postgres-password":       data.PostgresPassword,
+		"session-secret":          data.SessionSecret,
+		"azure-connection-string": data.AzureConnectionString,
+		"postgres-ca-cert":        data.PostgresCACert,
+	}
+
+	for _, filename := range []string{"bindplane.yaml", "jobs.yaml"} {
+		t.Run(filename, func(t *testing.T) {
+			content, err := renderTemplate(config, data, filename)
+			if err != nil {
+				t.Fatalf("Failed to render template: %v", err)
+			}
+			app, err := parseContainerApp(filename, content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := 0
+			for _, secret := range app.Properties.Configuration.Secrets {
+				if value, ok := want[secret.Name]; ok {
+					found++
+					if secret.Value != value {
+						t.Errorf("Secret %s = %q, want %q", secret.Name, secret.Value, value)
+					}
+				}
+			}
+			if found != len(want) {
+				t.Errorf("Found %d of the %d secrets", found, len(want))
+			}
+		})
+	}
+}
+
+func TestMaskSensitive(t *testing.T) {
+	data := &TemplateData{
+		PostgresHost:     "test-host",
+		License:          "test-license",
+		PostgresPassword: "",
+	}
+
+	masked := maskSensitive(data)
+
+	if masked.License != secretMask {
+		t.Errorf("Expected License to be masked, got: %q", masked.License)
+	}
+	if masked.PostgresPassword != "" {
+		t.Errorf("Expected empty PostgresPassword to stay empty, got: %q", masked.PostgresPassword)
+	}
+	if masked.PostgresHost != "test-host" {
+		t.Errorf("Expected PostgresHost to be unchanged, got: %q", masked.PostgresHost)
+	}
+	if data.License != "test-license" {
+		t.Errorf("Expected the original to be unchanged, got: %q", data.License)
+	}
+}
@@ -0,0 +1,10 @@
+package main
+
+import "strings"
+
+// shellQuote returns s as a single quoted shell word. Every value from Config
+// interpolated into deploy.sh goes through shellQuote so that characters such
+// as $, backticks, quotes and whitespace are taken literally.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
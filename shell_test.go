@@ -0,0 +1,153 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+func TestShellQuote(t *testing.T) {
+	testCases := map[string]string{
+		"":           "''",
+		"plain":      "'plain'",
+		"it's":       `'it'\''s'`,
+		"$(rm -rf)`": "'$(rm -rf)`'",
+	}
+	for input, expected := range testCases {
+		if got := shellQuote(input); got != expected {
+			t.Errorf("shellQuote(%q) = %s, want %s", input, got, expected)
+		}
+	}
+}
+
+// deployScripts renders the deploy script for every output format
+func deployScripts(t *testing.T, config *Config) map[string]string {
+	t.Helper()
+	scripts := make(map[string]string)
+	for _, format := range outputFormats {
+		config.OutputFormat = format
+		script, err := renderDeploymentScript(config)
+		if err != nil {
+			t.Fatalf("Failed to render the %s deploy script: %v", format, err)
+		}
+		scripts[format] = string(script)
+	}
+	return scripts
+}
+
+// scriptShape parses script and returns its literal words and the number of
+// commands and substitutions it runs
+func scriptShape(t *testing.T, script string) (map[string]bool, int) {
+	t.Helper()
+
+	file, err := syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(script), "deploy.sh")
+	if err != nil {
+		t.Fatalf("Failed to parse deploy script: %v\n%s", err, script)
+	}
+
+	literals := make(map[string]bool)
+	commands := 0
+	syntax.Walk(file, func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.Word:
+			if value, ok := literalWord(n); ok {
+				literals[value] = true
+			}
+		case *syntax.CallExpr, *syntax.CmdSubst, *syntax.ParamExp, *syntax.ArithmExp, *syntax.ProcSubst:
+			commands++
+		}
+		return true
+	})
+	return literals, commands
+}
+
+// literalWord returns the value of a word made only of literal text and
+// single quoted strings, as shellQuote produces
+func literalWord(word *syntax.Word) (string, bool) {
+	var b strings.Builder
+	for _, part := range word.Parts {
+		switch p := part.(type) {
+		case *syntax.SglQuoted:
+			if p.Dollar {
+				return "", false
+			}
+			b.WriteString(p.Value)
+		case *syntax.Lit:
+			escaped := false
+			for _, r := range p.Value {
+				if r == '\\' && !escaped {
+					escaped = true
+					continue
+				}
+				escaped = false
+				b.WriteRune(r)
+			}
+		default:
+			return "", false
+		}
+	}
+	return b.String(), true
+}
+
+func FuzzDeployScriptQuoting(f *testing.F) {
+	f.Add("test-rg", "teststorageaccount", "out", "test-env", "https://test-vault.vault.azure.net/secrets/key")
+	f.Add("rg; rm -rf /", "$(touch pwned)", "`id`", "env\"name", "it's")
+	f.Add("a b\tc", "$HOME", "out dir/'x'", "${IFS}", "\\\n")
+	f.Add("rg'x", "$(id)", "out", "env", "")
+
+	f.Fuzz(func(t *testing.T, resourceGroup, storageAccountName, outputDir, envName, keyVaultURI string) {
+		for _, value := range []string{resourceGroup, storageAccountName, outputDir, envName, keyVaultURI} {
+			// Shell words can't hold NUL bytes, and the parser only reads UTF-8
+			if strings.ContainsRune(value, 0) || !utf8.ValidString(value) {
+				t.Skip()
+			}
+		}
+
+		safe := &Config{
+			ACAEnvironmentID:             "/subscriptions/s/resourceGroups/rg/providers/Microsoft.App/managedEnvironments/env",
+			ResourceGroup:                "rg",
+			StorageAccountName:           "account",
+			StorageAccountKeyKeyVaultURI: "uri",
+			OutputDir:                    "out",
+			DeployPrometheus:             true,
+		}
+		hostile := *safe
+		hostile.ACAEnvironmentID = "/subscriptions/s/resourceGroups/rg/providers/Microsoft.App/managedEnvironments/" + strings.ReplaceAll(envName, "/", "")
+		hostile.ResourceGroup = resourceGroup
+		hostile.StorageAccountName = storageAccountName
+		hostile.StorageAccountKeyKeyVaultURI = keyVaultURI
+		hostile.OutputDir = outputDir
+		// Without a URI the storage account key comes from the environment,
+		// so the script is compared with a safe one reading it from there too
+		if keyVaultURI == "" {
+			safe.StorageAccountKeyKeyVaultURI = ""
+		}
+
+		safeScripts := deployScripts(t, safe)
+		for format, script := range deployScripts(t, &hostile) {
+			literals, commands := scriptShape(t, script)
+			_, wantCommands := scriptShape(t, safeScripts[format])
+			if commands != wantCommands {
+				t.Errorf("%s deploy script runs %d commands and expansions, want %d:\n%s", format, commands, wantCommands, script)
+			}
+
+			values := []string{hostile.OutputDir}
+			if keyVaultURI != "" {
+				values = append(values, keyVaultURI)
+			}
+			switch format {
+			case outputFormatYAML:
+				values = append(values, hostile.ResourceGroup, hostile.StorageAccountName, environmentName(&hostile))
+			case outputFormatBicep, outputFormatARM:
+				values = append(values, hostile.ResourceGroup)
+			}
+			for _, value := range values {
+				if !literals[value] {
+					t.Errorf("%s deploy script doesn't keep %q as a single literal word:\n%s", format, value, script)
+				}
+			}
+		}
+	})
+}
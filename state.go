@@ -0,0 +1,295 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// stateFile is the default name of the state file in the output directory
+const stateFile = "bindplane-aca.state.json"
+
+// stateVersion is the version of the state file format. Files with a newer
+// version are rejected rather than overwritten.
+const stateVersion = 1
+
+// deployState records what apply last deployed, so that plan can tell which
+// container apps need to change
+type deployState struct {
+	Version       int    `json:"version"`
+	ResourceGroup string `json:"resourceGroup"`
+	EnvironmentID string `json:"environmentId"`
+	// UpdatedAt is when apply last changed the state
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	// Components holds the applied container apps by name
+	Components map[string]stateComponent `json:"components"`
+}
+
+// stateComponent is an applied container app
+type stateComponent struct {
+	File string `json:"file"`
+	// SpecHash is the specHash of the component when it was applied
+	SpecHash  string    `json:"specHash"`
+	AppliedAt time.Time `json:"appliedAt"`
+}
+
+// loadState reads the state file at path. A missing file is an empty state.
+func loadState(path string) (*deployState, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &deployState{Version: stateVersion, Components: map[string]stateComponent{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var state deployState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	if state.Version < 1 || state.Version > stateVersion {
+		return nil, fmt.Errorf("state file %s has version %d; this version of %s supports version %d", path, state.Version, programName, stateVersion)
+	}
+	if state.Components == nil {
+		state.Components = map[string]stateComponent{}
+	}
+	return &state, nil
+}
+
+// check returns an error if the state belongs to another deployment
+func (s *deployState) check(config *Config) error {
+	if len(s.Components) == 0 {
+		return nil
+	}
+	if s.ResourceGroup != config.ResourceGroup {
+		return fmt.Errorf("state file is for resource group %s, not %s; use a separate -state for each deployment", s.ResourceGroup, config.ResourceGroup)
+	}
+	if s.EnvironmentID != config.ACAEnvironmentID {
+		return fmt.Errorf("state file is for environment %s, not %s; use a separate -state for each deployment", s.EnvironmentID, config.ACAEnvironmentID)
+	}
+	return nil
+}
+
+// save writes the state to path, replacing the previous file only once the
+// new one is complete
+func (s *deployState) save(path string) error {
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(append(content, '\n')); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// record marks a component as applied
+func (s *deployState) record(config *Config, step planStep, at time.Time) {
+	s.Version, s.ResourceGroup, s.EnvironmentID, s.UpdatedAt = stateVersion, config.ResourceGroup, config.ACAEnvironmentID, &at
+	s.Components[step.name] = stateComponent{File: step.file, SpecHash: step.hash, AppliedAt: at}
+}
+
+// recordDeployed records a component deployed by a command other than apply,
+// such as rollback, so plan compares with what is deployed. Only components
+// the state already tracks are recorded, and only in the state of config's
+// deployment.
+func (s *deployState) recordDeployed(config *Config, path string, step planStep) error {
+	if _, ok := s.Components[step.name]; !ok || s.check(config) != nil {
+		return nil
+	}
+	s.record(config, step, time.Now().UTC())
+	return s.save(path)
+}
+
+// forget removes a deleted component
+func (s *deployState) forget(name string, at time.Time) {
+	s.UpdatedAt = &at
+	delete(s.Components, name)
+}
+
+// specHash returns the hash of a component rendered with parameter
+// placeholders. Secret values are not part of it, so the state file holds
+// nothing secret, but Key Vault references are.
+func specHash(component *iacComponent) (string, error) {
+	spec, err := json.Marshal(jsonValue(component.doc, func(s string) any { return s }))
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s: %w", component.file, err)
+	}
+	sum := sha256.Sum256(spec)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// planAction is what apply does with a container app
+type planAction string
+
+const (
+	actionCreate    planAction = "create"
+	actionUpdate    planAction = "update"
+	actionUnchanged planAction = "unchanged"
+	actionDelete    planAction = "delete"
+)
+
+// planStep is the action for one container app
+type planStep struct {
+	name   string
+	file   string
+	action planAction
+	// hash is the spec hash of the rendered component, empty for deletes
+	hash string
+}
+
+// computePlan compares the components, rendered with secret markers
+// in deploy order, with the state. Rendered components come first in deploy
+// order, then the deletes in reverse deploy order. With force every rendered
+// component that exists is updated.
+func computePlan(state *deployState, components []*iacComponent, force bool) ([]planStep, error) {
+	var steps []planStep
+	rendered := make(map[string]bool)
+	for _, component := range components {
+		hash, err := specHash(component)
+		if err != nil {
+			return nil, err
+		}
+		step := planStep{name: component.name, file: component.file, hash: hash, action: actionCreate}
+		if applied, ok := state.Components[component.name]; ok {
+			step.action = actionUnchanged
+			if force || applied.SpecHash != hash {
+				step.action = actionUpdate
+			}
+		}
+		steps = append(steps, step)
+		rendered[component.name] = true
+	}
+
+	var deletes []planStep
+	for name, applied := range state.Components {
+		if !rendered[name] {
+			deletes = append(deletes, planStep{name: name, file: applied.File, action: actionDelete})
+		}
+	}
+	order := deployOrder(&Config{DeployPrometheus: true})
+	slices.SortFunc(deletes, func(a, b planStep) int {
+		// Files that are no longer templates count as deployed last
+		ai, bi := slices.Index(order, a.file), slices.Index(order, b.file)
+		if ai < 0 {
+			ai = len(order)
+		}
+		if bi < 0 {
+			bi = len(order)
+		}
+		return cmp.Or(cmp.Compare(bi, ai), cmp.Compare(a.name, b.name))
+	})
+	return append(steps, deletes...), nil
+}
+
+// planSymbols prefixes each step in the plan output
+var planSymbols = map[planAction]string{
+	actionCreate:    "+",
+	actionUpdate:    "~",
+	actionUnchanged: " ",
+	actionDelete:    "-",
+}
+
+// writePlan prints the plan and reports whether it changes anything
+func writePlan(w io.Writer, steps []planStep) bool {
+	counts := make(map[planAction]int)
+	for _, step := range steps {
+		counts[step.action]++
+		fmt.Fprintf(w, "  %s %s (%s): %s\n", planSymbols[step.action], step.name, step.file, step.action)
+	}
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d unchanged, %d to delete\n",
+		counts[actionCreate], counts[actionUpdate], counts[actionUnchanged], counts[actionDelete])
+	return counts[actionUnchanged] < len(steps)
+}
+
+// applyPlan carries out the changes of a plan in order, saving the state to
+// statePath after each so that a failed apply can be resumed. components are
+// rendered with their secrets, in deploy order.
+func applyPlan(ctx context.Context, d *armDeployer, steps []planStep, components []*iacComponent, state *deployState, statePath string) error {
+	for _, step := range steps {
+		switch step.action {
+		case actionCreate, actionUpdate:
+			i := slices.IndexFunc(components, func(c *iacComponent) bool { return c.name == step.name })
+			if i < 0 {
+				return fmt.Errorf("component %s was not rendered", step.name)
+			}
+			if step.file == "prometheus.yaml" {
+				if err := d.setPrometheusStorage(ctx); err != nil {
+					return err
+				}
+			}
+			if err := d.deployApp(ctx, components[i]); err != nil {
+				return err
+			}
+			state.record(d.config, step, time.Now().UTC())
+		case actionDelete:
+			if err := d.deleteApp(ctx, step.name); err != nil {
+				return err
+			}
+			// The storage is only removed once the app no longer mounts it,
+			// and the app stays in the state until both are gone
+			if step.file == "prometheus.yaml" {
+				if err := d.removePrometheusStorage(ctx); err != nil {
+					return err
+				}
+			}
+			state.forget(step.name, time.Now().UTC())
+		default:
+			continue
+		}
+		if err := state.save(statePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planDeployment loads the state at statePath and plans the changes needed
+// to deploy the rendered components
+func planDeployment(config *Config, data *TemplateData, statePath string, force bool) (*deployState, []planStep, error) {
+	state, err := loadState(statePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := state.check(config); err != nil {
+		return nil, nil, err
+	}
+	components, err := renderComponents(config, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	steps, err := computePlan(state, components, force)
+	if err != nil {
+		return nil, nil, err
+	}
+	return state, steps, nil
+}
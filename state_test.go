@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStateSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", stateFile)
+
+	state, err := loadState(path)
+	if err != nil {
+		t.Fatalf("loadState failed for a missing file: %v", err)
+	}
+	if state.Version != stateVersion || len(state.Components) != 0 {
+		t.Errorf("Expected an empty state, got %+v", state)
+	}
+
+	config := iacTestConfig(t)
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	state.record(config, planStep{name: "bindplane", file: "bindplane.yaml", hash: "sha256:abc"}, at)
+	if err := state.save(path); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+
+	loaded, err := loadState(path)
+	if err != nil {
+		t.Fatalf("loadState failed: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, state) {
+		t.Errorf("loadState = %+v, want %+v", loaded, state)
+	}
+	if err := loaded.check(config); err != nil {
+		t.Errorf("Expected the state to match its config: %v", err)
+	}
+	other := *config
+	other.ResourceGroup = "other-rg"
+	if err := loaded.check(&other); err == nil || !strings.Contains(err.Error(), "state file is for resource group test-rg, not other-rg") {
+		t.Errorf("Expected a state of another resource group to be rejected, got: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"version": 1`, `"resourceGroup": "test-rg"`, `"specHash": "sha256:abc"`, `"appliedAt": "2026-01-02T03:04:05Z"`} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Expected the state file to contain %s, got:\n%s", want, content)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(`{"version": 2, "components": {}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadState(path); err == nil || !strings.Contains(err.Error(), "has version 2") {
+		t.Errorf("Expected a newer state version to be rejected, got: %v", err)
+	}
+}
+
+func TestSpecHash(t *testing.T) {
+	hashes := func(data *TemplateData) map[string]string {
+		t.Helper()
+		components, err := renderComponents(iacTestConfig(t), data)
+		if err != nil {
+			t.Fatalf("renderComponents failed: %v", err)
+		}
+		hashes := make(map[string]string)
+		for _, component := range components {
+			if hashes[component.name], err = specHash(component); err != nil {
+				t.Fatalf("specHash failed: %v", err)
+			}
+		}
+		return hashes
+	}
+
+	base := hashes(iacTestData())
+
+	rotated := iacTestData()
+	rotated.PostgresPassword = "rotated-password"
+	if got := hashes(rotated); !reflect.DeepEqual(got, base) {
+		t.Errorf("Expected secret values not to change the hashes, got %v, want %v", got, base)
+	}
+
+	upgraded := iacTestData()
+	upgraded.BindplaneTag = "1.95.0"
+	if got := hashes(upgraded); got["bindplane-transform-agent"] == base["bindplane-transform-agent"] || got["otelcol"] != base["otelcol"] {
+		t.Errorf("Expected the tag to change the hashes of the components that use it, got %v, was %v", got, base)
+	}
+}
+
+func TestComputePlan(t *testing.T) {
+	config := iacTestConfig(t)
+	components, err := renderComponents(config, iacTestData())
+	if err != nil {
+		t.Fatalf("renderComponents failed: %v", err)
+	}
+
+	state := &deployState{Version: stateVersion, Components: map[string]stateComponent{}}
+	steps, err := computePlan(state, components, false)
+	if err != nil {
+		t.Fatalf("computePlan failed: %v", err)
+	}
+	for _, step := range steps {
+		if step.action != actionCreate {
+			t.Errorf("Expected %s to be created without state, got %s", step.name, step.action)
+		}
+		state.record(config, step, time.Now())
+	}
+
+	// Everything in the state is unchanged, except what was edited or
+	// is no longer rendered
+	state.Components["bindplane"] = stateComponent{File: "bindplane.yaml", SpecHash: "sha256:old"}
+	state.Components["legacy"] = stateComponent{File: "legacy.yaml", SpecHash: "sha256:old"}
+	config.DeployPrometheus = false
+	components, err = renderComponents(config, iacTestData())
+	if err != nil {
+		t.Fatalf("renderComponents failed: %v", err)
+	}
+	steps, err = computePlan(state, components, false)
+	if err != nil {
+		t.Fatalf("computePlan failed: %v", err)
+	}
+
+	var got []string
+	for _, step := range steps {
+		got = append(got, step.name+" "+string(step.action))
+	}
+	expected := []string{
+		"bindplane-transform-agent unchanged",
+		"bindplane-jobs unchanged",
+		"bindplane update",
+		"otelcol unchanged",
+		"legacy delete",
+		"bindplane-prometheus delete",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("computePlan = %q, want %q", got, expected)
+	}
+
+	var out bytes.Buffer
+	if !writePlan(&out, steps) {
+		t.Error("Expected writePlan to report changes")
+	}
+	for _, want := range []string{
+		"    bindplane-jobs (jobs.yaml): unchanged\n",
+		"  ~ bindplane (bindplane.yaml): update\n",
+		"  - bindplane-prometheus (prometheus.yaml): delete\n",
+		"Plan: 0 to create, 1 to update, 3 unchanged, 2 to delete\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected the plan to contain %q, got:\n%s", want, out.String())
+		}
+	}
+
+	steps, err = computePlan(state, components, true)
+	if err != nil {
+		t.Fatalf("computePlan failed: %v", err)
+	}
+	if steps[0].action != actionUpdate {
+		t.Errorf("Expected -force to update unchanged components, got %s", steps[0].action)
+	}
+}
+
+func TestRunPlanAndApply(t *testing.T) {
+	stubRunCommand(t)
+	arm := newMockARM(t)
+	for _, name := range []string{"AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET"} {
+		t.Setenv(name, "")
+	}
+
+	outputDir := filepath.Join(t.TempDir(), "out")
+	planArgs := func(extra ...string) []string {
+		return append(cliTestArgs(outputDir), extra...)
+	}
+	args := func(extra ...string) []string {
+		return planArgs(append([]string{
+			"-health-timeout", "0",
+			"-arm-endpoint", arm.URL, "-arm-authority-host", arm.URL,
+			"-arm-tenant-id", "test-tenant", "-arm-client-id", "test-client", "-arm-client-secret", "test-secret",
+		}, extra...)...)
+	}
+	puts := func() []string {
+		var names []string
+		for _, request := range arm.requests {
+			if name, ok := strings.CutPrefix(request, "PUT /subscriptions/test-subscription-id/resourceGroups/test-rg/providers/Microsoft.App/containerApps/"); ok {
+				names = append(names, name)
+			} else if strings.HasPrefix(request, "DELETE ") {
+				names = append(names, "delete "+request[strings.LastIndex(request, "/")+1:])
+			}
+		}
+		arm.requests = nil
+		return names
+	}
+
+	if err := runPlan(planArgs("-exit-code")); !errors.Is(err, errDifferences) {
+		t.Errorf("Expected plan -exit-code to report changes without state, got: %v", err)
+	}
+	if err := runApply(args("-deploy-prometheus")); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	expected := []string{"bindplane-transform-agent", "bindplane-prometheus", "bindplane-jobs", "bindplane", "otelcol"}
+	if got := puts(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("apply put %q, want %q", got, expected)
+	}
+	state, err := loadState(filepath.Join(outputDir, stateFile))
+	if err != nil {
+		t.Fatalf("loadState failed: %v", err)
+	}
+	if len(state.Components) != len(expected) {
+		t.Errorf("Expected the state to hold %d components, got %+v", len(expected), state.Components)
+	}
+
+	// Nothing changed, so nothing is applied
+	if err := runPlan(planArgs("-deploy-prometheus", "-exit-code")); err != nil {
+		t.Errorf("Expected plan -exit-code to succeed without changes, got: %v", err)
+	}
+	if err := runApply(args("-deploy-prometheus")); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if got := puts(); len(got) != 0 {
+		t.Errorf("Expected apply without changes to do nothing, got %q", got)
+	}
+
+	// Only the apps that run the new tag are updated
+	if err := runApply(args("-deploy-prometheus", "-bindplane-tag", "1.95.0")); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if got := puts(); !reflect.DeepEqual(got, []string{"bindplane-transform-agent", "bindplane-prometheus", "bindplane-jobs", "bindplane"}) {
+		t.Errorf("Expected the tag to update the apps whose image uses it, got %q", got)
+	}
+
+	// Prometheus is deleted once it is no longer rendered, but only with -yes
+	err = runApply(args("-bindplane-tag", "1.95.0"))
+	if err == nil || !strings.Contains(err.Error(), "without -yes") {
+		t.Errorf("Expected apply to refuse to delete without -yes, got: %v", err)
+	}
+	if err := runApply(args("-bindplane-tag", "1.95.0", "-yes")); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if got := puts(); !reflect.DeepEqual(got, []string{"delete bindplane-prometheus", "delete prometheus-pv"}) {
+		t.Errorf("Expected apply to delete Prometheus and its environment storage only, got %q", got)
+	}
+	state, err = loadState(filepath.Join(outputDir, stateFile))
+	if err != nil {
+		t.Fatalf("loadState failed: %v", err)
+	}
+	if _, ok := state.Components["bindplane-prometheus"]; ok || len(state.Components) != 4 {
+		t.Errorf("Expected Prometheus to be removed from the state, got %+v", state.Components)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// embeddedTemplates holds the default templates shipped with the binary
+//
+//go:embed templates/*.yaml
+var embeddedTemplates embed.FS
+
+// readTemplate returns the contents of the named template and a description of
+// where it was read from. A file of the same name in templatesDir, when set,
+// replaces the embedded template.
+func readTemplate(templatesDir, filename string) ([]byte, string, error) {
+	if templatesDir != "" {
+		templatePath := filepath.Join(templatesDir, filename)
+		content, err := os.ReadFile(templatePath)
+		if err == nil {
+			return content, templatePath, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, "", fmt.Errorf("failed to read template file %s: %w", templatePath, err)
+		}
+	}
+
+	embeddedPath := path.Join("templates", filename)
+	content, err := embeddedTemplates.ReadFile(embeddedPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read embedded template %s: %w", embeddedPath, err)
+	}
+
+	return content, "embedded:" + embeddedPath, nil
+}
+
+// templateFuncs are the functions available to the templates
+var templateFuncs = template.FuncMap{
+	"yamlQuote": yamlQuote,
+}
+
+// yamlQuote returns s as a double quoted YAML scalar, using YAML escapes so
+// that any value, such as a secret or a multi-line certificate, reads back
+// exactly. Invalid UTF-8 can't be represented in YAML and is an error.
+func yamlQuote(s string) (string, error) {
+	out, err := yaml.Marshal(&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Style: yaml.DoubleQuotedStyle, Value: s})
+	if err != nil {
+		return "", fmt.Errorf("failed to quote value: %w", err)
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestReadTemplateEmbedded(t *testing.T) {
+	for _, filename := range []string{"bindplane.yaml", "jobs.yaml", "prometheus.yaml", "transform-agent.yaml", "otelcol.yaml"} {
+		t.Run(filename, func(t *testing.T) {
+			content, source, err := readTemplate("", filename)
+			if err != nil {
+				t.Fatalf("Failed to read embedded template: %v", err)
+			}
+
+			onDisk, err := os.ReadFile(filepath.Join("templates", filename))
+			if err != nil {
+				t.Fatalf("Failed to read template from disk: %v", err)
+			}
+			if !bytes.Equal(content, onDisk) {
+				t.Errorf("Embedded template %s doesn't match templates directory", filename)
+			}
+			if !strings.HasPrefix(source, "embedded:") {
+				t.Errorf("Expected embedded source, got: %s", source)
+			}
+		})
+	}
+}
+
+func TestReadTemplateOverride(t *testing.T) {
+	dir := t.TempDir()
+	override := []byte("name: custom-bindplane\n")
+	if err := os.WriteFile(filepath.Join(dir, "bindplane.yaml"), override, 0644); err != nil {
+		t.Fatalf("Failed to write override: %v", err)
+	}
+
+	content, source, err := readTemplate(dir, "bindplane.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read overridden template: %v", err)
+	}
+	if !bytes.Equal(content, override) {
+		t.Errorf("Expected override content, got:\n%s", content)
+	}
+	if source != filepath.Join(dir, "bindplane.yaml") {
+		t.Errorf("Unexpected source: %s", source)
+	}
+
+	// Files missing from the override directory fall back to the embedded copy
+	content, _, err = readTemplate(dir, "jobs.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read fallback template: %v", err)
+	}
+	if !bytes.Contains(content, []byte("name: bindplane-jobs")) {
+		t.Errorf("Expected embedded jobs template, got:\n%s", content)
+	}
+}
+
+func TestReadTemplateMissing(t *testing.T) {
+	if _, _, err := readTemplate(t.TempDir(), "missing.yaml"); err == nil {
+		t.Error("Expected error for missing template but got none")
+	}
+}
+
+func TestYAMLQuote(t *testing.T) {
+	for _, value := range []string{"", "plain", `"quoted" \ 'single'`, "line\nbreak\r\n", " \tlead\x00\x7f\u2028\U0001F600", "# not a comment"} {
+		quoted, err := yamlQuote(value)
+		if err != nil {
+			t.Fatalf("yamlQuote(%q) failed: %v", value, err)
+		}
+		var decoded struct {
+			Value string `yaml:"value"`
+		}
+		if err := yaml.Unmarshal([]byte("value: "+quoted+" # comment\n"), &decoded); err != nil {
+			t.Fatalf("yamlQuote(%q) = %s, which doesn't parse: %v", value, quoted, err)
+		}
+		if decoded.Value != value {
+			t.Errorf("yamlQuote(%q) = %s, which reads back as %q", value, quoted, decoded.Value)
+		}
+	}
+
+	if _, err := yamlQuote("invalid \xff utf-8"); err == nil {
+		t.Error("Expected error quoting invalid UTF-8 but got none")
+	}
+}
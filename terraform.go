@@ -0,0 +1,572 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// terraformFiles lists the generated Terraform files in the order they are
+// written
+var terraformFiles = []string{
+	"versions.tf",
+	"variables.tf",
+	"environment_storage.tf",
+	"container_apps.tf",
+	"outputs.tf",
+}
+
+// renderTerraform converts the rendered templates into Terraform files keyed
+// by filename. Secrets become sensitive variables.
+func renderTerraform(config *Config, data *TemplateData) (map[string][]byte, error) {
+	components, err := renderComponents(config, data)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &terraformWriter{used: make(map[string]bool)}
+
+	var storage *hclBlock
+	if config.DeployPrometheus {
+		storage = &hclBlock{header: `resource "azurerm_container_app_environment_storage" "prometheus"`}
+		storage.attr("name", tfString("prometheus-pv"))
+		storage.attr("container_app_environment_id", tfString(config.ACAEnvironmentID))
+		storage.attr("account_name", tfString(config.StorageAccountName))
+		storage.attr("access_key", w.string(sensitiveMarker("StorageAccountKey")))
+		storage.attr("share_name", tfString("prometheus-data"))
+		storage.attr("access_mode", tfString("ReadWrite"))
+	}
+
+	var apps, outputs []*hclBlock
+	previous := ""
+	for _, component := range components {
+		app, err := parseContainerApp(component.file, component.content)
+		if err != nil {
+			return nil, err
+		}
+
+		patches := len(w.patches)
+		block, err := w.containerApp(component, app)
+		if err != nil {
+			return nil, err
+		}
+
+		// Deploy in the same order as deploy.sh, after the previous app and
+		// its patches
+		var dependsOn []string
+		if previous != "" {
+			dependsOn = append(dependsOn, previous)
+		}
+		if component.file == "prometheus.yaml" && storage != nil {
+			dependsOn = append(dependsOn, "azurerm_container_app_environment_storage.prometheus")
+		}
+		if len(dependsOn) > 0 {
+			block.attr("depends_on", "["+strings.Join(dependsOn, ", ")+"]")
+		}
+		apps = append(apps, block)
+
+		address := "azurerm_container_app." + terraformName(component.name)
+		deployed := []string{address}
+		for _, patch := range w.patches[patches:] {
+			apps = append(apps, patch)
+			deployed = append(deployed, patch.address())
+		}
+		stage := &hclBlock{header: fmt.Sprintf("resource \"terraform_data\" %q", terraformStageName(component.name))}
+		stage.attr("depends_on", "["+strings.Join(deployed, ", ")+"]")
+		apps = append(apps, stage)
+		previous = "terraform_data." + terraformStageName(component.name)
+
+		if app.Properties.Configuration.Ingress != nil {
+			output := &hclBlock{header: fmt.Sprintf("output %q", terraformName(component.name)+"_fqdn")}
+			output.attr("description", tfString("Ingress FQDN of the "+component.name+" container app"))
+			output.attr("value", address+".ingress[0].fqdn")
+			outputs = append(outputs, output)
+		}
+	}
+
+	versions := &hclBlock{header: "terraform"}
+	// terraform_data marks the deploy stages
+	versions.attr("required_version", tfString(">= 1.4"))
+	providers := versions.block("required_providers")
+	providers.attr("azurerm", "{\n      source  = \"hashicorp/azurerm\"\n      version = \"~> 4.0\"\n    }")
+	if len(w.patches) > 0 {
+		providers.attr("azapi", "{\n      source  = \"Azure/azapi\"\n      version = \"~> 2.0\"\n    }")
+	}
+	provider := &hclBlock{header: `provider "azurerm"`}
+	provider.block("features")
+	provider.attr("subscription_id", tfString(data.AzureSubscriptionID))
+
+	var variables []*hclBlock
+	resourceGroup := &hclBlock{header: `variable "resource_group_name"`}
+	resourceGroup.attr("description", tfString("Resource group the container apps are created in"))
+	resourceGroup.attr("type", "string")
+	resourceGroup.attr("default", tfString(config.ResourceGroup))
+	variables = append(variables, resourceGroup)
+	for _, p := range secretParameters {
+		if !w.used[p.name()] {
+			continue
+		}
+		variable := &hclBlock{header: fmt.Sprintf("variable %q", terraformName(p.flag))}
+		variable.attr("description", tfString(p.description))
+		variable.attr("type", "string")
+		variable.attr("sensitive", "true")
+		variables = append(variables, variable)
+	}
+
+	files := map[string][]byte{
+		"versions.tf":       renderHCL(versions, provider),
+		"variables.tf":      renderHCL(variables...),
+		"container_apps.tf": renderHCL(apps...),
+		"outputs.tf":        renderHCL(outputs...),
+	}
+	if storage != nil {
+		files["environment_storage.tf"] = renderHCL(storage)
+	}
+
+	return files, nil
+}
+
+// terraformWriter converts container apps into azurerm resources, recording
+// the variables referenced along the way
+type terraformWriter struct {
+	used map[string]bool
+	// patches holds azapi updates for settings azurerm_container_app lacks
+	patches []*hclBlock
+}
+
+// azapiPatch returns an azapi_update_resource that sets the value at path on
+// the component's container app, for settings azurerm_container_app cannot
+// express
+func (w *terraformWriter) azapiPatch(component *iacComponent, suffix string, path []string) *hclBlock {
+	name := terraformName(component.name)
+	node := component.doc
+	for _, key := range path {
+		node = mappingValue(node, key)
+	}
+
+	body := w.value(node, strings.Repeat("  ", len(path)+1))
+	for i := len(path) - 1; i >= 0; i-- {
+		indent := strings.Repeat("  ", i+1)
+		body = fmt.Sprintf("{\n%s  %s = %s\n%s}", indent, path[i], body, indent)
+	}
+
+	patch := &hclBlock{header: fmt.Sprintf("resource \"azapi_update_resource\" %q", name+"_"+suffix)}
+	patch.attr("type", tfString("Microsoft.App/containerApps@"+containerAppsAPIVersion))
+	patch.attr("resource_id", "azurerm_container_app."+name+".id")
+	patch.attr("body", body)
+	return patch
+}
+
+// value returns the Terraform expression for a rendered YAML node
+func (w *terraformWriter) value(node *yaml.Node, indent string) string {
+	node = resolveAlias(node)
+	switch node.Kind {
+	case yaml.MappingNode:
+		if len(node.Content) == 0 {
+			return "{}"
+		}
+		var b strings.Builder
+		b.WriteString("{\n")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			fmt.Fprintf(&b, "%s  %s = %s\n", indent, node.Content[i].Value, w.value(node.Content[i+1], indent+"  "))
+		}
+		b.WriteString(indent + "}")
+		return b.String()
+	case yaml.SequenceNode:
+		if len(node.Content) == 0 {
+			return "[]"
+		}
+		var b strings.Builder
+		b.WriteString("[\n")
+		for _, item := range node.Content {
+			fmt.Fprintf(&b, "%s  %s,\n", indent, w.value(item, indent+"  "))
+		}
+		b.WriteString(indent + "]")
+		return b.String()
+	}
+
+	switch node.ShortTag() {
+	case "!!int", "!!float", "!!bool":
+		return node.Value
+	case "!!null":
+		return "null"
+	default:
+		return w.string(node.Value)
+	}
+}
+
+// containerApp returns the azurerm_container_app resource for app
+func (w *terraformWriter) containerApp(component *iacComponent, app *containerApp) (*hclBlock, error) {
+	block := &hclBlock{header: fmt.Sprintf("resource \"azurerm_container_app\" %q", terraformName(component.name))}
+	block.attr("name", w.string(app.Name))
+	block.attr("container_app_environment_id", w.string(app.Properties.ManagedEnvironmentID))
+	block.attr("resource_group_name", "var.resource_group_name")
+	block.attr("revision_mode", w.string(app.Properties.Configuration.ActiveRevisionsMode))
+
+	if app.Identity != nil {
+		identity := block.block("identity")
+		identity.attr("type", w.string(app.Identity.Type))
+		if len(app.Identity.UserAssignedIdentities) > 0 {
+			var ids []string
+			for id := range app.Identity.UserAssignedIdentities {
+				ids = append(ids, w.string(id))
+			}
+			sort.Strings(ids)
+			identity.attr("identity_ids", "["+strings.Join(ids, ", ")+"]")
+		}
+	}
+
+	for _, secret := range app.Properties.Configuration.Secrets {
+		s := block.block("secret")
+		s.attr("name", w.string(secret.Name))
+		if secret.KeyVaultURL != "" {
+			s.attr("key_vault_secret_id", w.string(secret.KeyVaultURL))
+			s.attr("identity", w.string(secret.Identity))
+			continue
+		}
+		s.attr("value", w.string(secret.Value))
+	}
+
+	if ingress := app.Properties.Configuration.Ingress; ingress != nil {
+		if len(ingress.AdditionalPortMappings) > 0 {
+			w.patches = append(w.patches, w.azapiPatch(component, "additional_port_mappings",
+				[]string{"properties", "configuration", "ingress", "additionalPortMappings"}))
+		}
+		i := block.block("ingress")
+		i.attr("external_enabled", strconv.FormatBool(ingress.External))
+		i.attr("target_port", strconv.Itoa(ingress.TargetPort))
+		i.attr("allow_insecure_connections", strconv.FormatBool(ingress.AllowInsecure))
+		if ingress.Transport != "" {
+			i.attr("transport", w.string(ingress.Transport))
+		}
+		traffic := i.block("traffic_weight")
+		traffic.attr("latest_revision", "true")
+		traffic.attr("percentage", "100")
+	}
+
+	template := block.block("template")
+	template.attr("min_replicas", strconv.Itoa(app.Properties.Template.Scale.MinReplicas))
+	template.attr("max_replicas", strconv.Itoa(app.Properties.Template.Scale.MaxReplicas))
+
+	for _, container := range app.Properties.Template.Containers {
+		c := template.block("container")
+		c.attr("name", w.string(container.Name))
+		c.attr("image", w.string(container.Image))
+		if len(container.Command) > 0 {
+			c.attr("command", w.list(container.Command))
+		}
+		if len(container.Args) > 0 {
+			c.attr("args", w.list(container.Args))
+		}
+		c.attr("cpu", strconv.FormatFloat(container.Resources.CPU, 'f', -1, 64))
+		c.attr("memory", w.string(container.Resources.Memory))
+
+		for _, env := range container.Env {
+			e := c.block("env")
+			e.attr("name", w.string(env.Name))
+			if env.SecretRef != "" {
+				e.attr("secret_name", w.string(env.SecretRef))
+				continue
+			}
+			e.attr("value", w.string(env.Value))
+		}
+
+		for _, probe := range container.Probes {
+			p, err := w.probe(component, probe)
+			if err != nil {
+				return nil, err
+			}
+			c.body = append(c.body, hclItem{block: p})
+		}
+
+		// readOnly has no azurerm equivalent; Secret volumes are always read only
+		for _, mount := range container.VolumeMounts {
+			m := c.block("volume_mounts")
+			m.attr("name", w.string(mount.VolumeName))
+			m.attr("path", w.string(mount.MountPath))
+		}
+	}
+
+	for _, volume := range app.Properties.Template.Volumes {
+		if len(volume.Secrets) > 0 {
+			// Secret volume items replace the whole volumes list, so patch it once
+			w.patches = append(w.patches, w.azapiPatch(component, "volumes",
+				[]string{"properties", "template", "volumes"}))
+			break
+		}
+	}
+	for _, volume := range app.Properties.Template.Volumes {
+		v := template.block("volume")
+		v.attr("name", w.string(volume.Name))
+		if volume.StorageType != "" {
+			v.attr("storage_type", w.string(volume.StorageType))
+		}
+		if volume.StorageName != "" {
+			v.attr("storage_name", w.string(volume.StorageName))
+		}
+	}
+
+	return block, nil
+}
+
+// probe returns the azurerm probe block for probe
+func (w *terraformWriter) probe(component *iacComponent, probe containerAppProbe) (*hclBlock, error) {
+	var name string
+	switch probe.Type {
+	case "liveness", "Liveness":
+		name = "liveness_probe"
+	case "readiness", "Readiness":
+		name = "readiness_probe"
+	case "startup", "Startup":
+		name = "startup_probe"
+	default:
+		return nil, fmt.Errorf("template %s: unsupported probe type %q", component.file, probe.Type)
+	}
+
+	p := &hclBlock{header: name}
+	switch {
+	case probe.HTTPGet != nil:
+		transport := "HTTP"
+		if strings.EqualFold(probe.HTTPGet.Scheme, "https") {
+			transport = "HTTPS"
+		}
+		p.attr("transport", tfString(transport))
+		p.attr("port", strconv.Itoa(probe.HTTPGet.Port))
+		p.attr("path", w.string(probe.HTTPGet.Path))
+	case probe.TCPSocket != nil:
+		p.attr("transport", tfString("TCP"))
+		p.attr("port", strconv.Itoa(probe.TCPSocket.Port))
+	default:
+		return nil, fmt.Errorf("template %s: %s probe has no httpGet or tcpSocket action", component.file, probe.Type)
+	}
+
+	p.attr("initial_delay", strconv.Itoa(probe.InitialDelaySeconds))
+	if probe.PeriodSeconds > 0 {
+		p.attr("interval_seconds", strconv.Itoa(probe.PeriodSeconds))
+	}
+	if probe.TimeoutSeconds > 0 {
+		p.attr("timeout", strconv.Itoa(probe.TimeoutSeconds))
+	}
+	if probe.FailureThreshold > 0 {
+		p.attr("failure_count_threshold", strconv.Itoa(probe.FailureThreshold))
+	}
+	if probe.SuccessThreshold > 0 && name == "readiness_probe" {
+		p.attr("success_count_threshold", strconv.Itoa(probe.SuccessThreshold))
+	}
+
+	return p, nil
+}
+
+// string returns s as a Terraform string, referencing variables in place of
+// secret parameter markers
+func (w *terraformWriter) string(s string) string {
+	segments := splitPlaceholders(s)
+	if len(segments) == 1 && segments[0].param != "" {
+		return w.variable(segments[0].param)
+	}
+
+	var b strings.Builder
+	b.WriteString(`"`)
+	for _, segment := range segments {
+		if segment.param != "" {
+			fmt.Fprintf(&b, "${%s}", w.variable(segment.param))
+			continue
+		}
+		b.WriteString(terraformEscaper.Replace(segment.text))
+	}
+	b.WriteString(`"`)
+	return b.String()
+}
+
+// list returns items as a Terraform list of strings
+func (w *terraformWriter) list(items []string) string {
+	values := make([]string, len(items))
+	for i, item := range items {
+		values[i] = w.string(item)
+	}
+	return "[" + strings.Join(values, ", ") + "]"
+}
+
+// variable returns the reference to the variable for the named parameter
+func (w *terraformWriter) variable(param string) string {
+	w.used[param] = true
+	if p, ok := lookupSecretParameter(param); ok {
+		return "var." + terraformName(p.flag)
+	}
+	return "var." + param
+}
+
+// terraformEscaper escapes text for a quoted Terraform string
+var terraformEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+	"${", "$${",
+	"%{", "%%{",
+)
+
+// terraformStageName returns the name of the terraform_data resource that
+// depends on the named container app and its patches. deploy.sh applies them
+// one at a time to wait for each app to become healthy before the next.
+func terraformStageName(name string) string {
+	return terraformName(name) + "_deployed"
+}
+
+// tfString returns s as a literal Terraform string
+func tfString(s string) string {
+	return `"` + terraformEscaper.Replace(s) + `"`
+}
+
+// terraformName converts a dashed name into a Terraform identifier
+func terraformName(name string) string {
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+// hclBlock is a Terraform block made of attributes and nested blocks
+type hclBlock struct {
+	header string
+	body   []hclItem
+}
+
+// hclItem is either an attribute or a nested block
+type hclItem struct {
+	key   string
+	value string
+	block *hclBlock
+}
+
+// address returns the address of a resource block, e.g.
+// azapi_update_resource.otelcol_volumes for
+// resource "azapi_update_resource" "otelcol_volumes"
+func (b *hclBlock) address() string {
+	fields := strings.Fields(strings.TrimPrefix(b.header, "resource "))
+	for i := range fields {
+		fields[i] = strings.Trim(fields[i], `"`)
+	}
+	return strings.Join(fields, ".")
+}
+
+// attr appends an attribute to the block
+func (b *hclBlock) attr(key, value string) {
+	b.body = append(b.body, hclItem{key: key, value: value})
+}
+
+// block appends a nested block and returns it
+func (b *hclBlock) block(header string) *hclBlock {
+	nested := &hclBlock{header: header}
+	b.body = append(b.body, hclItem{block: nested})
+	return nested
+}
+
+// write formats the block at the given indent, aligning the equals signs of
+// consecutive attributes the way terraform fmt does
+func (b *hclBlock) write(out *strings.Builder, indent string) {
+	if len(b.body) == 0 {
+		fmt.Fprintf(out, "%s%s {}\n", indent, b.header)
+		return
+	}
+
+	fmt.Fprintf(out, "%s%s {\n", indent, b.header)
+	for i := 0; i < len(b.body); {
+		if b.body[i].block != nil {
+			if i > 0 {
+				out.WriteString("\n")
+			}
+			b.body[i].block.write(out, indent+"  ")
+			i++
+			continue
+		}
+
+		// Align a run of single line attributes
+		end, width := i, 0
+		for end < len(b.body) && b.body[end].block == nil {
+			if !strings.Contains(b.body[end].value, "\n") && len(b.body[end].key) > width {
+				width = len(b.body[end].key)
+			}
+			end++
+		}
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		for ; i < end; i++ {
+			key := b.body[i].key
+			if !strings.Contains(b.body[i].value, "\n") {
+				key += strings.Repeat(" ", width-len(key))
+			}
+			fmt.Fprintf(out, "%s  %s = %s\n", indent, key, b.body[i].value)
+		}
+	}
+	fmt.Fprintf(out, "%s}\n", indent)
+}
+
+// renderHCL formats top level blocks separated by blank lines
+func renderHCL(blocks ...*hclBlock) []byte {
+	var out strings.Builder
+	out.WriteString("# Generated by bindplane-aca. Do not edit by hand.\n")
+	for _, block := range blocks {
+		out.WriteString("\n")
+		block.write(&out, "")
+	}
+	return []byte(out.String())
+}
+
+// terraformDeploymentCommands applies the Terraform configuration, passing
+// sensitive variables from the environment
+func terraformDeploymentCommands(config *Config) []string {
+	commands := []string{
+		"#!/bin/bash",
+		"# Generated Terraform deployment commands for Bindplane Azure Container Apps",
+		"",
+		"set -e",
+		"",
+		"OUTPUT_DIR=" + shellQuote(config.OutputDir),
+		"",
+	}
+	commands = append(commands, envFileCommands()...)
+	commands = append(commands, terraformVariableCommands(config)...)
+
+	commands = append(commands, "")
+	commands = append(commands, healthCheckCommands(config)...)
+	commands = append(commands,
+		"",
+		"echo \"Deploying Bindplane to Azure Container Apps with Terraform...\"",
+		"terraform -chdir=\"$OUTPUT_DIR\" init -input=false",
+		"",
+		"# Each stage applies one container app, with the apps before it, and waits for",
+		"# it to become healthy; the last applies everything",
+	)
+	order := deployOrder(config)
+	for i, filename := range order {
+		name := containerAppNames[filename]
+		if i < len(order)-1 {
+			commands = append(commands, fmt.Sprintf("terraform -chdir=\"$OUTPUT_DIR\" apply -input=false -auto-approve -target=terraform_data.%s", terraformStageName(name)))
+		} else {
+			commands = append(commands, "terraform -chdir=\"$OUTPUT_DIR\" apply -input=false -auto-approve")
+		}
+		commands = append(commands, "wait_healthy "+name)
+	}
+	return append(commands,
+		"",
+		"echo \"Deployment complete!\"",
+		"",
+		"echo \"Container app FQDNs:\"",
+		"terraform -chdir=\"$OUTPUT_DIR\" output",
+	)
+}
+
+// terraformVariableCommands exports the sensitive Terraform variables
+func terraformVariableCommands(config *Config) []string {
+	commands := []string{
+		"",
+		"# Sensitive variables are read from the environment or Key Vault so they never appear in this script",
+	}
+	for _, p := range scriptParameters(config) {
+		commands = append(commands, fmt.Sprintf("export TF_VAR_%s=\"%s\"", terraformName(p.flag), p.scriptValue(config)))
+	}
+	return commands
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderTerraform(t *testing.T) {
+	config := iacTestConfig(t)
+	data := iacTestData()
+
+	files, err := renderTerraform(config, data)
+	if err != nil {
+		t.Fatalf("Failed to render Terraform: %v", err)
+	}
+
+	for _, filename := range terraformFiles {
+		t.Run(filename, func(t *testing.T) {
+			content, ok := files[filename]
+			if !ok {
+				t.Fatalf("Missing Terraform file %s", filename)
+			}
+			compareGolden(t, filepath.Join("testdata", "terraform", filename), content)
+			assertNoSecrets(t, content, config, data)
+		})
+	}
+}
+
+func TestRenderTerraformSensitiveVariables(t *testing.T) {
+	config := iacTestConfig(t)
+	config.DeployPrometheus = false
+
+	files, err := renderTerraform(config, iacTestData())
+	if err != nil {
+		t.Fatalf("Failed to render Terraform: %v", err)
+	}
+
+	if _, ok := files["environment_storage.tf"]; ok {
+		t.Error("Expected no environment storage without prometheus")
+	}
+
+	variables := string(files["variables.tf"])
+	for _, name := range []string{"license", "postgres_password", "session_secret", "azure_connection_string"} {
+		block := `variable "` + name + `" {`
+		i := strings.Index(variables, block)
+		if i < 0 {
+			t.Errorf("Missing variable %s", name)
+			continue
+		}
+		end := strings.Index(variables[i:], "}")
+		if !strings.Contains(variables[i:i+end], "sensitive   = true") {
+			t.Errorf("Variable %s is not marked sensitive:\n%s", name, variables[i:i+end])
+		}
+	}
+	if strings.Contains(variables, "storage_account_key") {
+		t.Error("Expected no storage account key variable without prometheus")
+	}
+}
+
+func TestTerraformString(t *testing.T) {
+	w := &terraformWriter{used: make(map[string]bool)}
+
+	testCases := map[string]string{
+		"plain":                    `"plain"`,
+		`say "hi"`:                 `"say \"hi\""`,
+		"${HOME}":                  `"$${HOME}"`,
+		sensitiveMarker("License"): "var.license",
+		"user:" + sensitiveMarker("PostgresPassword"): `"user:${var.postgres_password}"`,
+	}
+
+	for input, expected := range testCases {
+		if got := w.string(input); got != expected {
+			t.Errorf("string(%q) mismatch. Expected: %s, Got: %s", input, expected, got)
+		}
+	}
+}
+
+func TestTerraformDeploymentCommands(t *testing.T) {
+	config := iacTestConfig(t)
+
+	script := strings.Join(terraformDeploymentCommands(config), "\n")
+	for _, want := range []string{
+		`export TF_VAR_license="${BINDPLANE_ACA_LICENSE:?`,
+		`export TF_VAR_storage_account_key="${BINDPLANE_ACA_STORAGE_ACCOUNT_KEY:?`,
+		"apply -input=false -auto-approve",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected deploy script to contain %q, got:\n%s", want, script)
+		}
+	}
+	if strings.Contains(script, config.StorageAccountKey) {
+		t.Errorf("Deploy script contains the storage account key:\n%s", script)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// containerAppSchemaYAML is the schema rendered container app templates are
+// validated against
+//
+//go:embed schema/containerapp.yaml
+var containerAppSchemaYAML []byte
+
+// schema is the subset of JSON Schema used by schema/containerapp.yaml
+type schema struct {
+	Type       string             `yaml:"type"`
+	Required   []string           `yaml:"required"`
+	Properties map[string]*schema `yaml:"properties"`
+	Items      *schema            `yaml:"items"`
+	Enum       []string           `yaml:"enum"`
+	MinItems   int                `yaml:"minItems"`
+	Minimum    *float64           `yaml:"minimum"`
+	Maximum    *float64           `yaml:"maximum"`
+}
+
+// containerAppSchema is the parsed containerAppSchemaYAML
+var containerAppSchema = mustParseSchema(containerAppSchemaYAML)
+
+// mustParseSchema parses an embedded schema, panicking if it is invalid
+func mustParseSchema(content []byte) *schema {
+	var s schema
+	if err := yaml.Unmarshal(content, &s); err != nil {
+		panic(fmt.Sprintf("invalid embedded schema: %v", err))
+	}
+	return &s
+}
+
+// validationError is a problem found in a rendered template
+type validationError struct {
+	// line is the line of the rendered template the problem was found on
+	line    int
+	path    string
+	message string
+}
+
+// validateContainerApp parses a rendered template and checks it against the
+// container app schema and the Container Apps rules the schema can't
+// express. Errors are reported against the template lines that produced
+// them when template is given, otherwise against the rendered lines.
+func validateContainerApp(source string, template, content []byte) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return fmt.Errorf("%s: rendered output is not valid YAML: %w", source, err)
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: rendered output is not a YAML mapping", source)
+	}
+	doc := root.Content[0]
+
+	var problems []validationError
+	containerAppSchema.validate(doc, "", &problems)
+	problems = append(problems, checkContainerAppRules(doc)...)
+	if len(problems) == 0 {
+		return nil
+	}
+
+	var lines []int
+	if template != nil {
+		lines = templateLineMap(template, content)
+	}
+
+	errs := make([]error, 0, len(problems))
+	for _, problem := range problems {
+		errs = append(errs, fmt.Errorf("%s: %s: %s", problemLocation(source, lines, problem.line), strings.TrimPrefix(problem.path, "."), problem.message))
+	}
+	return errors.Join(errs...)
+}
+
+// problemLocation names the template line a problem on the given rendered
+// line came from, or the rendered line when it can't be traced. Without a
+// template line map the rendered line is the template line.
+func problemLocation(source string, lines []int, line int) string {
+	if lines == nil {
+		return fmt.Sprintf("%s:%d", source, line)
+	}
+	if line >= 1 && line <= len(lines) && lines[line-1] > 0 {
+		return fmt.Sprintf("%s:%d", source, lines[line-1])
+	}
+	return fmt.Sprintf("%s (rendered line %d)", source, line)
+}
+
+// validate checks node against s, appending any problems found
+func (s *schema) validate(node *yaml.Node, path string, problems *[]validationError) {
+	node = resolveAlias(node)
+	report := func(format string, args ...any) {
+		*problems = append(*problems, validationError{line: node.Line, path: path, message: fmt.Sprintf(format, args...)})
+	}
+
+	if !s.matchesType(node) {
+		report("expected %s, got %s", s.Type, describeNode(node))
+		return
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		for _, key := range s.Required {
+			if mappingValue(node, key) == nil {
+				report("missing required property %s", key)
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if property, ok := s.Properties[node.Content[i].Value]; ok {
+				property.validate(node.Content[i+1], path+"."+node.Content[i].Value, problems)
+			}
+		}
+	case yaml.SequenceNode:
+		if len(node.Content) < s.MinItems {
+			report("expected at least %d items, got %d", s.MinItems, len(node.Content))
+		}
+		if s.Items != nil {
+			for i, item := range node.Content {
+				s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), problems)
+			}
+		}
+	case yaml.ScalarNode:
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, node.Value) {
+			report("%q is not one of %s", node.Value, strings.Join(s.Enum, ", "))
+		}
+		if s.Minimum != nil || s.Maximum != nil {
+			value, err := strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+			if s.Minimum != nil && value < *s.Minimum {
+				report("%s is less than the minimum of %g", node.Value, *s.Minimum)
+			}
+			if s.Maximum != nil && value > *s.Maximum {
+				report("%s is greater than the maximum of %g", node.Value, *s.Maximum)
+			}
+		}
+	}
+}
+
+// matchesType reports whether node has the schema's type
+func (s *schema) matchesType(node *yaml.Node) bool {
+	switch s.Type {
+	case "":
+		return true
+	case "object":
+		return node.Kind == yaml.MappingNode
+	case "array":
+		return node.Kind == yaml.SequenceNode
+	case "string":
+		return node.Kind == yaml.ScalarNode && node.ShortTag() == "!!str"
+	case "integer":
+		return node.Kind == yaml.ScalarNode && node.ShortTag() == "!!int"
+	case "number":
+		return node.Kind == yaml.ScalarNode && (node.ShortTag() == "!!int" || node.ShortTag() == "!!float")
+	case "boolean":
+		return node.Kind == yaml.ScalarNode && node.ShortTag() == "!!bool"
+	default:
+		return false
+	}
+}
+
+// describeNode names the type of a YAML node for error messages
+func describeNode(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+	switch node.ShortTag() {
+	case "!!str":
+		return fmt.Sprintf("string %q", node.Value)
+	case "!!int":
+		return "integer " + node.Value
+	case "!!float":
+		return "number " + node.Value
+	case "!!bool":
+		return "boolean " + node.Value
+	case "!!null":
+		return "null"
+	default:
+		return node.ShortTag()
+	}
+}
+
+// checkContainerAppRules checks the rules that span several properties:
+// cpu and memory pairs, scale bounds and probe ports
+func checkContainerAppRules(doc *yaml.Node) []validationError {
+	var problems []validationError
+	properties := mappingValue(doc, "properties")
+	template := mappingValue(properties, "template")
+
+	if containers := mappingValue(template, "containers"); containers != nil && containers.Kind == yaml.SequenceNode {
+		for i, container := range containers.Content {
+			path := fmt.Sprintf(".properties.template.containers[%d]", i)
+			resources := mappingValue(container, "resources")
+			if problem, ok := checkResources(resources, path+".resources"); !ok {
+				problems = append(problems, problem)
+			}
+		}
+		if len(containers.Content) == 1 {
+			ingress := mappingValue(mappingValue(properties, "configuration"), "ingress")
+			problems = append(problems, checkProbePorts(ingress, containers.Content[0], ".properties.template.containers[0]")...)
+		}
+	}
+
+	scale := mappingValue(template, "scale")
+	minReplicas, maxReplicas := mappingValue(scale, "minReplicas"), mappingValue(scale, "maxReplicas")
+	if minReplicas != nil && maxReplicas != nil {
+		min, minErr := strconv.Atoi(minReplicas.Value)
+		max, maxErr := strconv.Atoi(maxReplicas.Value)
+		if minErr == nil && maxErr == nil && min > max {
+			problems = append(problems, validationError{
+				line:    minReplicas.Line,
+				path:    ".properties.template.scale",
+				message: fmt.Sprintf("minReplicas %d is greater than maxReplicas %d", min, max),
+			})
+		}
+	}
+
+	return problems
+}
+
+// checkProbePorts checks that the HTTP probes of an app's only container
+// target the ingress targetPort. Probes reach the container directly, so a
+// collector may serve them from a separate telemetry port; apps declaring
+// additionalPortMappings listen on several ports and aren't checked.
+func checkProbePorts(ingress, container *yaml.Node, path string) []validationError {
+	targetPort := mappingValue(ingress, "targetPort")
+	if targetPort == nil || mappingValue(ingress, "additionalPortMappings") != nil {
+		return nil
+	}
+	probes := mappingValue(container, "probes")
+	if probes == nil || probes.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	var problems []validationError
+	for i, probe := range probes.Content {
+		port := mappingValue(mappingValue(probe, "httpGet"), "port")
+		if port == nil || port.Value == targetPort.Value {
+			continue
+		}
+		problems = append(problems, validationError{
+			line:    port.Line,
+			path:    fmt.Sprintf("%s.probes[%d].httpGet.port", path, i),
+			message: fmt.Sprintf("probe port %s doesn't match ingress targetPort %s", port.Value, targetPort.Value),
+		})
+	}
+	return problems
+}
+
+// memoryPattern matches a Container Apps memory size in Gi
+var memoryPattern = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)Gi$`)
+
+// checkResources checks that cpu and memory are a combination Container Apps
+// allows: cpu in steps of 0.25 from 0.25 to 4 with 2Gi of memory per cpu
+func checkResources(resources *yaml.Node, path string) (validationError, bool) {
+	cpuNode, memoryNode := mappingValue(resources, "cpu"), mappingValue(resources, "memory")
+	if cpuNode == nil || memoryNode == nil {
+		// Reported by the schema
+		return validationError{}, true
+	}
+	cpu, err := strconv.ParseFloat(cpuNode.Value, 64)
+	if err != nil {
+		return validationError{}, true
+	}
+
+	if cpu < 0.25 || cpu > 4 || math.Mod(cpu, 0.25) != 0 {
+		return validationError{
+			line:    cpuNode.Line,
+			path:    path + ".cpu",
+			message: fmt.Sprintf("cpu %s is not allowed by Container Apps; use 0.25 to 4 in steps of 0.25", cpuNode.Value),
+		}, false
+	}
+
+	want := strconv.FormatFloat(cpu*2, 'f', -1, 64) + "Gi"
+	match := memoryPattern.FindStringSubmatch(memoryNode.Value)
+	if match != nil {
+		if memory, err := strconv.ParseFloat(match[1], 64); err == nil && memory == cpu*2 {
+			return validationError{}, true
+		}
+	}
+	return validationError{
+		line:    memoryNode.Line,
+		path:    path + ".memory",
+		message: fmt.Sprintf("cpu %s with memory %s is not a combination allowed by Container Apps; use memory %s", cpuNode.Value, memoryNode.Value, want),
+	}, false
+}
+
+// templateActionPattern matches a template action such as {{.PostgresHost}}
+var templateActionPattern = regexp.MustCompile(`\{\{.*?\}\}`)
+
+// templateLineMap maps each line of rendered content to the template line
+// that produced it, or 0 when it can't be traced. Lines are matched in order,
+// with template actions matching any text; lines holding only actions, such
+// as {{- if}} and {{- end}}, and branches not taken produce no output and are
+// skipped.
+func templateLineMap(template, content []byte) []int {
+	type templateLine struct {
+		number  int
+		pattern *regexp.Regexp
+	}
+	var templateLines []templateLine
+	for i, line := range strings.Split(string(template), "\n") {
+		line = strings.TrimRight(strings.TrimSuffix(strings.TrimRight(line, " \t\r"), requiredMarker), " \t")
+		if strings.TrimSpace(templateActionPattern.ReplaceAllString(line, "")) == "" && strings.Contains(line, "{{") {
+			continue
+		}
+		var pattern strings.Builder
+		pattern.WriteString("^")
+		last := 0
+		for _, match := range templateActionPattern.FindAllStringIndex(line, -1) {
+			pattern.WriteString(regexp.QuoteMeta(line[last:match[0]]))
+			pattern.WriteString(".*")
+			last = match[1]
+		}
+		pattern.WriteString(regexp.QuoteMeta(line[last:]))
+		pattern.WriteString("$")
+		templateLines = append(templateLines, templateLine{number: i + 1, pattern: regexp.MustCompile(pattern.String())})
+	}
+
+	renderedLines := strings.Split(string(content), "\n")
+	lines := make([]int, len(renderedLines))
+	next := 0
+	for i, rendered := range renderedLines {
+		for j := next; j < len(templateLines); j++ {
+			if templateLines[j].pattern.MatchString(rendered) {
+				lines[i] = templateLines[j].number
+				next = j + 1
+				break
+			}
+		}
+	}
+	return lines
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// validContainerApp is a minimal container app template that passes
+// validation
+const validContainerApp = `name: test
+type: Microsoft.App/containerApps
+properties:
+  managedEnvironmentId: {{.ACAEnvironmentID}}
+  configuration:
+    ingress:
+      targetPort: 8080
+  template:
+    containers:
+      - name: test
+        image: test:latest
+        resources:
+          cpu: 0.5
+          memory: 1Gi
+        env:
+          - name: HOST
+            value: {{.PostgresHost}}
+        probes:
+          - type: liveness
+            httpGet:
+              path: /health
+              port: 8080
+    scale:
+      minReplicas: 1
+      maxReplicas: 2
+`
+
+func TestValidateContainerApp(t *testing.T) {
+	testCases := map[string]struct {
+		replace, with string
+		want          []string
+	}{
+		"valid": {},
+		"cpu and memory": {
+			replace: "memory: 1Gi",
+			with:    "memory: 2Gi",
+			want:    []string{"test.yaml:14: properties.template.containers[0].resources.memory: cpu 0.5 with memory 2Gi is not a combination allowed by Container Apps; use memory 1Gi"},
+		},
+		"cpu step": {
+			replace: "cpu: 0.5",
+			with:    "cpu: 0.3",
+			want:    []string{"test.yaml:13: properties.template.containers[0].resources.cpu: cpu 0.3 is not allowed by Container Apps"},
+		},
+		"env value": {
+			replace: "value: {{.PostgresHost}}",
+			with:    "value: 5432",
+			want:    []string{"test.yaml:17: properties.template.containers[0].env[0].value: expected string, got integer 5432"},
+		},
+		"scale bounds": {
+			replace: "minReplicas: 1",
+			with:    "minReplicas: 3",
+			want:    []string{"test.yaml:24: properties.template.scale: minReplicas 3 is greater than maxReplicas 2"},
+		},
+		"scale maximum": {
+			replace: "maxReplicas: 2",
+			with:    "maxReplicas: 2000",
+			want:    []string{"test.yaml:25: properties.template.scale.maxReplicas: 2000 is greater than the maximum of 1000"},
+		},
+		"probe port": {
+			replace: "port: 8080",
+			with:    "port: 9090",
+			want:    []string{"test.yaml:22: properties.template.containers[0].probes[0].httpGet.port: probe port 9090 doesn't match ingress targetPort 8080"},
+		},
+		"tcp probe port": {
+			replace: "httpGet:\n              path: /health\n              port: 8080",
+			with:    "tcpSocket:\n              port: 9090",
+		},
+		"probe port with additional port mappings": {
+			// Multi-port apps such as otelcol serve probes from a telemetry port
+			replace: "targetPort: 8080\n",
+			with:    "targetPort: 8080\n      additionalPortMappings:\n        - targetPort: 4317\n",
+		},
+		"missing property": {
+			replace: "        image: test:latest\n",
+			with:    "",
+			want:    []string{"test.yaml:10: properties.template.containers[0]: missing required property image"},
+		},
+		"multiple problems": {
+			replace: "type: Microsoft.App/containerApps",
+			with:    "type: Microsoft.App/jobs\nlocation: [eastus]",
+			want: []string{
+				`test.yaml:2: type: "Microsoft.App/jobs" is not one of Microsoft.App/containerApps`,
+				"test.yaml:3: location: expected string, got array",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			template := strings.Replace(validContainerApp, tc.replace, tc.with, 1)
+			content := strings.NewReplacer("{{.ACAEnvironmentID}}", "test-env", "{{.PostgresHost}}", "test-host").Replace(template)
+
+			err := validateContainerApp("test.yaml", []byte(template), []byte(content))
+			if len(tc.want) == 0 {
+				if err != nil {
+					t.Errorf("Expected no error but got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Expected error but got none")
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Expected error to contain %q, got: %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateContainerAppInvalidYAML(t *testing.T) {
+	err := validateContainerApp("test.yaml", nil, []byte("name: [test\n"))
+	if err == nil || !strings.Contains(err.Error(), "test.yaml: rendered output is not valid YAML") {
+		t.Errorf("Expected invalid YAML error, got: %v", err)
+	}
+}
+
+func TestTemplateLineMap(t *testing.T) {
+	template := strings.Join([]string{
+		"name: test",                       // 1
+		"secrets:",                         // 2
+		"  - name: license",                // 3
+		"    {{- if .LicenseKeyVaultURI}}", // 4
+		`    keyVaultUrl: "{{.LicenseKeyVaultURI}}"`, // 5
+		"    {{- else}}",                       // 6
+		`    value: "{{.License}}" # required`, // 7
+		"    {{- end}}",                        // 8
+		"host: {{.PostgresHost}}",              // 9
+		"",
+	}, "\n")
+	content := strings.Join([]string{
+		"name: test",
+		"secrets:",
+		"  - name: license",
+		`    value: "test-license"`,
+		"host: test-host",
+		"",
+	}, "\n")
+
+	expected := []int{1, 2, 3, 7, 9, 10}
+	if got := templateLineMap([]byte(template), []byte(content)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("templateLineMap() = %v, want %v", got, expected)
+	}
+}
+
+func TestProblemLocation(t *testing.T) {
+	lines := []int{1, 0, 4}
+	tests := []struct {
+		line     int
+		expected string
+	}{
+		{line: 1, expected: "test.yaml:1"},
+		{line: 2, expected: "test.yaml (rendered line 2)"},
+		{line: 3, expected: "test.yaml:4"},
+		// Document level problems and lines past the map can't be traced
+		{line: 0, expected: "test.yaml (rendered line 0)"},
+		{line: 4, expected: "test.yaml (rendered line 4)"},
+	}
+	for _, tt := range tests {
+		if got := problemLocation("test.yaml", lines, tt.line); got != tt.expected {
+			t.Errorf("problemLocation(%d) = %q, want %q", tt.line, got, tt.expected)
+		}
+	}
+	if got := problemLocation("test.yaml", nil, 7); got != "test.yaml:7" {
+		t.Errorf("Expected the rendered line without a line map, got %q", got)
+	}
+}
+
+func TestRenderTemplateNonStringEnvValues(t *testing.T) {
+	data := iacTestData()
+	data.PostgresUsername = "12345"
+	data.PostgresDatabase = "true"
+	data.AzureNamespace = "on"
+
+	for _, filename := range []string{"bindplane.yaml", "jobs.yaml"} {
+		content, err := renderTemplate(&Config{}, data, filename)
+		if err != nil {
+			t.Fatalf("Failed to render %s: %v", filename, err)
+		}
+		var app struct {
+			Properties struct {
+				Template struct {
+					Containers []struct {
+						Env []struct {
+							Name  string `yaml:"name"`
+							Value any    `yaml:"value"`
+						} `yaml:"env"`
+					} `yaml:"containers"`
+				} `yaml:"template"`
+			} `yaml:"properties"`
+		}
+		if err := yaml.Unmarshal(content, &app); err != nil {
+			t.Fatalf("Failed to parse %s: %v", filename, err)
+		}
+		values := make(map[string]any)
+		for _, env := range app.Properties.Template.Containers[0].Env {
+			values[env.Name] = env.Value
+		}
+		for name, want := range map[string]string{
+			"BINDPLANE_POSTGRES_USERNAME": data.PostgresUsername,
+			"BINDPLANE_POSTGRES_DATABASE": data.PostgresDatabase,
+			"BINDPLANE_AZURE_NAMESPACE":   data.AzureNamespace,
+		} {
+			if values[name] != want {
+				t.Errorf("%s %s = %#v, want the string %q", filename, name, values[name], want)
+			}
+		}
+	}
+}
+
+func TestRenderTemplateValidates(t *testing.T) {
+	dir := t.TempDir()
+	template := strings.Replace(validContainerApp, "memory: 1Gi", "memory: 3Gi", 1)
+	if err := os.WriteFile(filepath.Join(dir, "bindplane.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("Failed to write override: %v", err)
+	}
+
+	_, err := renderTemplate(&Config{TemplatesDir: dir}, iacTestData(), "bindplane.yaml")
+	if err == nil {
+		t.Fatal("Expected error but got none")
+	}
+	want := filepath.Join(dir, "bindplane.yaml") + ":14: properties.template.containers[0].resources.memory"
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("Expected error to contain %q, got: %v", want, err)
+	}
+}